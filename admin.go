@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+)
+
+// adminToken protects the /admin routes, which are disabled while it is empty.
+var adminToken = mustSecret("TODO_ADMIN_TOKEN", "")
+
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			rnd.JSON(w, http.StatusUnauthorized, renderer.M{
+				"message": "Admin token required",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(adminOnly)
+	r.Mount("/backups", backupAdminHandlers())
+	r.Mount("/jobs", adminJobHandlers())
+	r.Get("/maintenance", getMaintenance)
+	r.Put("/maintenance", updateMaintenance)
+	return r
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+const analyticsDayFormat = "2006-01-02"
+
+// completionAnalytics returns completions, creations and finished pomodoros
+// per day or ISO week between ?from= and ?to= (dates, defaulting to the last
+// 30 days) along with the current completion streak and the average time to
+// complete a todo.
+// Buckets are computed in the ?tz= time zone, or the configured one.
+func completionAnalytics(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	granularity := q.Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+	bucketFormat := map[string]string{"day": "%Y-%m-%d", "week": "%G-W%V"}[granularity]
+	if bucketFormat == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "granularity must be day or week",
+		})
+		return
+	}
+	loc, err := requestLocation(r)
+	if err != nil {
+		locationError(w, err)
+		return
+	}
+	tz := loc.String()
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from, to := today.AddDate(0, 0, -29), today.AddDate(0, 0, 1)
+	if v := q.Get("from"); v != "" {
+		if from, err = time.ParseInLocation(analyticsDayFormat, v, loc); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "from must be a YYYY-MM-DD date"})
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.ParseInLocation(analyticsDayFormat, v, loc); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{"message": "to must be a YYYY-MM-DD date"})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	data, err := computeAnalytics(ctx, from, to, today, bucketFormat, tz)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to compute analytics",
+			"error":   err.Error(),
+		})
+		return
+	}
+	data["granularity"] = granularity
+	data["from"] = from.Format(analyticsDayFormat)
+	data["to"] = to.AddDate(0, 0, -1).Format(analyticsDayFormat)
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": data,
+	})
+}
+
+func computeAnalytics(ctx context.Context, from, to, today time.Time, format, tz string) (renderer.M, error) {
+	completions, err := countPerBucket(ctx, collection, "completed_at", from, to, format, tz)
+	if err != nil {
+		return nil, err
+	}
+	creations, err := countPerBucket(ctx, collection, "createdat", from, to, format, tz)
+	if err != nil {
+		return nil, err
+	}
+	streak, err := completionStreak(ctx, today, tz)
+	if err != nil {
+		return nil, err
+	}
+	avg, err := averageTimeToComplete(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	pomodoros, err := countPerBucket(ctx, pomodorosCollection, "completed_at", from, to, format, tz)
+	if err != nil {
+		return nil, err
+	}
+	return renderer.M{
+		"completions":                  completions,
+		"creations":                    creations,
+		"current_streak_days":          streak,
+		"average_time_to_complete_sec": avg,
+		"pomodoros":                    pomodoros,
+	}, nil
+}
+
+func countPerBucket(ctx context.Context, coll *mongo.Collection, field string, from, to time.Time, format, tz string) ([]countBucket, error) {
+	cur, err := coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{field: bson.M{"$gte": from, "$lt": to}}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": format, "date": "$" + field, "timezone": tz}},
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	buckets := []countBucket{}
+	err = cur.All(ctx, &buckets)
+	return buckets, err
+}
+
+// completionStreak counts consecutive days with at least one completion,
+// ending today, or yesterday when nothing has been completed yet today.
+func completionStreak(ctx context.Context, today time.Time, tz string) (int, error) {
+	days, err := countPerBucket(ctx, collection, "completed_at", today.AddDate(-1, 0, 0), today.AddDate(0, 0, 1), "%Y-%m-%d", tz)
+	if err != nil {
+		return 0, err
+	}
+	active := map[string]bool{}
+	for _, d := range days {
+		active[d.Key] = true
+	}
+	day := today
+	if !active[day.Format(analyticsDayFormat)] {
+		day = day.AddDate(0, 0, -1)
+	}
+	streak := 0
+	for active[day.Format(analyticsDayFormat)] {
+		streak++
+		day = day.AddDate(0, 0, -1)
+	}
+	return streak, nil
+}
+
+// averageTimeToComplete returns the mean seconds between creation and
+// completion for todos completed in the range, or nil when there are none.
+func averageTimeToComplete(ctx context.Context, from, to time.Time) (*float64, error) {
+	cur, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"completed_at": bson.M{"$gte": from, "$lt": to}}},
+		bson.M{"$group": bson.M{
+			"_id": nil,
+			"avg": bson.M{"$avg": bson.M{"$subtract": bson.A{"$completed_at", "$createdat"}}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []struct {
+		Avg float64 `bson:"avg"`
+	}
+	if err := cur.All(ctx, &res); err != nil || len(res) == 0 {
+		return nil, err
+	}
+	seconds := res[0].Avg / 1000
+	return &seconds, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// apns sends to iOS devices through the Apple Push Notification service with
+// token-based authentication: TODO_APNS_KEY is the .p8 signing key (usually
+// as a file: reference), TODO_APNS_KEY_ID and TODO_APNS_TEAM_ID identify it
+// and TODO_APNS_TOPIC is the bundle ID of the app. TODO_APNS_SANDBOX=true
+// sends to development builds.
+var apns = loadAPNs()
+
+// apnsTokenLifetime is how long a provider token is reused. Apple rejects
+// tokens older than an hour and throttles refreshes more often than every
+// 20 minutes.
+const apnsTokenLifetime = 40 * time.Minute
+
+type apnsSender struct {
+	key    *ecdsa.PrivateKey
+	keyID  string
+	teamID string
+	topic  string
+	host   string
+
+	mu      sync.Mutex
+	token   string
+	created time.Time
+}
+
+func loadAPNs() *apnsSender {
+	a := &apnsSender{
+		keyID:  env("TODO_APNS_KEY_ID", ""),
+		teamID: env("TODO_APNS_TEAM_ID", ""),
+		topic:  env("TODO_APNS_TOPIC", ""),
+		host:   "https://api.push.apple.com",
+	}
+	if env("TODO_APNS_SANDBOX", "") == "true" {
+		a.host = "https://api.sandbox.push.apple.com"
+	}
+	raw := mustSecret("TODO_APNS_KEY", "")
+	if raw == "" {
+		return a
+	}
+	block, _ := pem.Decode([]byte(raw))
+	if block == nil {
+		log.Fatal("TODO_APNS_KEY: the key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if err != nil || !ok {
+		log.Fatal("TODO_APNS_KEY: the key is not an EC key")
+	}
+	if a.keyID == "" || a.teamID == "" || a.topic == "" {
+		log.Fatal("TODO_APNS_KEY needs TODO_APNS_KEY_ID, TODO_APNS_TEAM_ID and TODO_APNS_TOPIC")
+	}
+	a.key = ecKey
+	return a
+}
+
+func (a *apnsSender) enabled() bool {
+	return a.key != nil
+}
+
+// providerToken returns the ES256 JWT that authenticates requests.
+func (a *apnsSender) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Since(a.created) < apnsTokenLifetime {
+		return a.token, nil
+	}
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": a.keyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": a.teamID, "iat": now.Unix()})
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants r and s as two fixed-size big-endian numbers.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	a.token, a.created = unsigned+"."+enc.EncodeToString(sig), now
+	return a.token, nil
+}
+
+func (a *apnsSender) send(ctx context.Context, token string, m pushMessage) error {
+	jwt, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"aps":  map[string]interface{}{"alert": map[string]string{"title": m.Title, "body": m.Body}, "sound": "default"},
+		"type": m.Type,
+	}
+	if m.TodoID != "" {
+		payload["todo_id"] = m.TodoID
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.host+"/3/device/"+url.PathEscape(token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+jwt)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	if m.CollapseID != "" {
+		req.Header.Set("apns-collapse-id", m.CollapseID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	var out struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(res.Body).Decode(&out)
+	switch {
+	case res.StatusCode == http.StatusGone || out.Reason == "Unregistered":
+		return errDeviceGone
+	case out.Reason == "BadDeviceToken":
+		// Also what a sandbox token gets from the production host, so the
+		// device is kept for the configuration to be fixed.
+		log.Printf("apns: rejected a device token, check TODO_APNS_SANDBOX: %s\n", out.Reason)
+		return permanent(fmt.Errorf("apns: %s: %s", res.Status, out.Reason))
+	case out.Reason == "ExpiredProviderToken":
+		// Retry with a fresh provider token.
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+		return fmt.Errorf("apns: %s: %s", res.Status, out.Reason)
+	}
+	err = fmt.Errorf("apns: %s: %s", res.Status, out.Reason)
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		return err
+	}
+	return permanent(err)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setArchived returns the handler for /archive or /unarchive. Archived todos
+// are kept as they are but left out of lists unless asked for with
+// ?archived=true.
+func setArchived(archived bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		id, err := parseTodoID(chi.URLParam(r, "id"))
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+				"error": err.Error(),
+			}))
+			return
+		}
+		now := time.Now()
+		update := bson.M{"$set": bson.M{"archived": true, "archived_at": now, "updatedat": now}}
+		if !archived {
+			update = bson.M{"$unset": bson.M{"archived": "", "archived_at": ""}, "$set": bson.M{"updatedat": now}}
+		}
+		var t todoModel
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&t)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			rnd.JSON(w, http.StatusNotFound, withMessage(r, "todo_not_found", nil))
+			return
+		}
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "update_failed", renderer.M{
+				"error": err.Error(),
+			}))
+			return
+		}
+		rnd.JSON(w, http.StatusOK, withMessage(r, "updated", renderer.M{
+			"data": newTodo(t),
+		}))
+	}
+}
+
+// archivedFilter hides archived todos unless ?archived=true asks for only
+// those or ?archived=all for everything.
+func archivedFilter(filter bson.M, r *http.Request) {
+	switch r.URL.Query().Get("archived") {
+	case "true":
+		filter["archived"] = true
+	case "all":
+	default:
+		filter["archived"] = bson.M{"$ne": true}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxAutocomplete = 10
+
+// autocomplete returns titles and tags starting with ?q= for type-ahead
+// inputs. Title matches use the anchored title_key index. Encrypted titles
+// only have an HMAC of their key, which has no prefixes, so with field
+// encryption on only tags are suggested; titles is empty and
+// titles_unavailable is set so clients can tell why.
+func autocomplete(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q := titleKey(r.URL.Query().Get("q"))
+	if q == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "q is required",
+		})
+		return
+	}
+	prefix := "^" + regexp.QuoteMeta(q)
+
+	var cur *mongo.Cursor
+	var err error
+	todos := []todoModel{}
+	if !titlesSealed() {
+		cur, err = collection.Find(ctx, bson.M{"title_key": bson.M{"$regex": prefix}},
+			options.Find().SetSort(bson.M{"title_key": 1}).SetLimit(maxAutocomplete).
+				SetProjection(bson.M{"title": 1}))
+		if err == nil {
+			err = cur.All(ctx, &todos)
+		}
+	}
+	tags := []countBucket{}
+	if err == nil {
+		cur, err = collection.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"tags": bson.M{"$regex": prefix, "$options": "i"}}}},
+			{{Key: "$unwind", Value: "$tags"}},
+			{{Key: "$match", Value: bson.M{"tags": bson.M{"$regex": prefix, "$options": "i"}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+			{{Key: "$limit", Value: maxAutocomplete}},
+		})
+		if err == nil {
+			err = cur.All(ctx, &tags)
+		}
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch suggestions",
+			"error":   err.Error(),
+		})
+		return
+	}
+	titles := []renderer.M{}
+	seen := map[string]bool{}
+	for _, t := range todos {
+		if key := strings.ToLower(t.Title); !seen[key] {
+			seen[key] = true
+			titles = append(titles, renderer.M{"todo_id": t.ID.Hex(), "title": t.Title})
+		}
+	}
+	tagNames := []string{}
+	for _, tag := range tags {
+		tagNames = append(tagNames, tag.Key)
+	}
+	res := renderer.M{
+		"titles": titles,
+		"tags":   tagNames,
+	}
+	if titlesSealed() {
+		res["titles_unavailable"] = true
+	}
+	rnd.JSON(w, http.StatusOK, res)
+}
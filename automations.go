@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Automations are actions run on a cron schedule, such as creating a
+// "Weekly review" todo every Friday at 17:00 or archiving todos completed
+// more than 30 days ago. Schedules are read in the time zone of the stored
+// preferences.
+const (
+	automationsCollectionName = "automations"
+	automationPollInterval    = 30 * time.Second
+)
+
+const (
+	actionCreateTodo       = "create_todo"
+	actionArchiveCompleted = "archive_completed"
+)
+
+var automationsCollection *mongo.Collection
+
+type (
+	automation struct {
+		ID       primitive.ObjectID `bson:"_id" json:"_id"`
+		Name     string             `bson:"name" json:"name" validate:"required,max=200"`
+		Schedule string             `bson:"schedule" json:"schedule" validate:"required"`
+		Action   string             `bson:"action" json:"action" validate:"required"`
+		// Todo is the todo create_todo adds.
+		Todo *automationTodo `bson:"todo,omitempty" json:"todo,omitempty"`
+		// OlderThanDays is how long ago archive_completed todos must have
+		// been completed.
+		OlderThanDays int        `bson:"older_than_days,omitempty" json:"older_than_days,omitempty"`
+		Enabled       bool       `bson:"enabled" json:"enabled"`
+		NextRunAt     *time.Time `bson:"next_run_at,omitempty" json:"next_run_at,omitempty"`
+		LastRunAt     *time.Time `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+		LastResult    string     `bson:"last_result,omitempty" json:"last_result,omitempty"`
+		LastError     string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+		CreatedAt     time.Time  `bson:"created_at" json:"created_at"`
+	}
+	automationTodo struct {
+		Title     string   `bson:"title" json:"title"`
+		List      string   `bson:"list,omitempty" json:"list,omitempty"`
+		Tags      []string `bson:"tags,omitempty" json:"tags,omitempty"`
+		DueInDays *int     `bson:"due_in_days,omitempty" json:"due_in_days,omitempty"`
+	}
+)
+
+var automationIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "enabled", Value: 1}, {Key: "next_run_at", Value: 1}}},
+}
+
+// check validates the automation and returns its parsed schedule.
+func (a *automation) check() (*cronSchedule, error) {
+	schedule, err := parseCron(a.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	switch a.Action {
+	case actionCreateTodo:
+		if a.Todo == nil || strings.TrimSpace(a.Todo.Title) == "" {
+			return nil, errors.New("create_todo needs a todo with a title")
+		}
+		if a.Todo.DueInDays != nil && *a.Todo.DueInDays < 0 {
+			return nil, errors.New("due_in_days must not be negative")
+		}
+		if err := checkTodoContent(a.Todo.Title, a.Todo.Tags, nil); err != nil {
+			return nil, fmt.Errorf("todo: %w", err)
+		}
+	case actionArchiveCompleted:
+		if a.OlderThanDays < 0 {
+			return nil, errors.New("older_than_days must not be negative")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q, use %s or %s", a.Action, actionCreateTodo, actionArchiveCompleted)
+	}
+	return schedule, nil
+}
+
+// nextRun returns when the automation is due after now, or nil when the
+// schedule never matches again.
+func nextRun(ctx context.Context, schedule *cronSchedule, now time.Time) (*time.Time, error) {
+	loc, err := preferredLocation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next := schedule.next(now.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// rescheduleAutomations works out next_run_at of every enabled automation
+// again, for when the time zone schedules are read in has changed.
+func rescheduleAutomations() {
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cur, err := automationsCollection.Find(ctx, bson.M{"enabled": true})
+	enabled := []automation{}
+	if err == nil {
+		err = cur.All(ctx, &enabled)
+	}
+	if err != nil {
+		log.Printf("automations: %s\n", err)
+		return
+	}
+	now := time.Now()
+	for _, a := range enabled {
+		schedule, err := a.check()
+		if err != nil {
+			continue
+		}
+		next, err := nextRun(ctx, schedule, now)
+		if err == nil {
+			_, err = automationsCollection.UpdateOne(ctx,
+				bson.M{"_id": a.ID, "next_run_at": a.NextRunAt},
+				bson.M{"$set": bson.M{"next_run_at": next}})
+		}
+		if err != nil {
+			log.Printf("automations: %s: %s\n", a.Name, err)
+		}
+	}
+}
+
+// automationScheduler runs due automations until ctx is done.
+func automationScheduler(ctx context.Context) {
+	ticker := time.NewTicker(automationPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Automations write, so they wait out maintenance.
+			if enabled, _, _ := maintenanceState(); enabled {
+				continue
+			}
+			if err := runDueAutomations(ctx, time.Now()); err != nil {
+				log.Printf("automations: %s\n", err)
+			}
+		}
+	}
+}
+
+func runDueAutomations(ctx context.Context, now time.Time) error {
+	cur, err := automationsCollection.Find(ctx, bson.M{"enabled": true, "next_run_at": bson.M{"$lte": now}})
+	if err != nil {
+		return err
+	}
+	due := []automation{}
+	if err := cur.All(ctx, &due); err != nil {
+		return err
+	}
+	for _, a := range due {
+		schedule, err := a.check()
+		if err != nil {
+			log.Printf("automations: %s: %s\n", a.Name, err)
+			continue
+		}
+		next, err := nextRun(ctx, schedule, now)
+		if err != nil {
+			return err
+		}
+		// Moving next_run_at first makes sure a run that is missed because
+		// of a crash is skipped rather than repeated.
+		res, err := automationsCollection.UpdateOne(ctx,
+			bson.M{"_id": a.ID, "next_run_at": a.NextRunAt},
+			bson.M{"$set": bson.M{"next_run_at": next}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		runAutomation(ctx, a, now)
+	}
+	return nil
+}
+
+// runAutomation performs the action and records the outcome.
+func runAutomation(ctx context.Context, a automation, now time.Time) automation {
+	result, err := performAutomation(ctx, a, now)
+	a.LastRunAt, a.LastResult, a.LastError = &now, result, ""
+	update := bson.M{"$set": bson.M{"last_run_at": now, "last_result": result}, "$unset": bson.M{"last_error": ""}}
+	if err != nil {
+		a.LastResult, a.LastError = "", err.Error()
+		update = bson.M{"$set": bson.M{"last_run_at": now, "last_error": err.Error()}, "$unset": bson.M{"last_result": ""}}
+		log.Printf("automations: %s: %s\n", a.Name, err)
+	}
+	if _, err := automationsCollection.UpdateOne(ctx, bson.M{"_id": a.ID}, update); err != nil {
+		log.Printf("automations: %s\n", err)
+	}
+	return a
+}
+
+func performAutomation(ctx context.Context, a automation, now time.Time) (string, error) {
+	switch a.Action {
+	case actionCreateTodo:
+		t := todoModel{
+			Title:     strings.TrimSpace(a.Todo.Title),
+			List:      strings.TrimSpace(a.Todo.List),
+			Tags:      a.Todo.Tags,
+			CreatedAt: now,
+			UpdatedAt: now,
+			ID:        newTodoID(),
+		}
+		if a.Todo.DueInDays != nil {
+			due := now.AddDate(0, 0, *a.Todo.DueInDays)
+			t.DueAt = &due
+		}
+		if err := insertSeed(ctx, nil, []todoModel{t}); err != nil {
+			return "", err
+		}
+		go todosCreated(t)
+		return "created todo " + t.ID.Hex(), nil
+	case actionArchiveCompleted:
+		res, err := collection.UpdateMany(ctx,
+			bson.M{
+				"iscompleted":  true,
+				"completed_at": bson.M{"$lt": now.AddDate(0, 0, -a.OlderThanDays)},
+				"archived":     bson.M{"$ne": true},
+			},
+			bson.M{"$set": bson.M{"archived": true, "archived_at": now, "updatedat": now}})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("archived %d todos", res.ModifiedCount), nil
+	}
+	return "", fmt.Errorf("unknown action %q", a.Action)
+}
+
+func automationHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listAutomations)
+	r.Post("/", createAutomation)
+	r.Get("/{id}", getAutomation)
+	r.Put("/{id}", updateAutomation)
+	r.Delete("/{id}", deleteAutomation)
+	r.Post("/{id}/run", runAutomationNow)
+	return r
+}
+
+func listAutomations(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := automationsCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	automations := []automation{}
+	if err == nil {
+		err = cur.All(ctx, &automations)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch automations",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": automations,
+	})
+}
+
+// decodeAutomation reads and validates an automation from the request body
+// and works out its next run, writing the error response itself when that
+// fails.
+func decodeAutomation(ctx context.Context, w http.ResponseWriter, r *http.Request) (*automation, bool) {
+	var a automation
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	a.Name = strings.TrimSpace(a.Name)
+	err := validate.Struct(&a)
+	var schedule *cronSchedule
+	if err == nil {
+		schedule, err = a.check()
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid automation",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	a.NextRunAt, a.LastRunAt, a.LastResult, a.LastError = nil, nil, "", ""
+	if a.Enabled {
+		if a.NextRunAt, err = nextRun(ctx, schedule, time.Now()); err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+				"message": "Failed to schedule the automation",
+				"error":   err.Error(),
+			})
+			return nil, false
+		}
+	}
+	return &a, true
+}
+
+func createAutomation(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, ok := decodeAutomation(ctx, w, r)
+	if !ok {
+		return
+	}
+	a.ID = primitive.NewObjectID()
+	a.CreatedAt = time.Now()
+	if _, err := automationsCollection.InsertOne(ctx, a); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Automation creation failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Automation creation successful",
+		"data":    a,
+	})
+}
+
+// findAutomation loads the automation named by the {id} URL parameter,
+// writing the error response itself when that fails.
+func findAutomation(ctx context.Context, w http.ResponseWriter, r *http.Request) (*automation, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	var a automation
+	if err := automationsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&a); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, renderer.M{
+			"message": "Automation not found",
+		})
+		return nil, false
+	}
+	return &a, true
+}
+
+func getAutomation(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if a, ok := findAutomation(ctx, w, r); ok {
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"data": a,
+		})
+	}
+}
+
+// updateAutomation replaces the definition and reschedules it. The record
+// of the last run is kept.
+func updateAutomation(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	existing, ok := findAutomation(ctx, w, r)
+	if !ok {
+		return
+	}
+	a, ok := decodeAutomation(ctx, w, r)
+	if !ok {
+		return
+	}
+	a.ID, a.CreatedAt = existing.ID, existing.CreatedAt
+	a.LastRunAt, a.LastResult, a.LastError = existing.LastRunAt, existing.LastResult, existing.LastError
+	if _, err := automationsCollection.ReplaceOne(ctx, bson.M{"_id": a.ID}, a); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Automation update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Automation update successful",
+		"data":    a,
+	})
+}
+
+func deleteAutomation(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, ok := findAutomation(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := automationsCollection.DeleteOne(ctx, bson.M{"_id": a.ID}); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Error deleting the automation",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":       "Automation deletion successful",
+		"automation_id": a.ID.Hex(),
+	})
+}
+
+// runAutomationNow runs the automation once without changing its schedule,
+// to try it out.
+func runAutomationNow(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	a, ok := findAutomation(ctx, w, r)
+	if !ok {
+		return
+	}
+	ran := runAutomation(ctx, *a, time.Now())
+	status := http.StatusOK
+	if ran.LastError != "" {
+		status = http.StatusInternalServerError
+	}
+	rnd.JSON(w, status, renderer.M{
+		"data": ran,
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backupCollections lists every collection included in backups.
+var backupCollections = []string{
+	collectionName,
+	filtersCollectionName,
+	notificationsCollectionName,
+	customFieldsCollectionName,
+	listsCollectionName,
+	timeEntriesCollectionName,
+	pomodorosCollectionName,
+	settingsCollectionName,
+	historyCollectionName,
+	automationsCollectionName,
+	rulesCollectionName,
+	devicesCollectionName,
+}
+
+// backupRecord is one line of a backup archive: a gzip-compressed stream of
+// newline-delimited records holding canonical extended JSON documents.
+type backupRecord struct {
+	Collection string          `json:"collection"`
+	Document   json.RawMessage `json:"document"`
+}
+
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("out", "", "file path, s3://bucket/key or - for stdout")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("backup: --out is required")
+	}
+	ctx := context.Background()
+	if *out == "-" {
+		return writeBackup(ctx, os.Stdout)
+	}
+	if strings.HasPrefix(*out, "s3://") {
+		size, err := uploadBackup(ctx, *out, writeBackup)
+		if err == nil {
+			log.Printf("Backup of %d bytes written to %s\n", size, *out)
+		}
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := writeBackup(ctx, f); err != nil {
+		f.Close()
+		return err
+	}
+	log.Printf("Backup written to %s\n", *out)
+	return f.Close()
+}
+
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "file path, s3://bucket/key or - for stdin")
+	drop := fs.Bool("drop", false, "drop the collections before restoring")
+	fs.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("restore: --in is required")
+	}
+	var r io.ReadCloser
+	switch {
+	case *in == "-":
+		r = os.Stdin
+	case strings.HasPrefix(*in, "s3://"):
+		s3, err := newS3Client()
+		if err != nil {
+			return err
+		}
+		bucket, key, err := parseS3URL(*in)
+		if err != nil {
+			return err
+		}
+		if r, err = s3.Get(bucket, key); err != nil {
+			return err
+		}
+	default:
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		r = f
+	}
+	defer r.Close()
+	n, err := restoreBackup(context.Background(), r, *drop)
+	if err == nil {
+		log.Printf("Restored %d documents from %s\n", n, *in)
+	}
+	return err
+}
+
+// uploadBackup spools the archive produced by write to a temporary file, since
+// S3 needs the content length up front, and uploads it to dest.
+func uploadBackup(ctx context.Context, dest string, write func(context.Context, io.Writer) error) (int64, error) {
+	s3, err := newS3Client()
+	if err != nil {
+		return 0, err
+	}
+	bucket, key, err := parseS3URL(dest)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp("", "todo-backup-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := write(ctx, tmp); err != nil {
+		return 0, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, s3.Put(bucket, key, tmp, size)
+}
+
+func writeBackup(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	db := collection.Database()
+	for _, name := range backupCollections {
+		cur, err := db.Collection(name).Find(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		for cur.Next(ctx) {
+			doc, err := bson.MarshalExtJSON(cur.Current, true, false)
+			if err != nil {
+				cur.Close(ctx)
+				return err
+			}
+			if err := enc.Encode(backupRecord{Collection: name, Document: doc}); err != nil {
+				cur.Close(ctx)
+				return err
+			}
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+// readBackup decodes the whole archive in r into the documents of each
+// collection, so a truncated or corrupt archive is noticed before anything
+// is written.
+func readBackup(r io.Reader) (map[string][]bson.D, error) {
+	archive, err := openBackupArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	docs := map[string][]bson.D{}
+	br := bufio.NewReader(gz)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec backupRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, err
+			}
+			if !containsString(backupCollections, rec.Collection) {
+				return nil, fmt.Errorf("backup: unknown collection %q", rec.Collection)
+			}
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(rec.Document, true, &doc); err != nil {
+				return nil, err
+			}
+			if backupID(doc) == nil {
+				return nil, fmt.Errorf("backup: document in %s has no _id", rec.Collection)
+			}
+			docs[rec.Collection] = append(docs[rec.Collection], doc)
+		}
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func backupID(doc bson.D) interface{} {
+	for _, e := range doc {
+		if e.Key == "_id" {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// restoreBackup upserts every document of the archive by _id, so restoring
+// the same archive twice is harmless. The archive is read in full first, so
+// with drop the collections are only dropped once it is known to be whole.
+// Sync clients have to start over.
+func restoreBackup(ctx context.Context, r io.Reader, drop bool) (int, error) {
+	docs, err := readBackup(r)
+	if err != nil {
+		return 0, err
+	}
+	db := collection.Database()
+	if drop {
+		for _, name := range backupCollections {
+			if err := db.Collection(name).Drop(ctx); err != nil {
+				return 0, err
+			}
+		}
+	}
+	restored := 0
+	for _, name := range backupCollections {
+		for i := 0; i < len(docs[name]); i += 500 {
+			batch := docs[name][i:min(i+500, len(docs[name]))]
+			models := make([]mongo.WriteModel, 0, len(batch))
+			for _, doc := range batch {
+				models = append(models, mongo.NewReplaceOneModel().
+					SetFilter(bson.M{"_id": backupID(doc)}).SetReplacement(doc).SetUpsert(true))
+			}
+			res, err := db.Collection(name).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+			if err != nil {
+				return restored, err
+			}
+			restored += int(res.UpsertedCount + res.MatchedCount)
+		}
+	}
+	// Clients cannot tell from the tombstones what the restore removed.
+	return restored, resetSync(ctx)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted backups are the plain archive cut into chunks sealed with
+// AES-256-GCM. Each chunk is stored as a 4-byte length, a random nonce and the
+// ciphertext; its index and a final-chunk flag are authenticated as additional
+// data so chunks cannot be reordered, dropped or truncated unnoticed.
+const (
+	backupMagic     = "TODOBAK1"
+	backupChunkSize = 64 << 10
+)
+
+var errBackupTruncated = errors.New("encrypted backup is truncated")
+
+func backupKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("backup key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("backup key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+type encryptingWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+func newEncryptingWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, backupMagic); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, buf: make([]byte, 0, backupChunkSize)}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		if len(e.buf) == cap(e.buf) && len(p) > 0 {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the remaining data as the final chunk. It does not close the
+// underlying writer.
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptingWriter) seal(last bool) error {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := e.aead.Seal(nonce, nonce, e.buf, chunkAAD(e.index, last))
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func chunkAAD(index uint64, last bool) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint64(aad, index)
+	if last {
+		aad[8] = 1
+	}
+	return aad
+}
+
+type decryptingReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	buf   bytes.Buffer
+	index uint64
+	done  bool
+}
+
+// newDecryptingReader returns a reader over the plain archive. The magic
+// header must already have been consumed.
+func newDecryptingReader(r io.Reader, key []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{r: r, aead: aead}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	return d.buf.Read(p)
+}
+
+func (d *decryptingReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return errBackupTruncated
+	}
+	sealed := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return errBackupTruncated
+	}
+	nonceSize := d.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return errBackupTruncated
+	}
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
+	plain, err := d.aead.Open(nil, nonce, ciphertext, chunkAAD(d.index, false))
+	if err != nil {
+		plain, err = d.aead.Open(nil, nonce, ciphertext, chunkAAD(d.index, true))
+		if err != nil {
+			return errors.New("backup decryption failed: wrong key or corrupted file")
+		}
+		d.done = true
+	}
+	d.index++
+	d.buf.Write(plain)
+	return nil
+}
+
+// openBackupArchive returns a reader over the gzip archive in r, decrypting it
+// with TODO_BACKUP_KEY when the file is an encrypted backup.
+func openBackupArchive(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(backupMagic))
+	if err != nil || string(head) != backupMagic {
+		return br, nil
+	}
+	br.Discard(len(backupMagic))
+	if backupSettings.key == "" {
+		return nil, errors.New("backup is encrypted but TODO_BACKUP_KEY is not set")
+	}
+	key, err := backupKey(backupSettings.key)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptingReader(br, key)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const backupTimeFormat = "20060102T150405Z"
+
+var backupSettings = struct {
+	interval string
+	target   string
+	key      string
+	retain   string
+}{
+	interval: env("TODO_BACKUP_INTERVAL", ""),
+	target:   env("TODO_BACKUP_TARGET", "./backups"),
+	key:      mustSecret("TODO_BACKUP_KEY", ""),
+	retain:   env("TODO_BACKUP_RETAIN", "7"),
+}
+
+// backupMu keeps scheduled and manually triggered backups from overlapping.
+var backupMu sync.Mutex
+
+type backupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupStore is where scheduled backups are kept: a local directory, or an S3
+// prefix when TODO_BACKUP_TARGET is an s3:// URL.
+type backupStore interface {
+	Save(ctx context.Context, name string, write func(context.Context, io.Writer) error) error
+	List() ([]backupInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+func newBackupStore() (backupStore, error) {
+	if strings.HasPrefix(backupSettings.target, "s3://") {
+		bucket, prefix, err := parseS3URL(backupSettings.target)
+		if err != nil {
+			return nil, err
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return &s3BackupStore{bucket: bucket, prefix: prefix}, nil
+	}
+	if err := os.MkdirAll(backupSettings.target, 0o700); err != nil {
+		return nil, err
+	}
+	return localBackupStore(backupSettings.target), nil
+}
+
+type localBackupStore string
+
+func (dir localBackupStore) Save(ctx context.Context, name string, write func(context.Context, io.Writer) error) error {
+	path := filepath.Join(string(dir), name)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := write(ctx, f); err != nil {
+		f.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (dir localBackupStore) List() ([]backupInfo, error) {
+	entries, err := os.ReadDir(string(dir))
+	if err != nil {
+		return nil, err
+	}
+	backups := []backupInfo{}
+	for _, entry := range entries {
+		created, ok := backupTime(entry.Name())
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backupInfo{Name: entry.Name(), Size: info.Size(), CreatedAt: created})
+	}
+	return backups, nil
+}
+
+func (dir localBackupStore) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(dir), name))
+}
+
+func (dir localBackupStore) Delete(name string) error {
+	return os.Remove(filepath.Join(string(dir), name))
+}
+
+type s3BackupStore struct {
+	bucket, prefix string
+}
+
+func (s *s3BackupStore) Save(ctx context.Context, name string, write func(context.Context, io.Writer) error) error {
+	_, err := uploadBackup(ctx, "s3://"+s.bucket+"/"+s.prefix+name, write)
+	return err
+}
+
+func (s *s3BackupStore) List() ([]backupInfo, error) {
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := client.List(s.bucket, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	backups := []backupInfo{}
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, s.prefix)
+		if created, ok := backupTime(name); ok {
+			backups = append(backups, backupInfo{Name: name, Size: obj.Size, CreatedAt: created})
+		}
+	}
+	return backups, nil
+}
+
+func (s *s3BackupStore) Open(name string) (io.ReadCloser, error) {
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(s.bucket, s.prefix+name)
+}
+
+func (s *s3BackupStore) Delete(name string) error {
+	client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	return client.Delete(s.bucket, s.prefix+name)
+}
+
+// backupTime parses the timestamp out of a backup name, which doubles as the
+// check that a file is one of ours.
+func backupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, "todo-") || !strings.HasSuffix(name, ".bak") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, "todo-"), ".bak"))
+	return t, err == nil
+}
+
+// runScheduledBackup writes an encrypted backup to the configured store and
+// then deletes the oldest backups beyond TODO_BACKUP_RETAIN.
+func runScheduledBackup(ctx context.Context) (string, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	if backupSettings.key == "" {
+		return "", errors.New("TODO_BACKUP_KEY is required for scheduled backups")
+	}
+	key, err := backupKey(backupSettings.key)
+	if err != nil {
+		return "", err
+	}
+	store, err := newBackupStore()
+	if err != nil {
+		return "", err
+	}
+	name := "todo-" + time.Now().UTC().Format(backupTimeFormat) + ".bak"
+	err = store.Save(ctx, name, func(ctx context.Context, w io.Writer) error {
+		ew, err := newEncryptingWriter(w, key)
+		if err != nil {
+			return err
+		}
+		if err := writeBackup(ctx, ew); err != nil {
+			return err
+		}
+		return ew.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	retain, err := strconv.Atoi(backupSettings.retain)
+	if err != nil || retain < 1 {
+		return name, fmt.Errorf("invalid TODO_BACKUP_RETAIN %q", backupSettings.retain)
+	}
+	backups, err := store.List()
+	if err != nil {
+		return name, err
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	for _, old := range backups[min(retain, len(backups)):] {
+		if err := store.Delete(old.Name); err != nil {
+			return name, err
+		}
+	}
+	return name, nil
+}
+
+// backupScheduler runs a backup every TODO_BACKUP_INTERVAL until ctx is done.
+// It does nothing when no interval is configured.
+func backupScheduler(ctx context.Context) {
+	if backupSettings.interval == "" {
+		return
+	}
+	interval, err := time.ParseDuration(backupSettings.interval)
+	if err != nil || interval <= 0 {
+		log.Printf("backup: invalid TODO_BACKUP_INTERVAL %q\n", backupSettings.interval)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			name, err := runScheduledBackup(ctx)
+			if err != nil {
+				log.Printf("backup: %s\n", err)
+				continue
+			}
+			log.Printf("backup: wrote %s\n", name)
+		}
+	}
+}
+
+func backupAdminHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listBackups)
+	r.With(longRunning).Post("/", triggerBackup)
+	r.Get("/{name}", downloadBackup)
+	return r
+}
+
+func listBackups(w http.ResponseWriter, r *http.Request) {
+	store, err := newBackupStore()
+	var backups []backupInfo
+	if err == nil {
+		backups, err = store.List()
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to list backups",
+			"error":   err.Error(),
+		})
+		return
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": backups,
+	})
+}
+
+// triggerBackup takes a backup now, or queues one with ?async=true.
+func triggerBackup(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if wantsAsync(r) {
+		j, err := enqueueJob(ctx, "backup", nil)
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+				"message": "Backup failed",
+				"error":   err.Error(),
+			})
+			return
+		}
+		acceptedJob(w, j)
+		return
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	name, err := runScheduledBackup(ctx)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Backup failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Backup successful",
+		"name":    name,
+	})
+}
+
+func runBackupJob(ctx context.Context, _ bson.Raw) (interface{}, error) {
+	name, err := runScheduledBackup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"name": name}, nil
+}
+
+func downloadBackup(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if _, ok := backupTime(name); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	store, err := newBackupStore()
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to open backup",
+			"error":   err.Error(),
+		})
+		return
+	}
+	f, err := store.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("backup download: %s\n", err)
+	}
+}
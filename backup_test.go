@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func gzipLines(lines ...string) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	for _, l := range lines {
+		gz.Write([]byte(l + "\n"))
+	}
+	gz.Close()
+	return b.Bytes()
+}
+
+func TestReadBackup(t *testing.T) {
+	good := `{"collection":"todo","document":{"_id":{"$oid":"64b7f0a1c2d3e4f5a6b7c8d9"},"title":"a"}}`
+	archive := gzipLines(good, good)
+	docs, err := readBackup(bytes.NewReader(archive))
+	if err != nil || len(docs[collectionName]) != 2 {
+		t.Fatalf("readBackup = %v, %v, want two todos", docs, err)
+	}
+	for name, data := range map[string][]byte{
+		"truncated":          archive[:len(archive)-10],
+		"unknown collection": gzipLines(`{"collection":"nope","document":{"_id":1}}`),
+		"missing _id":        gzipLines(`{"collection":"todo","document":{"title":"a"}}`),
+		"bad record":         gzipLines(good, `{"collection":`),
+	} {
+		if _, err := readBackup(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: readBackup succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// maxRequestBody bounds every request body. Handlers that decode JSON stop
+// reading there, and the ones that read the whole body into memory answer
+// 413 with readFailed.
+const maxRequestBody = 32 << 20
+
+func limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readFailed answers a request whose body could not be read.
+func readFailed(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge,
+			"The request body is larger than "+strconv.Itoa(maxRequestBody)+" bytes", nil)
+		return
+	}
+	rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+		"error": err.Error(),
+	}))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxCalendarDays = 366
+
+type calendarDay struct {
+	Date  string `json:"date"`
+	Todos []todo `json:"todos"`
+}
+
+// todoCalendar returns the todos due between ?from= and ?to= (inclusive
+// YYYY-MM-DD dates in the ?tz= time zone) grouped by due day, so clients can
+// render a month or week without fetching every todo. Days without todos are
+// left out.
+func todoCalendar(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	loc, err := requestLocation(r)
+	if err != nil {
+		locationError(w, err)
+		return
+	}
+	tz := loc.String()
+	from, fromErr := time.ParseInLocation(analyticsDayFormat, q.Get("from"), loc)
+	to, toErr := time.ParseInLocation(analyticsDayFormat, q.Get("to"), loc)
+	if fromErr != nil || toErr != nil || to.Before(from) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "from and to must be YYYY-MM-DD dates with from <= to",
+		})
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+	if to.Sub(from) > maxCalendarDays*24*time.Hour {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "The calendar range is limited to one year",
+		})
+		return
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	filter["due_at"] = bson.M{"$gte": from, "$lt": to}
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "due_at", Value: 1}}))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	days := []calendarDay{}
+	for _, t := range todos {
+		date := t.DueAt.In(loc).Format(analyticsDayFormat)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, calendarDay{Date: date, Todos: []todo{}})
+		}
+		days[len(days)-1].Todos = append(days[len(days)-1].Todos, newTodo(t))
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"from": from.Format(analyticsDayFormat),
+		"to":   to.AddDate(0, 0, -1).Format(analyticsDayFormat),
+		"tz":   tz,
+		"data": days,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// cascadeChecklist completes a todo's subtasks along with the todo when
+// cascade is set, which requests ask for with ?cascade=true. Subtasks are
+// the checklist items (suggest-subtasks fills them in) and live in the
+// todo's own document, so the cascade is part of the same atomic update.
+// Items sent in the request are completed in place; otherwise the stored
+// ones are. It returns the fields to $set and how many items were still
+// open.
+func cascadeChecklist(cascade bool, status string, stored, provided []checklistItem) (bson.D, int) {
+	if !cascade {
+		return nil, 0
+	}
+	if s := workflow.status(status); s == nil || !s.Done {
+		return nil, 0
+	}
+	items := stored
+	if provided != nil {
+		items = provided
+	}
+	open := 0
+	for i := range items {
+		if !items[i].IsCompleted {
+			open++
+		}
+		if provided != nil {
+			items[i].IsCompleted = true
+		}
+	}
+	if provided != nil || open == 0 {
+		return nil, open
+	}
+	return bson.D{{Key: "checklist.$[].is_completed", Value: true}}, open
+}
+
+func cascadeRequested(r *http.Request) bool {
+	return r.URL.Query().Get("cascade") == "true"
+}
+
+// completionUpdate returns the fields to $set when a todo moves to status,
+// with its checklist completed as cascadeChecklist does. Every path that
+// changes the status of an existing todo through the workflow uses it.
+func completionUpdate(status string, now time.Time, cascade bool, stored, provided []checklistItem) (bson.D, int) {
+	fields, open := cascadeChecklist(cascade, status, stored, provided)
+	return append(statusUpdate(status, now), fields...), open
+}
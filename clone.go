@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// cloneTodo copies a todo as a new open todo at the end of the list. The
+// checklist is copied unchecked unless ?checklist=false is given. Todos have
+// no subtasks, so there is nothing else to copy.
+func cloneTodo(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	var t todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, withMessage(r, "todo_not_found", nil))
+		return
+	}
+	sortKeys, err := nextSortKeys(ctx, 1)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "clone_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+
+	t = cloneFields(t, sortKeys[0], r.URL.Query().Get("checklist") != "false")
+	if _, err := collection.InsertOne(ctx, t); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "clone_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	go todosCreated(t)
+	rnd.JSON(w, http.StatusCreated, withMessage(r, "cloned", renderer.M{
+		"todo_id": t.ID.Hex(),
+	}))
+}
+
+// cloneFields builds the clone of t. Only the fields listed here are
+// copied; progress, history and scheduler state such as tracked time,
+// snoozes and escalations stay with the original. The title is the opened
+// one, which MarshalBSON seals for the new ID.
+func cloneFields(t todoModel, sortKey string, checklist bool) todoModel {
+	now := time.Now()
+	c := todoModel{
+		ID:           newTodoID(),
+		IsCompleted:  false,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Status:       workflow.Initial,
+		SortKey:      sortKey,
+		List:         t.List,
+		Tags:         t.Tags,
+		ReminderAt:   t.ReminderAt,
+		DueAt:        t.DueAt,
+		CustomFields: t.CustomFields,
+		Starred:      t.Starred,
+		Color:        t.Color,
+		Estimated:    t.Estimated,
+		Remaining:    t.Estimated,
+		BlockedBy:    t.BlockedBy,
+		Blocked:      t.Blocked,
+		Location:     t.Location,
+		Title:        t.Title,
+		TitleKey:     storedTitleKey(t.Title),
+		TitleGrams:   titleGrams(t.Title),
+	}
+	if checklist {
+		for _, item := range t.Checklist {
+			item.IsCompleted = false
+			c.Checklist = append(c.Checklist, item)
+		}
+	}
+	return c
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// withFieldKeys turns on title encryption for the test.
+func withFieldKeys(t *testing.T) {
+	t.Helper()
+	keys, err := parseFieldKeys("k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedKeys, savedIndex := fieldKeys, indexKey
+	fieldKeys, indexKey = keys, make([]byte, 32)
+	t.Cleanup(func() { fieldKeys, indexKey = savedKeys, savedIndex })
+}
+
+func TestCloneSealsTitleOnce(t *testing.T) {
+	withFieldKeys(t)
+	original := todoModel{ID: newTodoID(), Title: "Water the plants"}
+	data, err := bson.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored todoModel
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = bson.Marshal(cloneFields(stored, "a0", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Title string `bson:"title"`
+	}
+	if err := bson.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw.Title, sealedPrefix) {
+		t.Fatalf("clone title stored as %q, want it sealed", raw.Title)
+	}
+	var clone todoModel
+	if err := bson.Unmarshal(data, &clone); err != nil {
+		t.Fatal(err)
+	}
+	if clone.Title != original.Title {
+		t.Errorf("clone title = %q, want %q", clone.Title, original.Title)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const listsCollectionName = "lists"
+
+var (
+	listsCollection *mongo.Collection
+	hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-f]{3}|[0-9a-f]{6})$`)
+	colorPalette    = []string{"red", "orange", "yellow", "green", "teal", "blue", "purple", "pink", "gray"}
+)
+
+// listSettings holds what is stored about a list beyond its name, which is
+// still the value kept on each todo.
+type listSettings struct {
+	Name      string    `bson:"name" json:"name"`
+	Color     string    `bson:"color" json:"color,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// normalizeColor accepts a #rgb or #rrggbb hex value or a palette name and
+// returns it in lower case. An empty color is valid and means none.
+func normalizeColor(color string) (string, bool) {
+	color = strings.ToLower(strings.TrimSpace(color))
+	if color == "" || hexColorPattern.MatchString(color) {
+		return color, true
+	}
+	for _, name := range colorPalette {
+		if color == name {
+			return color, true
+		}
+	}
+	return "", false
+}
+
+func invalidColor(w http.ResponseWriter, color string) {
+	rnd.JSON(w, http.StatusBadRequest, renderer.M{
+		"message": "color must be a hex value like #1e90ff or one of " + strings.Join(colorPalette, ", "),
+		"color":   color,
+	})
+}
+
+func listHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", fetchLists)
+	r.Put("/{id}", updateList)
+	r.Get("/{id}/export.pdf", exportListPDF)
+	return r
+}
+
+// listName returns the list name in the path. chi matches the raw path
+// when it differs from the decoded one, as for names holding a slash, so
+// the parameter is unescaped only then.
+func listName(r *http.Request) (string, error) {
+	name := chi.URLParam(r, "id")
+	if r.URL.RawPath != "" {
+		return url.PathUnescape(name)
+	}
+	return name, nil
+}
+
+// fetchLists returns every list name in use with its todo count and color.
+func fetchLists(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"list": bson.M{"$nin": bson.A{nil, ""}}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$list", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	counts := []countBucket{}
+	if err == nil {
+		err = cur.All(ctx, &counts)
+	}
+	settings := []listSettings{}
+	if err == nil {
+		cur, err = listsCollection.Find(ctx, bson.M{})
+		if err == nil {
+			err = cur.All(ctx, &settings)
+		}
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch lists",
+			"error":   err.Error(),
+		})
+		return
+	}
+	colors := map[string]string{}
+	for _, s := range settings {
+		colors[s.Name] = s.Color
+	}
+	lists := []renderer.M{}
+	for _, c := range counts {
+		lists = append(lists, renderer.M{
+			"name":  c.Key,
+			"count": c.Count,
+			"color": colors[c.Key],
+		})
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": lists,
+	})
+}
+
+func updateList(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	name, err := listName(r)
+	if err != nil || name == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+		})
+		return
+	}
+	var body struct {
+		Color string `json:"color"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	color, ok := normalizeColor(body.Color)
+	if !ok {
+		invalidColor(w, body.Color)
+		return
+	}
+	s := listSettings{
+		Name:      name,
+		Color:     color,
+		UpdatedAt: time.Now(),
+	}
+	if _, err := listsCollection.UpdateOne(ctx, bson.M{"name": s.Name}, bson.M{"$set": s}, options.Update().SetUpsert(true)); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "List update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "List update successful",
+		"data":    s,
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "fmt"
+
+// commands are the subcommands accepted as the first argument. Running the
+// binary without one starts the server.
+var commands = map[string]func(args []string) error{
+	"backup":            backupCommand,
+	"restore":           restoreCommand,
+	"migrate-statuses":  migrateStatusesCommand,
+	"rotate-field-keys": rotateFieldKeysCommand,
+	"seed":              seedCommand,
+	"doctor":            doctorCommand,
+}
+
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd(args)
+}
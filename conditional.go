@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ifUnmodifiedSince rejects a write with 412 when the todo changed after the
+// time in If-Unmodified-Since. Clients get that time from Last-Modified on
+// GET /todo/{id}. Like the header, the comparison is to the second.
+//
+// The check here answers early; the handlers repeat it in the filter of
+// their write through unmodifiedSince, so an update landing in between is
+// not overwritten.
+func ifUnmodifiedSince(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+		if err != nil {
+			// A missing or invalid date means no precondition.
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := parseTodoID(chi.URLParam(r, "id"))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var t todoModel
+		err = collection.FindOne(ctx, bson.M{"_id": id},
+			options.FindOne().SetProjection(bson.M{"updatedat": 1})).Decode(&t)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", nil))
+			return
+		}
+		if err == nil && t.UpdatedAt.Truncate(time.Second).After(since) {
+			preconditionFailed(w, r, t.UpdatedAt)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), unmodifiedSinceKey{}, since)))
+	})
+}
+
+type unmodifiedSinceKey struct{}
+
+// unmodifiedSince adds the request's If-Unmodified-Since condition to
+// filter and reports whether there was one. A write that then matches
+// nothing should be answered with conditionFailed.
+func unmodifiedSince(r *http.Request, filter bson.M) bool {
+	since, ok := r.Context().Value(unmodifiedSinceKey{}).(time.Time)
+	if ok {
+		filter["updatedat"] = bson.M{"$lt": since.Add(time.Second)}
+	}
+	return ok
+}
+
+// conditionFailed answers a conditional write that matched nothing. It
+// reports false, answering nothing, when the todo does not exist.
+func conditionFailed(ctx context.Context, w http.ResponseWriter, r *http.Request, id todoID) bool {
+	var t todoModel
+	err := collection.FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"updatedat": 1})).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", nil))
+		return true
+	}
+	preconditionFailed(w, r, t.UpdatedAt)
+	return true
+}
+
+func preconditionFailed(w http.ResponseWriter, r *http.Request, updatedAt time.Time) {
+	w.Header().Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	rnd.JSON(w, http.StatusPreconditionFailed, withMessage(r, "precondition_failed", nil))
+}
+
+// backfillUpdatedAt folds the updated_at field that updates used to write
+// into updatedat, which is the field todos are read from.
+func backfillUpdatedAt() {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	_, err := collection.UpdateMany(ctx, bson.M{"updated_at": bson.M{"$exists": true}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"updatedat": bson.M{"$max": bson.A{"$updatedat", "$updated_at"}}}}},
+		{{Key: "$unset", Value: "updated_at"}},
+	})
+	if err != nil {
+		log.Printf("updated at: %s\n", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2026-06-01 is a Monday.
+	after := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 0 * * 1", time.Date(2026, 6, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * *", time.Date(2026, 6, 15, 0, 0, 0, 0, time.UTC)},
+		// */2 restricts the day of month, so either day field matching is
+		// enough: the 3rd is odd, though not a Monday.
+		{"0 0 */2 * 1", time.Date(2026, 6, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-31/2 * 1", time.Date(2026, 6, 3, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 7", time.Date(2026, 6, 7, 9, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.expr, err)
+		}
+		if got := s.next(after); !got.Equal(tt.want) {
+			t.Errorf("%q: next = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
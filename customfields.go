@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const customFieldsCollectionName = "custom_fields"
+
+var (
+	customFieldsCollection *mongo.Collection
+	customFieldKeyPattern  = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+)
+
+// customField declares a user-defined field. Values live in the todo's
+// custom_fields subdocument under the field key, typed according to Type.
+type customField struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	Key       string             `bson:"key" json:"key" validate:"required"`
+	Name      string             `bson:"name" json:"name" validate:"required,max=100"`
+	Type      string             `bson:"type" json:"type" validate:"oneof=text number date select"`
+	Options   []string           `bson:"options,omitempty" json:"options,omitempty"`
+	Required  bool               `bson:"required" json:"required"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func loadCustomFields(ctx context.Context) (map[string]customField, error) {
+	cur, err := customFieldsCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	fields := []customField{}
+	if err := cur.All(ctx, &fields); err != nil {
+		return nil, err
+	}
+	byKey := map[string]customField{}
+	for _, f := range fields {
+		byKey[f.Key] = f
+	}
+	return byKey, nil
+}
+
+// checkCustomFields validates values against the declared fields and returns
+// them converted to their stored types. When creating is set, required fields
+// must be present.
+func checkCustomFields(ctx context.Context, values map[string]interface{}, creating bool) (bson.M, error) {
+	defs, err := loadCustomFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	typed := bson.M{}
+	for key, value := range values {
+		def, ok := defs[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown custom field %q", key)
+		}
+		converted, err := def.convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("custom field %q: %w", key, err)
+		}
+		typed[key] = converted
+	}
+	if creating {
+		for key, def := range defs {
+			if _, ok := typed[key]; def.Required && !ok {
+				return nil, fmt.Errorf("custom field %q is required", key)
+			}
+		}
+	}
+	return typed, nil
+}
+
+func (f customField) convert(value interface{}) (interface{}, error) {
+	switch f.Type {
+	case "number":
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+		return nil, errors.New("must be a number")
+	case "date":
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, errors.New("must be an RFC 3339 date")
+	case "select":
+		if s, ok := value.(string); ok {
+			for _, option := range f.Options {
+				if s == option {
+					return s, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s", strings.Join(f.Options, ", "))
+	default:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, errors.New("must be a string")
+	}
+}
+
+// customFieldFilter turns ?cf.<key>=value parameters into conditions on the
+// custom_fields subdocument. Numeric values match numbers as well as text,
+// and RFC 3339 times match dates. Dates are compared with
+// ?cf.<key>.before= and ?cf.<key>.after=, which take an RFC 3339 time or
+// a day such as 2024-05-01 in the request's time zone; the day itself is
+// left out either way.
+func customFieldFilter(filter bson.M, r *http.Request) error {
+	for param, values := range r.URL.Query() {
+		name, ok := strings.CutPrefix(param, "cf.")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		key, op, _ := strings.Cut(name, ".")
+		if !customFieldKeyPattern.MatchString(key) {
+			continue
+		}
+		cond, _ := filter["custom_fields."+key].(bson.M)
+		if cond == nil {
+			cond = bson.M{}
+		}
+		switch op {
+		case "":
+			candidates := bson.A{values[0]}
+			if n, err := strconv.ParseFloat(values[0], 64); err == nil {
+				candidates = append(candidates, n)
+			}
+			if t, err := time.Parse(time.RFC3339, values[0]); err == nil {
+				candidates = append(candidates, t)
+			}
+			cond["$in"] = candidates
+		case "before", "after":
+			t, day, err := filterDate(r, values[0])
+			if err != nil {
+				return fmt.Errorf("%s: %w", param, err)
+			}
+			switch {
+			case op == "before":
+				cond["$lt"] = t
+			case day:
+				cond["$gte"] = t.AddDate(0, 0, 1)
+			default:
+				cond["$gt"] = t
+			}
+		default:
+			return fmt.Errorf("%w %s, use cf.%s, cf.%s.before or cf.%s.after", errInvalidFilter, param, key, key, key)
+		}
+		filter["custom_fields."+key] = cond
+	}
+	return nil
+}
+
+// filterDate reads an RFC 3339 time, or a day in the request's time zone,
+// which it returns the start of.
+func filterDate(r *http.Request, s string) (t time.Time, day bool, err error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	loc, err := requestLocation(r)
+	if err != nil {
+		return t, false, err
+	}
+	t, err = time.ParseInLocation(time.DateOnly, s, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("%w %q, use an RFC 3339 time or a day like 2024-05-01", errInvalidFilter, s)
+	}
+	return t, true, nil
+}
+
+func customFieldHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listCustomFields)
+	r.Post("/", createCustomField)
+	r.Delete("/{key}", deleteCustomField)
+	return r
+}
+
+func listCustomFields(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := customFieldsCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"key": 1}))
+	fields := []customField{}
+	if err == nil {
+		err = cur.All(ctx, &fields)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch custom fields",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": fields,
+	})
+}
+
+func createCustomField(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var f customField
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := validate.Struct(&f); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !customFieldKeyPattern.MatchString(f.Key) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "key must be lowercase letters, digits and underscores",
+		})
+		return
+	}
+	if f.Type == "select" && len(f.Options) == 0 {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "select fields need options",
+		})
+		return
+	}
+	if f.Type != "select" {
+		f.Options = nil
+	}
+	f.ID = primitive.NewObjectID()
+	f.CreatedAt = time.Now()
+	res, err := customFieldsCollection.UpdateOne(ctx, bson.M{"key": f.Key}, bson.M{"$setOnInsert": f}, options.Update().SetUpsert(true))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Custom field creation failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if res.UpsertedCount == 0 {
+		rnd.JSON(w, http.StatusConflict, renderer.M{
+			"message": "A custom field with this key already exists",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Custom field creation successful",
+		"key":     f.Key,
+	})
+}
+
+// deleteCustomField removes the declaration only; values already stored on
+// todos are left in place and can no longer be written.
+func deleteCustomField(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key := chi.URLParam(r, "key")
+	res, err := customFieldsCollection.DeleteOne(ctx, bson.M{"key": key})
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Error deleting the custom field",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if res.DeletedCount == 0 {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "Custom field not found",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Custom field deletion successful",
+		"key":     key,
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCustomFieldFilterDates(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for query, want := range map[string]bson.M{
+		"cf.launch.before=2024-05-01&tz=Europe/Berlin": {"$lt": time.Date(2024, 5, 1, 0, 0, 0, 0, berlin)},
+		"cf.launch.after=2024-05-01&tz=Europe/Berlin":  {"$gte": time.Date(2024, 5, 2, 0, 0, 0, 0, berlin)},
+		"cf.launch.after=2024-05-01T12:00:00Z":         {"$gt": at},
+		"cf.launch.after=2024-05-01T12:00:00Z&cf.launch.before=2024-06-01T00:00:00Z": {
+			"$gt": at,
+			"$lt": time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"cf.launch=2024-05-01T12:00:00Z": {"$in": bson.A{"2024-05-01T12:00:00Z", at}},
+	} {
+		filter := bson.M{}
+		if err := customFieldFilter(filter, httptest.NewRequest("GET", "/?"+query, nil)); err != nil {
+			t.Errorf("%s: %s", query, err)
+			continue
+		}
+		if got := inUTC(filter["custom_fields.launch"]); !reflect.DeepEqual(got, inUTC(want)) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"cf.launch.before=soon&tz=UTC", "cf.launch.on=2024-05-01"} {
+		err := customFieldFilter(bson.M{}, httptest.NewRequest("GET", "/?"+query, nil))
+		if !errors.Is(err, errInvalidFilter) {
+			t.Errorf("%s: err = %v, want errInvalidFilter", query, err)
+		}
+	}
+}
+
+// inUTC returns v with the times in it moved to UTC, so they compare
+// equal with reflect.DeepEqual.
+func inUTC(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC()
+	case bson.M:
+		m := bson.M{}
+		for k, e := range v {
+			m[k] = inUTC(e)
+		}
+		return m
+	case bson.A:
+		a := bson.A{}
+		for _, e := range v {
+			a = append(a, inUTC(e))
+		}
+		return a
+	}
+	return v
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 const (
 	hostname       string = "127.0.0.1:27017"
@@ -18,12 +18,16 @@ const (
 func DBInstance() *mongo.Client {
 	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://"+hostname))
+	opts, err := ClientOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		log.Fatal(err)
 		defer cancel()
 	}
-	fmt.Printf("Connection to mongo Successful at %s\n", hostname)
+	fmt.Printf("Connection to mongo Successful at %s\n", strings.Join(opts.Hosts, ","))
 	return client
 }
 
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// ClientOptions builds the Mongo client options from the environment:
+//
+//	TODO_MONGO_URI            connection string (default mongodb://127.0.0.1:27017)
+//	TODO_MONGO_REPLICA_SET    replica set name
+//	TODO_MONGO_READ_PREFERENCE primary, primaryPreferred, secondary,
+//	                          secondaryPreferred or nearest
+//	TODO_MONGO_READ_CONCERN   local, available, majority, linearizable or snapshot
+//	TODO_MONGO_WRITE_CONCERN  majority or a number of nodes
+//	TODO_MONGO_MAX_POOL_SIZE  maximum connections per server
+//	TODO_MONGO_MIN_POOL_SIZE  connections kept open per server
+//
+// Values given here override the same options in the URI.
+func ClientOptions() (*options.ClientOptions, error) {
+	uri, err := Secret("TODO_MONGO_URI", "mongodb://"+hostname)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Client().ApplyURI(uri)
+	if name := os.Getenv("TODO_MONGO_REPLICA_SET"); name != "" {
+		opts.SetReplicaSet(name)
+	}
+	if mode := os.Getenv("TODO_MONGO_READ_PREFERENCE"); mode != "" {
+		rp, err := ReadPreference(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+	if level := os.Getenv("TODO_MONGO_READ_CONCERN"); level != "" {
+		switch level {
+		case "local", "available", "majority", "linearizable", "snapshot":
+			opts.SetReadConcern(&readconcern.ReadConcern{Level: level})
+		default:
+			return nil, fmt.Errorf("TODO_MONGO_READ_CONCERN: unknown level %q", level)
+		}
+	}
+	if w := os.Getenv("TODO_MONGO_WRITE_CONCERN"); w != "" {
+		if w == "majority" {
+			opts.SetWriteConcern(writeconcern.Majority())
+		} else if n, err := strconv.Atoi(w); err == nil && n >= 0 {
+			opts.SetWriteConcern(&writeconcern.WriteConcern{W: n})
+		} else {
+			return nil, fmt.Errorf("TODO_MONGO_WRITE_CONCERN: expected majority or a number, got %q", w)
+		}
+	}
+	for key, set := range map[string]func(uint64) *options.ClientOptions{
+		"TODO_MONGO_MAX_POOL_SIZE": opts.SetMaxPoolSize,
+		"TODO_MONGO_MIN_POOL_SIZE": opts.SetMinPoolSize,
+	} {
+		if raw := os.Getenv(key); raw != "" {
+			n, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			set(n)
+		}
+	}
+	return opts, opts.Validate()
+}
+
+// Secret reads a secret setting. It returns the environment value as it is
+// unless the application installs a secrets provider.
+var Secret = func(key, fallback string) (string, error) {
+	return getenv(key, fallback), nil
+}
+
+// ReadPreference parses a read preference mode name.
+func ReadPreference(mode string) (*readpref.ReadPref, error) {
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, fmt.Errorf("unknown read preference %q", mode)
+	}
+	return readpref.New(m)
+}
+
+func getenv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
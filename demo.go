@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Demo mode serves a sample data set from its own database and restores it
+// every TODO_DEMO_RESET, so visitors can change anything. There is no
+// in-memory store; the demo database lives next to the real one on the same
+// server.
+const demoDBName = "project_todo_demo"
+
+var demoFlag = flag.Bool("demo", env("TODO_DEMO", "") == "true", "serve seeded sample data that is reset periodically")
+
+// startDemo switches to the demo database. It must run before the server
+// starts. The instance holding the "demo" lease seeds the database when it
+// takes the lease and again every interval, so replicas do not reset it
+// over each other.
+func startDemo(ctx context.Context) error {
+	openCollections(collection.Database().Client().Database(demoDBName))
+	interval, err := time.ParseDuration(env("TODO_DEMO_RESET", "1h"))
+	if err != nil || interval <= 0 {
+		log.Printf("demo: invalid TODO_DEMO_RESET, the demo will not be reset\n")
+		n, err := collection.EstimatedDocumentCount(ctx)
+		if err != nil || n > 0 {
+			return err
+		}
+		return resetDemo(ctx)
+	}
+	go runAsLeader(ctx, "demo", func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := resetDemo(ctx); err != nil {
+				log.Printf("demo: %s\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+	return nil
+}
+
+// resetDemo empties the demo database and seeds it again with dates
+// relative to now. The leases are kept, so the reset does not hand the
+// schedulers to another instance.
+func resetDemo(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	db := collection.Database()
+	if db.Name() != demoDBName {
+		return fmt.Errorf("refusing to reset %s, which is not the demo database", db.Name())
+	}
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$ne": leasesCollectionName}})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := db.Collection(name).Drop(ctx); err != nil {
+			return err
+		}
+	}
+	ensureIndexes()
+	lists, todos := demoData(time.Now())
+	if err := insertSeed(ctx, lists, todos); err != nil {
+		return err
+	}
+	log.Printf("demo: seeded %d lists and %d todos\n", len(lists), len(todos))
+	return nil
+}
+
+func demoData(now time.Time) ([]listSettings, []todoModel) {
+	day := 24 * time.Hour
+	in := func(d time.Duration) *time.Time {
+		t := now.Add(d).Truncate(time.Hour)
+		return &t
+	}
+	minutes := func(n int) *int { return &n }
+	lists := []listSettings{
+		{Name: "Work", Color: "blue"},
+		{Name: "Home", Color: "green"},
+		{Name: "Groceries", Color: "orange"},
+		{Name: "Trip to Lisbon", Color: "purple"},
+	}
+	todo := func(title, list string, tags ...string) todoModel {
+		return todoModel{Title: title, List: list, Tags: tags, CreatedAt: now.Add(-3 * day), UpdatedAt: now.Add(-3 * day)}
+	}
+	todos := []todoModel{}
+	add := func(t todoModel, change func(*todoModel)) {
+		if change != nil {
+			change(&t)
+		}
+		todos = append(todos, t)
+	}
+	add(todo("Prepare quarterly report", "Work", "reports"), func(t *todoModel) {
+		t.DueAt, t.Pinned = in(2*day), true
+		if workflow.status("doing") != nil {
+			t.Status = "doing"
+		}
+		t.Estimated, t.Remaining = minutes(240), minutes(90)
+		t.Checklist = []checklistItem{
+			{Title: "Collect numbers from finance", IsCompleted: true},
+			{Title: "Draft summary"},
+			{Title: "Review with team"},
+		}
+	})
+	add(todo("Reply to client feedback", "Work", "email"), func(t *todoModel) {
+		t.DueAt, t.Starred = in(-day), true
+	})
+	add(todo("Book meeting room for retro", "Work"), func(t *todoModel) {
+		t.DueAt, t.ReminderAt = in(day), in(20*time.Hour)
+	})
+	add(todo("Update onboarding docs", "Work", "docs"), func(t *todoModel) {
+		t.Estimated = minutes(60)
+	})
+	add(todo("Fix flaky login test", "Work", "bug"), func(t *todoModel) {
+		t.IsCompleted, t.UpdatedAt = true, now.Add(-day)
+	})
+	add(todo("Water the plants", "Home"), func(t *todoModel) {
+		t.DueAt, t.Color = in(6*time.Hour), "green"
+	})
+	add(todo("Call the plumber", "Home", "calls"), func(t *todoModel) {
+		t.DueAt = in(3 * day)
+	})
+	add(todo("Renew car insurance", "Home", "bills"), func(t *todoModel) {
+		t.DueAt, t.Starred = in(10*day), true
+	})
+	add(todo("Pay electricity bill", "Home", "bills"), func(t *todoModel) {
+		t.IsCompleted, t.UpdatedAt = true, now.Add(-2*day)
+	})
+	for _, item := range []string{"Oat milk", "Sourdough bread", "Tomatoes", "Coffee beans", "Basil"} {
+		add(todo(item, "Groceries", "shopping"), nil)
+	}
+	add(todo("Book flights", "Trip to Lisbon", "travel"), func(t *todoModel) {
+		t.IsCompleted, t.UpdatedAt = true, now.Add(-2*day)
+	})
+	add(todo("Reserve a table in Alfama", "Trip to Lisbon", "travel"), func(t *todoModel) {
+		t.DueAt = in(14 * day)
+		t.Location = &todoLocation{Label: "Alfama, Lisbon", Lat: 38.7118, Lng: -9.1300}
+		t.Location.check()
+	})
+	add(todo("Pack bags", "Trip to Lisbon", "travel"), func(t *todoModel) {
+		t.DueAt = in(20 * day)
+		t.Checklist = []checklistItem{{Title: "Passport"}, {Title: "Adapters"}, {Title: "Sunscreen"}}
+	})
+	add(todo("Read \"Designing Data-Intensive Applications\"", "", "reading"), func(t *todoModel) {
+		t.Archived = true
+		t.ArchivedAt = in(-day)
+	})
+	return lists, todos
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// A todo lists the todos blocking it in blocked_by. blocked is kept up to
+// date as blockers are added, removed, completed or deleted, so ?blocked=
+// stays a plain indexed match.
+
+func blockerHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listBlockers)
+	r.Post("/", addBlocker)
+	r.Delete("/{blockerID}", removeBlocker)
+	return r
+}
+
+func listBlockers(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	var t todoModel
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	blockers := []todoModel{}
+	if err == nil && len(t.BlockedBy) > 0 {
+		var cur *mongo.Cursor
+		cur, err = collection.Find(ctx, bson.M{"_id": bson.M{"$in": t.BlockedBy}})
+		if err == nil {
+			err = cur.All(ctx, &blockers)
+		}
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch blockers",
+			"error":   err.Error(),
+		})
+		return
+	}
+	todoList := []todo{}
+	for _, b := range blockers {
+		todoList = append(todoList, newTodo(b))
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": todoList,
+	})
+}
+
+func addBlocker(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		TodoID string `json:"todo_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	blockerID, err := parseTodoID(body.TodoID)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "todo_id must be the ID of the blocking todo",
+		})
+		return
+	}
+	if err := checkBlockerCycle(ctx, id, blockerID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errBlockerCycle) {
+			status = http.StatusConflict
+		} else if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, renderer.M{
+			"message": "Cannot add blocker",
+			"error":   err.Error(),
+		})
+		return
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$addToSet": bson.M{"blocked_by": blockerID},
+		"$set":      bson.M{"updatedat": time.Now()},
+	})
+	if err == nil {
+		err = refreshBlocked(ctx, id)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Cannot add blocker",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":    "Blocker added",
+		"todo_id":    id.Hex(),
+		"blocker_id": blockerID.Hex(),
+	})
+}
+
+func removeBlocker(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	blockerID, err := parseTodoID(chi.URLParam(r, "blockerID"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$pull": bson.M{"blocked_by": blockerID},
+		"$set":  bson.M{"updatedat": time.Now()},
+	})
+	if err == nil {
+		err = refreshBlocked(ctx, id)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Cannot remove blocker",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":    "Blocker removed",
+		"todo_id":    id.Hex(),
+		"blocker_id": blockerID.Hex(),
+	})
+}
+
+var errBlockerCycle = errors.New("the blocker already depends on this todo")
+
+// checkBlockerCycle walks everything the blocker is transitively blocked by
+// and fails if the todo is among it.
+func checkBlockerCycle(ctx context.Context, id, blockerID todoID) error {
+	if id == blockerID {
+		return errBlockerCycle
+	}
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": blockerID}}},
+		{{Key: "$graphLookup", Value: bson.M{
+			"from":             collectionName,
+			"startWith":        "$blocked_by",
+			"connectFromField": "blocked_by",
+			"connectToField":   "_id",
+			"as":               "ancestors",
+		}}},
+		{{Key: "$project", Value: bson.M{"ancestors._id": 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	var res []struct {
+		Ancestors []struct {
+			ID todoID `bson:"_id"`
+		} `bson:"ancestors"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return err
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("blocker %s: %w", blockerID.Hex(), mongo.ErrNoDocuments)
+	}
+	for _, a := range res[0].Ancestors {
+		if a.ID == id {
+			return errBlockerCycle
+		}
+	}
+	return nil
+}
+
+// refreshBlocked recomputes blocked for the given todos from the state of
+// their blockers and notifies about each todo that became unblocked.
+func refreshBlocked(ctx context.Context, ids ...todoID) error {
+	for _, id := range ids {
+		var t todoModel
+		if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+			return err
+		}
+		open := int64(0)
+		if len(t.BlockedBy) > 0 {
+			var err error
+			open, err = collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": t.BlockedBy}, "iscompleted": false})
+			if err != nil {
+				return err
+			}
+		}
+		if blocked := open > 0; blocked != t.Blocked {
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"blocked": blocked, "updatedat": time.Now()}}); err != nil {
+				return err
+			}
+			if !blocked && !t.IsCompleted {
+				notify(ctx, notification{
+					Type:    "unblocked",
+					Message: outboundTitle(t) + " is no longer blocked",
+					TodoID:  t.ID.Hex(),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// blockerChanged updates the todos blocked by id after it was completed,
+// reopened or deleted. Deleted blockers are dropped from blocked_by.
+func blockerChanged(id todoID, deleted bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"blocked_by": id})
+	dependents := []todoModel{}
+	if err == nil {
+		err = cur.All(ctx, &dependents)
+	}
+	if err == nil && deleted {
+		_, err = collection.UpdateMany(ctx, bson.M{"blocked_by": id}, bson.M{
+			"$pull": bson.M{"blocked_by": id},
+			"$set":  bson.M{"updatedat": time.Now()},
+		})
+	}
+	ids := []todoID{}
+	for _, t := range dependents {
+		ids = append(ids, t.ID)
+	}
+	if err == nil {
+		err = refreshBlocked(ctx, ids...)
+	}
+	if err != nil {
+		log.Printf("blockers: %s\n", err)
+	}
+}
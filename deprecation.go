@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// deprecation describes something clients should stop using. It is announced
+// with the Deprecation header (RFC 9745) and, once a removal date is set, the
+// Sunset header (RFC 8594).
+type deprecation struct {
+	since  time.Time
+	sunset time.Time
+}
+
+var (
+	// legacyPaths covers the unversioned aliases of the v1 API.
+	legacyPaths = deprecation{
+		since:  time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		sunset: envDate("TODO_LEGACY_SUNSET"),
+	}
+	// isCompletedField covers setting is_completed on update instead of
+	// status.
+	isCompletedField = deprecation{
+		since:  time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		sunset: envDate("TODO_IS_COMPLETED_SUNSET"),
+	}
+)
+
+// envDate reads an optional YYYY-MM-DD date, returning the zero time when the
+// variable is unset.
+func envDate(key string) time.Time {
+	raw := env(key, "")
+	if raw == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		log.Fatalf("%s: %s", key, err)
+	}
+	return t
+}
+
+// mark adds the headers to the response and logs the use, naming what was
+// used so the logs show which clients still need updating.
+func (d deprecation) mark(w http.ResponseWriter, r *http.Request, what string) {
+	w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.since.Unix()))
+	if !d.sunset.IsZero() {
+		w.Header().Set("Sunset", d.sunset.Format(http.TimeFormat))
+	}
+	log.Printf("deprecated: %s used by %s (%s)\n", what, r.RemoteAddr, r.UserAgent())
+}
+
+// deprecated marks every request to the routes it wraps.
+func deprecated(d deprecation) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d.mark(w, r, r.Method+" "+r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The digest is a periodic summary of overdue, upcoming and completed todos,
+// sent as a notification, and by email if enabled for it, daily or weekly
+// at a chosen hour in the time zone of the preferences. It is configured in
+// the digest settings document.
+const (
+	digestSettingsID   = "digest"
+	digestPollInterval = time.Minute
+	digestMaxTitles    = 10
+)
+
+const (
+	digestOff    = "off"
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+type digestSettings struct {
+	Frequency string `bson:"frequency" json:"frequency"`
+	// Weekday is the day weekly digests are sent, 0 for Sunday.
+	Weekday int `bson:"weekday" json:"weekday"`
+	Hour    int `bson:"hour" json:"hour"`
+	// Lists limits the digest to todos in these lists; empty means all.
+	Lists  []string   `bson:"lists,omitempty" json:"lists,omitempty"`
+	NextAt *time.Time `bson:"next_at,omitempty" json:"next_at,omitempty"`
+}
+
+// digest is the content of one digest.
+type digest struct {
+	Period    string
+	Since     time.Time
+	Overdue   []todoModel
+	DueSoon   []todoModel
+	Completed int64
+	Location  *time.Location
+}
+
+func (d digestSettings) check() error {
+	switch d.Frequency {
+	case digestOff, digestDaily, digestWeekly:
+	default:
+		return fmt.Errorf("frequency must be %s, %s or %s", digestOff, digestDaily, digestWeekly)
+	}
+	if d.Weekday < 0 || d.Weekday > 6 {
+		return errors.New("weekday must be between 0 (Sunday) and 6")
+	}
+	if d.Hour < 0 || d.Hour > 23 {
+		return errors.New("hour must be between 0 and 23")
+	}
+	return nil
+}
+
+// schedule returns the digest as a cron schedule, or nil when it is off.
+func (d digestSettings) schedule() *cronSchedule {
+	expr := fmt.Sprintf("0 %d * * *", d.Hour)
+	switch d.Frequency {
+	case digestWeekly:
+		expr = fmt.Sprintf("0 %d * * %d", d.Hour, d.Weekday)
+	case digestDaily:
+	default:
+		return nil
+	}
+	s, _ := parseCron(expr)
+	return s
+}
+
+// period is how far back a digest looks and how far ahead it lists due todos.
+func (d digestSettings) period() time.Duration {
+	if d.Frequency == digestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func loadDigestSettings(ctx context.Context) (digestSettings, error) {
+	d := digestSettings{Frequency: digestOff, Hour: 8, Weekday: 1}
+	err := settingsCollection.FindOne(ctx, bson.M{"_id": digestSettingsID}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = nil
+	}
+	return d, err
+}
+
+// digestScheduler sends the digest when it is due until ctx is done.
+func digestScheduler(ctx context.Context) {
+	ticker := time.NewTicker(digestPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if enabled, _, _ := maintenanceState(); enabled {
+				continue
+			}
+			if err := sendDueDigest(ctx, time.Now()); err != nil {
+				log.Printf("digest: %s\n", err)
+			}
+		}
+	}
+}
+
+func sendDueDigest(ctx context.Context, now time.Time) error {
+	d, err := loadDigestSettings(ctx)
+	if err != nil || d.NextAt == nil || d.NextAt.After(now) {
+		return err
+	}
+	schedule := d.schedule()
+	if schedule == nil {
+		return nil
+	}
+	next, err := nextRun(ctx, schedule, now)
+	if err != nil {
+		return err
+	}
+	// As with automations, a digest missed by a crash is skipped rather than
+	// sent twice.
+	res, err := settingsCollection.UpdateOne(ctx,
+		bson.M{"_id": digestSettingsID, "next_at": d.NextAt},
+		bson.M{"$set": bson.M{"next_at": next}})
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+	dg, err := buildDigest(ctx, d, now)
+	if err != nil {
+		return err
+	}
+	notify(ctx, notification{Type: "digest", Message: dg.summary(), template: "digest", data: dg})
+	return nil
+}
+
+// buildDigest collects the open todos that are overdue or due within the
+// next period and counts those completed during the last one.
+func buildDigest(ctx context.Context, d digestSettings, now time.Time) (digest, error) {
+	period := d.period()
+	loc, err := preferredLocation(ctx)
+	if err != nil {
+		return digest{}, err
+	}
+	dg := digest{Period: d.Frequency, Since: now.Add(-period), Location: loc}
+	scope := bson.M{"archived": bson.M{"$ne": true}}
+	if len(d.Lists) > 0 {
+		scope["list"] = bson.M{"$in": d.Lists}
+	}
+	find := func(extra bson.M) ([]todoModel, error) {
+		filter := bson.M{"iscompleted": false}
+		for k, v := range scope {
+			filter[k] = v
+		}
+		for k, v := range extra {
+			filter[k] = v
+		}
+		cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"due_at": 1}))
+		todos := []todoModel{}
+		if err == nil {
+			err = cur.All(ctx, &todos)
+		}
+		return todos, err
+	}
+	if dg.Overdue, err = find(bson.M{"due_at": bson.M{"$lt": now}}); err != nil {
+		return dg, err
+	}
+	if dg.DueSoon, err = find(bson.M{"due_at": bson.M{"$gte": now, "$lt": now.Add(period)}}); err != nil {
+		return dg, err
+	}
+	completed := bson.M{"iscompleted": true, "completed_at": bson.M{"$gte": dg.Since}}
+	for k, v := range scope {
+		completed[k] = v
+	}
+	dg.Completed, err = collection.CountDocuments(ctx, completed)
+	return dg, err
+}
+
+func (dg digest) Title() string {
+	if dg.Period == digestWeekly {
+		return "Weekly digest"
+	}
+	return "Daily digest"
+}
+
+// Ahead says how far ahead DueSoon looks.
+func (dg digest) Ahead() string {
+	if dg.Period == digestWeekly {
+		return "in the next week"
+	}
+	return "in the next day"
+}
+
+// Name is how the digest refers to t: by title, or by ID while titles are
+// encrypted.
+func (dg digest) Name(t todoModel) string {
+	if titlesSealed() {
+		return "Todo " + t.ID.Hex()
+	}
+	return t.Title
+}
+
+// Due formats when t is due for the email templates.
+func (dg digest) Due(t todoModel) string {
+	if t.DueAt == nil {
+		return ""
+	}
+	return t.DueAt.In(dg.Location).Format("Mon 2 Jan 15:04")
+}
+
+// summary renders the digest as the text of a notification.
+func (dg digest) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d overdue, %d due %s, %d completed", dg.Title(), len(dg.Overdue), len(dg.DueSoon), dg.Ahead(), dg.Completed)
+	for _, group := range []struct {
+		name  string
+		todos []todoModel
+	}{{"Overdue", dg.Overdue}, {"Due " + dg.Ahead(), dg.DueSoon}} {
+		if len(group.todos) == 0 {
+			continue
+		}
+		titles := []string{}
+		for i, t := range group.todos {
+			if i == digestMaxTitles {
+				titles = append(titles, fmt.Sprintf("and %d more", len(group.todos)-i))
+				break
+			}
+			titles = append(titles, dg.Name(t))
+		}
+		fmt.Fprintf(&b, "\n%s: %s", group.name, strings.Join(titles, ", "))
+	}
+	return b.String()
+}
+
+func getDigestSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	d, err := loadDigestSettings(ctx)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch digest settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": d,
+	})
+}
+
+func updateDigestSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var d digestSettings
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := d.check(); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid digest settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	d.NextAt = nil
+	if schedule := d.schedule(); schedule != nil {
+		next, err := nextRun(ctx, schedule, time.Now())
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+				"message": "Settings update failed",
+				"error":   err.Error(),
+			})
+			return
+		}
+		d.NextAt = next
+	}
+	_, err := settingsCollection.ReplaceOne(ctx, bson.M{"_id": digestSettingsID}, d, options.Replace().SetUpsert(true))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Settings update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Settings update successful",
+		"data":    d,
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// doctorReport collects the results of the doctor command. Failures are
+// things that will break the server; warnings are settings that are
+// probably not what was meant.
+type doctorReport struct {
+	failed, warned int
+}
+
+func (d *doctorReport) ok(format string, args ...interface{}) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (d *doctorReport) warn(format string, args ...interface{}) {
+	d.warned++
+	fmt.Printf("warn  "+format+"\n", args...)
+}
+
+func (d *doctorReport) fail(format string, args ...interface{}) {
+	d.failed++
+	fmt.Printf("FAIL  "+format+"\n", args...)
+}
+
+// doctorCommand checks the configuration, the database and the backup
+// target, and prints what to fix before going live. It exits with an error
+// when any check fails.
+func doctorCommand(args []string) error {
+	d := &doctorReport{}
+	d.checkConfig()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if d.checkMongo(ctx) {
+		d.checkIndexes(ctx)
+		if err := checkIDFormat(ctx); err != nil {
+			d.fail("%s", err)
+		} else {
+			d.ok("ids: stored in the form TODO_ID_FORMAT asks for")
+		}
+	}
+	d.checkBackupTarget()
+	fmt.Printf("\n%d failed, %d warnings\n", d.failed, d.warned)
+	if d.failed > 0 {
+		return errors.New("doctor found problems")
+	}
+	return nil
+}
+
+func (d *doctorReport) checkConfig() {
+	for _, key := range []string{"TODO_DUPLICATE_WINDOW", "TODO_OVERDUE_INTERVAL", "TODO_ESCALATE_AFTER", "TODO_BACKUP_INTERVAL", "TODO_DEMO_RESET", "TODO_ASYNC_THRESHOLD", "TODO_TELEMETRY_INTERVAL"} {
+		if raw := env(key, ""); raw != "" {
+			if _, err := time.ParseDuration(raw); err != nil {
+				d.fail("%s: %q is not a duration like 10m or 24h", key, raw)
+			}
+		}
+	}
+	for _, key := range []string{"TODO_ESCALATE_MAX", "TODO_BACKUP_RETAIN", "TODO_POMODORO_MINUTES", "TODO_LLM_RATE"} {
+		if raw := env(key, ""); raw != "" {
+			if n, err := strconv.Atoi(raw); err != nil || n < 1 {
+				d.fail("%s: %q is not a positive number, the default is used instead", key, raw)
+			}
+		}
+	}
+	if searchMode != "ngram" && searchMode != "atlas" {
+		d.fail("TODO_SEARCH_MODE: %q is neither ngram nor atlas", searchMode)
+	}
+	if backupSettings.key != "" {
+		if _, err := backupKey(backupSettings.key); err != nil {
+			d.fail("TODO_BACKUP_KEY: %s", err)
+		}
+	} else if backupSettings.interval != "" {
+		d.warn("TODO_BACKUP_KEY is not set, scheduled backups are stored unencrypted")
+	}
+	if adminToken == "" {
+		d.warn("TODO_ADMIN_TOKEN is not set, the /admin routes are disabled")
+	} else if len(adminToken) < 16 {
+		d.warn("TODO_ADMIN_TOKEN is shorter than 16 characters")
+	}
+	if llm.URL != "" && llm.APIKey == "" {
+		d.warn("TODO_LLM_URL is set without TODO_LLM_API_KEY")
+	}
+	if (emailSettings.host != "" || emailSettings.dir != "") && emailSettings.to == "" {
+		d.warn("TODO_EMAIL_TO is not set, no email is sent")
+	}
+	if err := parseTemplates(); err != nil {
+		d.fail("templates: %s", err)
+	}
+	if *devFlag {
+		d.warn("dev mode is on, templates are read on every request")
+	}
+	if telemetrySettings.url != "" {
+		d.ok("telemetry: reports go to %s", telemetrySettings.url)
+	}
+	if *maintenanceFlag {
+		d.warn("maintenance mode is on, the server will refuse writes")
+	}
+	if d.failed == 0 {
+		d.ok("configuration")
+	}
+}
+
+// checkMongo pings the primary and writes and removes a probe document to
+// check the credentials may write.
+func (d *doctorReport) checkMongo(ctx context.Context) bool {
+	db := collection.Database()
+	if err := db.Client().Ping(ctx, readpref.Primary()); err != nil {
+		d.fail("mongo: cannot reach a primary: %s", err)
+		return false
+	}
+	d.ok("mongo: connected to database %s", db.Name())
+	probe := db.Collection("doctor_probe")
+	res, err := probe.InsertOne(ctx, bson.M{"at": time.Now()})
+	if err == nil {
+		_, err = probe.DeleteOne(ctx, bson.M{"_id": res.InsertedID})
+	}
+	if err == nil {
+		err = probe.Drop(ctx)
+	}
+	if err != nil {
+		d.fail("mongo: the user cannot write to %s: %s", db.Name(), err)
+		return false
+	}
+	d.ok("mongo: read and write permitted")
+	return true
+}
+
+// checkIndexes reports indexes the server would create on startup that are
+// missing, for example because the user may not create indexes, and those
+// whose unique or TTL setting differs from what the server expects.
+func (d *doctorReport) checkIndexes(ctx context.Context) {
+	problems := 0
+	for _, c := range requiredIndexes() {
+		cur, err := c.collection.Indexes().List(ctx)
+		var existing []struct {
+			Key                bson.D `bson:"key"`
+			Unique             bool   `bson:"unique"`
+			ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+		}
+		if err == nil {
+			err = cur.All(ctx, &existing)
+		}
+		if err != nil {
+			d.fail("indexes: cannot list indexes of %s: %s", c.collection.Name(), err)
+			continue
+		}
+		have := map[string]int{}
+		for i, index := range existing {
+			have[indexKeyString(index.Key)] = i
+		}
+		for _, index := range c.indexes {
+			key := indexKeyString(index.Keys.(bson.D))
+			i, ok := have[key]
+			if !ok {
+				problems++
+				d.warn("indexes: %s has no index on %s, start the server once or create it by hand", c.collection.Name(), key)
+				continue
+			}
+			unique, ttl := false, int32(-1)
+			if o := index.Options; o != nil {
+				unique = o.Unique != nil && *o.Unique
+				if o.ExpireAfterSeconds != nil {
+					ttl = *o.ExpireAfterSeconds
+				}
+			}
+			if existing[i].Unique != unique {
+				problems++
+				d.warn("indexes: the index of %s on %s should have unique %t, drop it and start the server to recreate it", c.collection.Name(), key, unique)
+			}
+			haveTTL := int32(-1)
+			if existing[i].ExpireAfterSeconds != nil {
+				haveTTL = *existing[i].ExpireAfterSeconds
+			}
+			if haveTTL != ttl {
+				problems++
+				d.warn("indexes: the index of %s on %s expires documents after %s instead of %s, drop it and start the server to recreate it",
+					c.collection.Name(), key, ttlString(haveTTL), ttlString(ttl))
+			}
+		}
+	}
+	if problems == 0 {
+		d.ok("indexes: all present")
+	}
+}
+
+func ttlString(seconds int32) string {
+	if seconds < 0 {
+		return "never"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func indexKeyString(keys bson.D) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s:%v", k.Key, k.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// checkBackupTarget lists the backup target, which needs the same
+// credentials and permissions as writing to it.
+func (d *doctorReport) checkBackupTarget() {
+	store, err := newBackupStore()
+	if err == nil {
+		_, err = store.List()
+	}
+	if err != nil {
+		d.fail("backups: cannot use %s: %s", backupSettings.target, err)
+		return
+	}
+	if local, ok := store.(localBackupStore); ok {
+		f, err := os.CreateTemp(string(local), ".doctor-*")
+		if err != nil {
+			d.fail("backups: cannot write to %s: %s", backupSettings.target, err)
+			return
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	d.ok("backups: %s is usable", backupSettings.target)
+}
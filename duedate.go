@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDueHour is the time of day used when a due string names a day but no
+// time, e.g. "next friday".
+const defaultDueHour = 9
+
+var clockPattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+// resolveDue parses a natural language due string in the request's time zone.
+func resolveDue(r *http.Request, due string) (*time.Time, error) {
+	loc, err := requestLocation(r)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseDue(due, time.Now().In(loc))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// parseDue understands a day ("today", "tonight", "tomorrow", "friday",
+// "next friday", "next week", "next month", "in 3 days", "2026-05-01") and/or
+// a time of day ("5pm", "5:30 pm", "17:00", "noon", "midnight"), relative to
+// now. "in N minutes/hours" gives an exact time. A weekday always means the
+// next one after today, and a time alone means its next occurrence.
+func parseDue(input string, now time.Time) (time.Time, error) {
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(input, ",", " ")))
+	var day time.Time
+	var hour, minute int
+	hasClock, exact := false, false
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if i+1 < len(words) && (words[i+1] == "am" || words[i+1] == "pm") {
+			word += words[i+1]
+			i++
+		}
+		switch {
+		case word == "at" || word == "on" || word == "by":
+		case word == "today":
+			day = now
+		case word == "tonight":
+			day = now
+			if !hasClock {
+				hour, minute = 20, 0
+				hasClock = true
+			}
+		case word == "tomorrow":
+			day = now.AddDate(0, 0, 1)
+		case word == "next" && i+1 < len(words):
+			i++
+			switch words[i] {
+			case "week":
+				day = now.AddDate(0, 0, 7)
+			case "month":
+				day = now.AddDate(0, 1, 0)
+			case "year":
+				day = now.AddDate(1, 0, 0)
+			default:
+				weekday, ok := parseWeekday(words[i])
+				if !ok {
+					return time.Time{}, fmt.Errorf("don't understand %q", "next "+words[i])
+				}
+				day = nextWeekday(now, weekday)
+			}
+		case word == "in" && i+2 < len(words):
+			n, err := strconv.Atoi(words[i+1])
+			if words[i+1] == "a" || words[i+1] == "an" {
+				n, err = 1, nil
+			}
+			if err != nil || n < 0 {
+				return time.Time{}, fmt.Errorf("don't understand %q", words[i+1])
+			}
+			switch strings.TrimSuffix(words[i+2], "s") {
+			case "minute", "min":
+				day, exact = now.Add(time.Duration(n)*time.Minute), true
+			case "hour":
+				day, exact = now.Add(time.Duration(n)*time.Hour), true
+			case "day":
+				day = now.AddDate(0, 0, n)
+			case "week":
+				day = now.AddDate(0, 0, 7*n)
+			case "month":
+				day = now.AddDate(0, n, 0)
+			default:
+				return time.Time{}, fmt.Errorf("don't understand %q", words[i+2])
+			}
+			i += 2
+		default:
+			if weekday, ok := parseWeekday(word); ok {
+				day = nextWeekday(now, weekday)
+			} else if date, err := time.ParseInLocation("2006-01-02", word, now.Location()); err == nil {
+				day = date
+			} else if h, m, ok := parseClock(word); ok {
+				hour, minute, hasClock = h, m, true
+			} else {
+				return time.Time{}, fmt.Errorf("don't understand %q", word)
+			}
+		}
+	}
+
+	switch {
+	case day.IsZero() && !hasClock:
+		return time.Time{}, fmt.Errorf("no date or time in %q", input)
+	case day.IsZero():
+		due := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 1)
+		}
+		return due, nil
+	case exact && !hasClock:
+		return day.Truncate(time.Minute), nil
+	case !hasClock:
+		hour, minute = defaultDueHour, 0
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location()), nil
+}
+
+func parseWeekday(word string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if word == name || word == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func nextWeekday(now time.Time, weekday time.Weekday) time.Time {
+	days := (int(weekday) - int(now.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+	return now.AddDate(0, 0, days)
+}
+
+// parseClock reads "5pm", "5:30pm", "17:00", "noon" and "midnight". A bare
+// number is rejected because it could as well be a day.
+func parseClock(word string) (int, int, bool) {
+	switch word {
+	case "noon":
+		return 12, 0, true
+	case "midnight":
+		return 0, 0, true
+	}
+	m := clockPattern.FindStringSubmatch(word)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	if minute > 59 {
+		return 0, 0, false
+	}
+	switch m[3] {
+	case "":
+		if hour > 23 {
+			return 0, 0, false
+		}
+	default:
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	return hour, minute, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateWindow is how far back createTodo looks for an open todo with the
+// same title. Set TODO_DUPLICATE_WINDOW to 0 to turn the check off.
+var duplicateWindow = loadDuplicateWindow()
+
+func loadDuplicateWindow() time.Duration {
+	d, err := time.ParseDuration(env("TODO_DUPLICATE_WINDOW", "10m"))
+	if err != nil {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// titleKey normalizes a title for duplicate detection: case, surrounding
+// and repeated whitespace are ignored.
+func titleKey(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(title), " "))
+}
+
+// findDuplicate returns an open todo created within the window whose title
+// normalizes to the same key, or nil.
+func findDuplicate(ctx context.Context, key string, now time.Time) (*todoModel, error) {
+	if duplicateWindow <= 0 {
+		return nil, nil
+	}
+	var t todoModel
+	err := collection.FindOne(ctx, bson.M{
+		"title_key":   key,
+		"iscompleted": false,
+		"createdat":   bson.M{"$gte": now.Add(-duplicateWindow)},
+	}, options.FindOne().SetSort(bson.M{"createdat": -1})).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// backfillTitleKeys sets title_key on todos created before it existed. Runs of
+// inner whitespace are not collapsed here, which only matters for duplicate
+// detection on those older todos. Encrypted collections get their keys from
+// rotate-field-keys instead.
+func backfillTitleKeys() {
+	if titlesSealed() {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	_, err := collection.UpdateMany(ctx, bson.M{"title_key": bson.M{"$exists": false}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"title_key": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$title"}}}}}},
+	})
+	if err != nil {
+		log.Printf("title keys: %s\n", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxEffortMinutes caps estimates at one week of work.
+const maxEffortMinutes = 7 * 24 * 60
+
+func checkEffort(estimated, remaining *int) error {
+	for name, v := range map[string]*int{"estimated_minutes": estimated, "remaining_minutes": remaining} {
+		if v != nil && (*v < 0 || *v > maxEffortMinutes) {
+			return fmt.Errorf("%s must be between 0 and %d", name, maxEffortMinutes)
+		}
+	}
+	return nil
+}
+
+// effortFilter handles ?max_effort=N, matching todos whose remaining effort,
+// or estimate when nothing remaining was recorded, is at most N minutes.
+func effortFilter(filter bson.M, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("max_effort"))
+	if err != nil {
+		return
+	}
+	andFilter(filter, bson.M{"$or": bson.A{
+		bson.M{"remaining_minutes": bson.M{"$lte": n}},
+		bson.M{"remaining_minutes": bson.M{"$exists": false}, "estimated_minutes": bson.M{"$lte": n}},
+	}})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Email is sent over SMTP when TODO_SMTP_HOST is set. For development,
+// TODO_EMAIL_DIR writes every message to that directory as an .eml file
+// instead. There are no user accounts, so everything goes to the addresses
+// in TODO_EMAIL_TO. Messages are sent by the job worker, which retries
+// transient failures.
+var emailSettings = struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+	to       string
+	dir      string
+}{
+	host:     env("TODO_SMTP_HOST", ""),
+	port:     env("TODO_SMTP_PORT", "587"),
+	username: env("TODO_SMTP_USERNAME", ""),
+	password: mustSecret("TODO_SMTP_PASSWORD", ""),
+	from:     env("TODO_EMAIL_FROM", "todo@localhost"),
+	to:       env("TODO_EMAIL_TO", ""),
+	dir:      env("TODO_EMAIL_DIR", ""),
+}
+
+// emailTemplateDir in staticFS holds a name.txt and a name.html template
+// per message. The text template also defines the subject.
+const emailTemplateDir = "email"
+
+type emailMessage struct {
+	To      []string `bson:"to"`
+	Subject string   `bson:"subject"`
+	Text    string   `bson:"text"`
+	HTML    string   `bson:"html"`
+	// Unsubscribe is the link sent in List-Unsubscribe, if any.
+	Unsubscribe string `bson:"unsubscribe,omitempty"`
+}
+
+// emailData is what the templates are filled with: Data is the message
+// and Unsubscribe the link, which the footer leaves out when it is empty.
+type emailData struct {
+	Data        interface{}
+	Unsubscribe string
+}
+
+func emailEnabled() bool {
+	return (emailSettings.host != "" || emailSettings.dir != "") && emailSettings.to != ""
+}
+
+func emailRecipients() []string {
+	to := []string{}
+	for _, addr := range strings.Split(emailSettings.to, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// renderEmail fills the templates called name for the recipients to.
+func renderEmail(name string, to []string, data emailData) (emailMessage, error) {
+	m := emailMessage{To: to, Unsubscribe: data.Unsubscribe}
+	text, err := textTemplate(path.Join(emailTemplateDir, name+".txt"))
+	if err != nil {
+		return m, err
+	}
+	html, err := htmlTemplate(path.Join(emailTemplateDir, name+".html"))
+	if err != nil {
+		return m, err
+	}
+	var b bytes.Buffer
+	if err := text.ExecuteTemplate(&b, "subject", data); err != nil {
+		return m, err
+	}
+	m.Subject = strings.Join(strings.Fields(b.String()), " ")
+	b.Reset()
+	if err := text.Execute(&b, data); err != nil {
+		return m, err
+	}
+	m.Text = b.String()
+	b.Reset()
+	if err := html.Execute(&b, data); err != nil {
+		return m, err
+	}
+	m.HTML = b.String()
+	return m, nil
+}
+
+// queueEmail renders a notification of type kind and leaves sending it to
+// the job worker. Recipients who unsubscribed from kind are left out. With
+// unsubscribe links, each recipient gets a message of their own, as the
+// link names them.
+func queueEmail(ctx context.Context, kind, name string, data interface{}) error {
+	to, err := emailRecipientsFor(ctx, kind)
+	if err != nil || len(to) == 0 {
+		return err
+	}
+	groups := [][]string{to}
+	if unsubscribeEnabled() {
+		groups = nil
+		for _, addr := range to {
+			groups = append(groups, []string{addr})
+		}
+	}
+	for _, group := range groups {
+		link := ""
+		if len(group) == 1 {
+			link = unsubscribeURL(group[0], kind)
+		}
+		m, err := renderEmail(name, group, emailData{Data: data, Unsubscribe: link})
+		if err != nil {
+			return err
+		}
+		if _, err := enqueueJob(ctx, "email", m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runEmailJob(ctx context.Context, payload bson.Raw) (interface{}, error) {
+	var m emailMessage
+	if err := bson.Unmarshal(payload, &m); err != nil {
+		return nil, permanent(err)
+	}
+	return nil, sendEmail(ctx, m)
+}
+
+// buildEmail encodes m as a multipart/alternative MIME message.
+func buildEmail(m emailMessage, now time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	id := make([]byte, 12)
+	rand.Read(id)
+	host := "localhost"
+	if _, domain, ok := strings.Cut(emailSettings.from, "@"); ok {
+		host = domain
+	}
+	header := []string{
+		"From: " + emailSettings.from,
+		"To: " + strings.Join(m.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date: " + now.Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@%s>", hex.EncodeToString(id), host),
+	}
+	if m.Unsubscribe != "" {
+		header = append(header,
+			"List-Unsubscribe: <"+m.Unsubscribe+">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click")
+	}
+	header = append(header,
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary="+w.Boundary())
+	b.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func sendEmail(ctx context.Context, m emailMessage) error {
+	if len(m.To) == 0 {
+		return permanent(errors.New("email has no recipients"))
+	}
+	now := time.Now()
+	msg, err := buildEmail(m, now)
+	if err != nil {
+		return permanent(err)
+	}
+	if emailSettings.dir != "" {
+		if err := os.MkdirAll(emailSettings.dir, 0o755); err != nil {
+			return err
+		}
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		name := fmt.Sprintf("%s-%s.eml", now.Format("20060102-150405"), hex.EncodeToString(suffix))
+		return os.WriteFile(filepath.Join(emailSettings.dir, name), msg, 0o644)
+	}
+	return smtpSend(ctx, m.To, msg)
+}
+
+// smtpSend delivers msg with STARTTLS when the server offers it. Rejections
+// (5xx replies) are permanent; anything else is worth retrying.
+func smtpSend(ctx context.Context, to []string, msg []byte) error {
+	addr := net.JoinHostPort(emailSettings.host, emailSettings.port)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, emailSettings.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	err = func() error {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: emailSettings.host}); err != nil {
+				return err
+			}
+		}
+		if emailSettings.username != "" {
+			auth := smtp.PlainAuth("", emailSettings.username, emailSettings.password, emailSettings.host)
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+		if err := c.Mail(emailSettings.from); err != nil {
+			return err
+		}
+		for _, addr := range to {
+			if err := c.Rcpt(addr); err != nil {
+				return err
+			}
+		}
+		w, err := c.Data()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return c.Quit()
+	}()
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
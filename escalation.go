@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// escalationPolicy controls the overdue job. There are no per-user policies
+// because todos have no owner or assigner; every notification goes to the
+// shared in-app feed.
+var escalationPolicy = struct {
+	interval string
+	after    string
+	max      string
+}{
+	interval: env("TODO_OVERDUE_INTERVAL", "5m"),
+	after:    env("TODO_ESCALATE_AFTER", "24h"),
+	max:      env("TODO_ESCALATE_MAX", "3"),
+}
+
+// overdueScheduler checks for overdue todos every TODO_OVERDUE_INTERVAL until
+// ctx is done. An empty interval turns the job off.
+func overdueScheduler(ctx context.Context) {
+	if escalationPolicy.interval == "" {
+		return
+	}
+	interval, err := time.ParseDuration(escalationPolicy.interval)
+	after, afterErr := time.ParseDuration(escalationPolicy.after)
+	maxNotices, maxErr := strconv.Atoi(escalationPolicy.max)
+	if err != nil || interval <= 0 || afterErr != nil || after <= 0 || maxErr != nil {
+		log.Printf("overdue: invalid TODO_OVERDUE_INTERVAL, TODO_ESCALATE_AFTER or TODO_ESCALATE_MAX\n")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The job writes, so it waits out maintenance.
+			if enabled, _, _ := maintenanceState(); enabled {
+				continue
+			}
+			if err := escalateOverdue(ctx, time.Now(), after, maxNotices); err != nil {
+				log.Printf("overdue: %s\n", err)
+			}
+		}
+	}
+}
+
+// escalateOverdue marks open todos past their due date as overdue, notifies
+// about each and fires the overdue rules. Todos still overdue after another
+// period are notified again, maxNotices times in total. Todos that were
+// completed, rescheduled or archived are cleared.
+func escalateOverdue(ctx context.Context, now time.Time, after time.Duration, maxNotices int) error {
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"overdue": true, "$or": bson.A{
+			bson.M{"iscompleted": true},
+			bson.M{"due_at": bson.M{"$gte": now}},
+			bson.M{"archived": true},
+		}},
+		bson.M{
+			"$unset": bson.M{"overdue": "", "escalations": "", "escalated_at": ""},
+			"$set":   bson.M{"updatedat": now},
+		})
+	if err != nil {
+		return err
+	}
+	cur, err := collection.Find(ctx, bson.M{
+		"iscompleted": false,
+		"archived":    bson.M{"$ne": true},
+		"due_at":      bson.M{"$lt": now},
+		"$or": bson.A{
+			bson.M{"overdue": bson.M{"$ne": true}},
+			bson.M{"escalated_at": bson.M{"$lt": now.Add(-after)}, "escalations": bson.M{"$lt": maxNotices}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		return err
+	}
+	newlyOverdue := []todoModel{}
+	for _, t := range todos {
+		// The filter on escalated_at makes sure a todo is only escalated once
+		// per period even if two instances run the job.
+		set := bson.M{"overdue": true, "escalated_at": now}
+		if !t.Overdue {
+			set["updatedat"] = now
+		}
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": t.ID, "escalated_at": t.EscalatedAt},
+			bson.M{"$set": set, "$inc": bson.M{"escalations": 1}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		n := notification{Type: "overdue", TodoID: t.ID.Hex()}
+		if t.Overdue {
+			n.Type = "overdue_escalation"
+			n.Message = fmt.Sprintf("%s is still overdue (notice %d of %d)", outboundTitle(t), t.Escalations+1, maxNotices)
+		} else {
+			n.Message = outboundTitle(t) + " is overdue"
+			newlyOverdue = append(newlyOverdue, t)
+		}
+		notify(ctx, n)
+	}
+	fireRules(triggerOverdue, newlyOverdue...)
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// exportTodos streams every todo matching the list filters in the format
+// requested with ?format=.
+func exportTodos(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "csv", "ndjson", "xlsx":
+	default:
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Unsupported export format",
+			"format":  format,
+		})
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer cur.Close(ctx)
+	// Large exports outlive the server's write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("export: %s\n", err)
+	}
+	switch format {
+	case "ndjson":
+		err = exportNDJSON(ctx, w, cur)
+	case "xlsx":
+		err = exportXLSX(ctx, w, cur)
+	default:
+		err = exportCSV(ctx, w, cur)
+	}
+	if err != nil {
+		log.Printf("export: %s\n", err)
+	}
+}
+
+func exportFilename(ext string) string {
+	return fmt.Sprintf("todos-%s.%s", time.Now().Format("20060102"), ext)
+}
+
+func exportCSV(ctx context.Context, w http.ResponseWriter, cur *mongo.Cursor) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename("csv")))
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "title", "is_completed", "list", "tags", "due_at", "created_at", "updated_at"}); err != nil {
+		return err
+	}
+	for cur.Next(ctx) {
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		dueAt := ""
+		if t.DueAt != nil {
+			dueAt = t.DueAt.Format(time.RFC3339)
+		}
+		if err := cw.Write([]string{
+			t.ID.Hex(),
+			csvSafe(t.Title),
+			strconv.FormatBool(t.IsCompleted),
+			csvSafe(t.List),
+			csvSafe(strings.Join(t.Tags, ";")),
+			dueAt,
+			t.CreatedAt.Format(time.RFC3339),
+			t.UpdatedAt.Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return cur.Err()
+}
+
+func exportNDJSON(ctx context.Context, w http.ResponseWriter, cur *mongo.Cursor) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename("ndjson")))
+	return writeNDJSON(ctx, w, cur, todoPresenter(nil))
+}
+
+// csvSafe keeps spreadsheet applications from evaluating user text as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvUnescape reverses csvSafe so exported files can be imported again.
+func csvUnescape(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fcm sends to Android devices through the Firebase Cloud Messaging HTTP v1
+// API. TODO_FCM_CREDENTIALS holds the service account key JSON of the
+// Firebase project, usually as a file: reference.
+var fcm = loadFCM()
+
+type fcmSender struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key         *rsa.PrivateKey
+	mu          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+func loadFCM() *fcmSender {
+	f := &fcmSender{}
+	raw := mustSecret("TODO_FCM_CREDENTIALS", "")
+	if raw == "" {
+		return f
+	}
+	if err := json.Unmarshal([]byte(raw), f); err != nil {
+		log.Fatalf("TODO_FCM_CREDENTIALS: %s", err)
+	}
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		log.Fatal("TODO_FCM_CREDENTIALS: private_key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		log.Fatal("TODO_FCM_CREDENTIALS: private_key is not an RSA key")
+	}
+	f.key = rsaKey
+	if f.TokenURI == "" {
+		f.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return f
+}
+
+func (f *fcmSender) enabled() bool {
+	return f.key != nil
+}
+
+// token returns an OAuth access token for the service account, fetching a
+// new one shortly before the cached one expires.
+func (f *fcmSender) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.accessToken != "" && time.Now().Before(f.expires.Add(-time.Minute)) {
+		return f.accessToken, nil
+	}
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   f.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/firebase.messaging",
+		"aud":   f.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf("fcm: token request failed with %s: %s", res.Status, body)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	f.accessToken = out.AccessToken
+	f.expires = now.Add(time.Duration(out.ExpiresIn) * time.Second)
+	return f.accessToken, nil
+}
+
+func (f *fcmSender) send(ctx context.Context, token string, m pushMessage) error {
+	access, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{"type": m.Type}
+	if m.TodoID != "" {
+		data["todo_id"] = m.TodoID
+	}
+	message := map[string]interface{}{
+		"token":        token,
+		"notification": map[string]string{"title": m.Title, "body": m.Body},
+		"data":         data,
+	}
+	if m.CollapseID != "" {
+		// The tag replaces a notification that is still shown.
+		message["android"] = map[string]interface{}{
+			"collapse_key": m.CollapseID,
+			"notification": map[string]string{"tag": m.CollapseID},
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"message": message})
+	endpoint := fmt.Sprintf("https://fcm.googleapis.com/v1/projects/%s/messages:send", url.PathEscape(f.ProjectID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+access)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	var out struct {
+		Error struct {
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&out)
+	for _, d := range out.Error.Details {
+		if d.ErrorCode == "UNREGISTERED" {
+			return errDeviceGone
+		}
+	}
+	err = fmt.Errorf("fcm: %s: %s", res.Status, out.Error.Message)
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		// Retry with a fresh access token.
+		f.mu.Lock()
+		f.accessToken = ""
+		f.mu.Unlock()
+		return err
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return err
+	}
+	return permanent(err)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// feedToken guards the calendar feed. The feed is disabled while it is empty.
+var feedToken = mustSecret("TODO_FEED_TOKEN", "")
+
+// icsFeed serves todos that have a due date as an iCalendar document so the
+// feed can be subscribed to with a webcal:// URL. Entries are VEVENTs by default
+// since most calendar apps ignore VTODO; ?type=todo emits VTODOs instead.
+// Archived todos are left out.
+func icsFeed(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if feedToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(feedToken)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{
+		"due_at":   bson.M{"$exists": true},
+		"archived": bson.M{"$ne": true},
+	})
+	if err != nil {
+		http.Error(w, "Failed to fetch todo", http.StatusInternalServerError)
+		return
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		http.Error(w, "Failed to fetch todo", http.StatusInternalServerError)
+		return
+	}
+
+	asTodo := r.URL.Query().Get("type") == "todo"
+	now := time.Now().UTC().Format(icsTimeFormat)
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//project_todo//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "X-WR-CALNAME:Todos")
+	for _, t := range todos {
+		due := t.DueAt.UTC().Format(icsTimeFormat)
+		if asTodo {
+			writeICSLine(&b, "BEGIN:VTODO")
+			writeICSLine(&b, "DUE:"+due)
+			if t.IsCompleted {
+				writeICSLine(&b, "STATUS:COMPLETED")
+			} else {
+				writeICSLine(&b, "STATUS:NEEDS-ACTION")
+			}
+		} else {
+			writeICSLine(&b, "BEGIN:VEVENT")
+			writeICSLine(&b, "DTSTART:"+due)
+			writeICSLine(&b, "DTEND:"+due)
+		}
+		writeICSLine(&b, "UID:"+t.ID.Hex()+"@project_todo")
+		writeICSLine(&b, "DTSTAMP:"+now)
+		writeICSLine(&b, "CREATED:"+t.CreatedAt.UTC().Format(icsTimeFormat))
+		writeICSLine(&b, "LAST-MODIFIED:"+t.UpdatedAt.UTC().Format(icsTimeFormat))
+		summary := t.Title
+		if asTodo || !t.IsCompleted {
+			writeICSLine(&b, "SUMMARY:"+escapeICSText(summary))
+		} else {
+			writeICSLine(&b, "SUMMARY:"+escapeICSText("✓ "+summary))
+		}
+		if len(t.Tags) > 0 {
+			tags := make([]string, len(t.Tags))
+			for i, tag := range t.Tags {
+				tags[i] = escapeICSText(tag)
+			}
+			writeICSLine(&b, "CATEGORIES:"+strings.Join(tags, ","))
+		}
+		if asTodo {
+			writeICSLine(&b, "END:VTODO")
+		} else {
+			writeICSLine(&b, "END:VEVENT")
+		}
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="todos.ics"`)
+	if _, err := fmt.Fprint(w, b.String()); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeICSLine writes a content line folded at 75 octets as RFC 5545 requires.
+// The space that starts a continuation line counts towards its 75.
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
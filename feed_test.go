@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteICSLineFolds(t *testing.T) {
+	for _, line := range []string{
+		"SUMMARY:" + strings.Repeat("x", 300),
+		"SUMMARY:" + strings.Repeat("ü", 150),
+	} {
+		var b strings.Builder
+		writeICSLine(&b, line)
+		folded := strings.TrimSuffix(b.String(), "\r\n")
+		for _, l := range strings.Split(folded, "\r\n") {
+			if len(l) > 75 {
+				t.Errorf("line of %d octets: %q", len(l), l)
+			}
+		}
+		if got := strings.ReplaceAll(folded, "\r\n ", ""); got != line {
+			t.Errorf("unfolded to %q, want %q", got, line)
+		}
+	}
+}
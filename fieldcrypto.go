@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Todo titles can be encrypted at rest with AES-256-GCM. A sealed title is
+// stored as "sealed:v1:<key id>:<base64 nonce and ciphertext>" with the todo
+// ID as additional data, so it cannot be copied onto another todo. Titles
+// without the prefix are read as plain text, which lets a collection be
+// encrypted gradually with the rotate-field-keys command.
+//
+// title_key and title_grams hold HMACs instead of text while encryption is
+// on, so duplicate detection and trigram search keep working. Prefix
+// autocomplete, title_contains filters and Atlas Search do not.
+//
+// Titles do not leave the collection in plain text either: notifications,
+// pushes and email name todos by ID, and queued imports seal their rows.
+const sealedPrefix = "sealed:v1:"
+
+// fieldKeyProvider supplies the keys titles are sealed with.
+type fieldKeyProvider interface {
+	// current returns the key new values are sealed with.
+	current() (id string, key []byte)
+	// key returns the key with the given ID for values sealed before a
+	// rotation.
+	key(id string) ([]byte, bool)
+}
+
+// envFieldKeys reads TODO_FIELD_KEYS, a comma separated list of id:base64
+// 32-byte keys. The first seals new values; the others are kept to open
+// values sealed before the last rotation.
+type envFieldKeys struct {
+	order []string
+	keys  map[string][]byte
+}
+
+func (e envFieldKeys) current() (string, []byte) {
+	return e.order[0], e.keys[e.order[0]]
+}
+
+func (e envFieldKeys) key(id string) ([]byte, bool) {
+	k, ok := e.keys[id]
+	return k, ok
+}
+
+func parseFieldKeys(raw string) (envFieldKeys, error) {
+	e := envFieldKeys{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return e, fmt.Errorf("field key %q: expected id:base64", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return e, fmt.Errorf("field key %q must be 32 bytes of base64", id)
+		}
+		if _, dup := e.keys[id]; dup {
+			return e, fmt.Errorf("field key %q is listed twice", id)
+		}
+		e.order = append(e.order, id)
+		e.keys[id] = key
+	}
+	return e, nil
+}
+
+// fieldKeys is nil when titles are stored in plain text. indexKey keys the
+// HMACs stored in place of title_key and title_grams; it is not rotated.
+var fieldKeys, indexKey = loadFieldKeys()
+
+func loadFieldKeys() (fieldKeyProvider, []byte) {
+	raw := mustSecret("TODO_FIELD_KEYS", "")
+	if raw == "" {
+		return nil, nil
+	}
+	keys, err := parseFieldKeys(raw)
+	if err != nil {
+		log.Fatalf("TODO_FIELD_KEYS: %s", err)
+	}
+	index, err := base64.StdEncoding.DecodeString(mustSecret("TODO_FIELD_INDEX_KEY", ""))
+	if err != nil || len(index) != 32 {
+		log.Fatal("TODO_FIELD_INDEX_KEY must be 32 bytes of base64 when TODO_FIELD_KEYS is set")
+	}
+	if searchMode == "atlas" {
+		log.Fatal("TODO_SEARCH_MODE=atlas cannot search encrypted titles")
+	}
+	return keys, index
+}
+
+func titlesSealed() bool { return fieldKeys != nil }
+
+func fieldAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealTitle encrypts a title for the todo with the current key. It returns
+// the title unchanged when encryption is off.
+func sealTitle(id todoID, title string) (string, error) {
+	return sealField(id.Hex(), title)
+}
+
+// openTitle decrypts a stored title. Plain text titles are returned as they
+// are.
+func openTitle(id todoID, stored string) (string, error) {
+	title, err := openField(id.Hex(), stored)
+	if err != nil {
+		return "", fmt.Errorf("title of %s: %w", id.Hex(), err)
+	}
+	return title, nil
+}
+
+// sealField encrypts value bound to aad, which has to be given again to
+// open it.
+func sealField(aad, value string) (string, error) {
+	if !titlesSealed() || value == "" {
+		return value, nil
+	}
+	keyID, key := fieldKeys.current()
+	aead, err := fieldAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(aad))
+	return sealedPrefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func openField(aad, stored string) (string, error) {
+	keyID, _, ok := sealedKeyID(stored)
+	if !ok {
+		return stored, nil
+	}
+	if !titlesSealed() {
+		return "", errors.New("encrypted but TODO_FIELD_KEYS is not set")
+	}
+	key, ok := fieldKeys.key(keyID)
+	if !ok {
+		return "", fmt.Errorf("sealed with unknown key %q", keyID)
+	}
+	aead, err := fieldAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	_, encoded, _ := strings.Cut(strings.TrimPrefix(stored, sealedPrefix), ":")
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed value is malformed")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", errors.New("could not be decrypted")
+	}
+	return string(plain), nil
+}
+
+func sealedKeyID(stored string) (string, string, bool) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return "", "", false
+	}
+	return strings.Cut(strings.TrimPrefix(stored, sealedPrefix), ":")
+}
+
+// outboundTitle names a todo in text that leaves the todo collection:
+// notifications, pushes and email. While encryption is on it names the todo
+// by ID, since that text is stored and sent in plain text.
+func outboundTitle(t todoModel) string {
+	if titlesSealed() {
+		return "Todo " + t.ID.Hex()
+	}
+	return strconv.Quote(t.Title)
+}
+
+// blindIndex replaces an index value with its HMAC while encryption is on.
+func blindIndex(value string) string {
+	if !titlesSealed() {
+		return value
+	}
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// storedTitleKey is the title_key saved with a todo and used to look up
+// duplicates.
+func storedTitleKey(title string) string {
+	return blindIndex(titleKey(title))
+}
+
+// MarshalBSON seals the title on the way into the database.
+func (t todoModel) MarshalBSON() ([]byte, error) {
+	type plain todoModel
+	sealed, err := sealTitle(t.ID, t.Title)
+	if err != nil {
+		return nil, err
+	}
+	p := plain(t)
+	p.Title = sealed
+	return bson.Marshal(p)
+}
+
+// UnmarshalBSON opens sealed titles on the way out.
+func (t *todoModel) UnmarshalBSON(data []byte) error {
+	type plain todoModel
+	if err := bson.Unmarshal(data, (*plain)(t)); err != nil {
+		return err
+	}
+	title, err := openTitle(t.ID, t.Title)
+	if err != nil {
+		return err
+	}
+	t.Title = title
+	return nil
+}
+
+// MarshalBSON seals the titles of a queued import, which would otherwise wait
+// in the jobs collection in plain text. Each is bound to its row.
+func (p importJobPayload) MarshalBSON() ([]byte, error) {
+	type plain importJobPayload
+	q := plain(p)
+	q.Rows = make([]importRow, len(p.Rows))
+	for i, row := range p.Rows {
+		sealed, err := sealField(importRowAAD(i), row.Title)
+		if err != nil {
+			return nil, err
+		}
+		row.Title = sealed
+		q.Rows[i] = row
+	}
+	return bson.Marshal(q)
+}
+
+func (p *importJobPayload) UnmarshalBSON(data []byte) error {
+	type plain importJobPayload
+	if err := bson.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	for i := range p.Rows {
+		title, err := openField(importRowAAD(i), p.Rows[i].Title)
+		if err != nil {
+			return fmt.Errorf("import row %d: %w", i+1, err)
+		}
+		p.Rows[i].Title = title
+	}
+	return nil
+}
+
+func importRowAAD(i int) string {
+	return "import-row:" + strconv.Itoa(i)
+}
+
+// rotateFieldKeysCommand re-seals every title that is in plain text or sealed
+// with an older key, and recomputes its blind indexes. Run it after turning
+// encryption on or adding a new key to the front of TODO_FIELD_KEYS; the old
+// key can be dropped once it finishes.
+func rotateFieldKeysCommand(args []string) error {
+	if !titlesSealed() {
+		return errors.New("TODO_FIELD_KEYS is not set")
+	}
+	ctx := context.Background()
+	currentID, _ := fieldKeys.current()
+	cur, err := collection.Find(ctx, bson.M{}, nil)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	rotated := 0
+	for cur.Next(ctx) {
+		var doc struct {
+			ID    todoID `bson:"_id"`
+			Title string `bson:"title"`
+		}
+		if err := bson.Unmarshal(cur.Current, &doc); err != nil {
+			return err
+		}
+		if keyID, _, ok := sealedKeyID(doc.Title); ok && keyID == currentID {
+			continue
+		}
+		plain, err := openTitle(doc.ID, doc.Title)
+		if err != nil {
+			return err
+		}
+		sealed, err := sealTitle(doc.ID, plain)
+		if err != nil {
+			return err
+		}
+		writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err = collection.UpdateOne(writeCtx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{
+			"title":       sealed,
+			"title_key":   storedTitleKey(plain),
+			"title_grams": titleGrams(plain),
+		}})
+		cancel()
+		if err != nil {
+			return err
+		}
+		rotated++
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("re-sealed %d titles with key %q\n", rotated, currentID)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// todoFieldColumns maps each field a client can ask for with ?fields= to the
+// document fields needed to produce it.
+var todoFieldColumns = map[string][]string{
+	"title":             {"title"},
+	"is_completed":      {"iscompleted"},
+	"created_at":        {"createdat"},
+	"updated_at":        {"updatedat"},
+	"list":              {"list"},
+	"tags":              {"tags"},
+	"checklist":         {"checklist"},
+	"reminder_at":       {"reminder_at"},
+	"due_at":            {"due_at"},
+	"completed_at":      {"completed_at"},
+	"custom_fields":     {"custom_fields"},
+	"status":            {"status", "iscompleted"},
+	"sort_key":          {"sort_key"},
+	"pinned":            {"pinned"},
+	"starred":           {"starred"},
+	"color":             {"color"},
+	"tracked_seconds":   {"tracked_seconds"},
+	"pomodoros":         {"pomodoros"},
+	"estimated_minutes": {"estimated_minutes"},
+	"remaining_minutes": {"remaining_minutes"},
+	"blocked_by":        {"blocked_by"},
+	"blocked":           {"blocked"},
+	"overdue":           {"overdue"},
+	"snoozes":           {"snoozes"},
+	"location":          {"location"},
+	"archived":          {"archived"},
+	"archived_at":       {"archived_at"},
+	"_links":            {},
+}
+
+// requestFields parses ?fields=title,is_completed into the fields to return
+// and the projection that loads them. The _id is always included. It returns
+// nil for both when every field was asked for.
+func requestFields(r *http.Request) ([]string, bson.M, error) {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil, nil, nil
+	}
+	fields := []string{"_id"}
+	projection := bson.M{"_id": 1}
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		columns, ok := todoFieldColumns[field]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields = append(fields, field)
+		for _, column := range columns {
+			projection[column] = 1
+		}
+	}
+	return fields, projection, nil
+}
+
+// todoPresenter returns how todos are rendered for the selected fields.
+func todoPresenter(fields []string) func(todoModel) interface{} {
+	if fields == nil {
+		return func(t todoModel) interface{} { return newTodo(t) }
+	}
+	return func(t todoModel) interface{} {
+		raw, _ := json.Marshal(newTodo(t))
+		all := map[string]json.RawMessage{}
+		json.Unmarshal(raw, &all)
+		selected := map[string]json.RawMessage{}
+		for _, field := range fields {
+			if v, ok := all[field]; ok {
+				selected[field] = v
+			}
+		}
+		return selected
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	filtersCollectionName = "filters"
+	maxFilterDepth        = 4
+)
+
+var filtersCollection *mongo.Collection
+
+type (
+	// filterExpr is the stored definition of a saved filter. Every condition
+	// that is set must hold; All and Any nest further expressions.
+	filterExpr struct {
+		Completed     *bool        `bson:"completed,omitempty" json:"completed,omitempty"`
+		Overdue       *bool        `bson:"overdue,omitempty" json:"overdue,omitempty"`
+		List          string       `bson:"list,omitempty" json:"list,omitempty"`
+		Tags          []string     `bson:"tags,omitempty" json:"tags,omitempty"`
+		AnyTags       []string     `bson:"any_tags,omitempty" json:"any_tags,omitempty"`
+		TitleContains string       `bson:"title_contains,omitempty" json:"title_contains,omitempty"`
+		DueWithinDays *int         `bson:"due_within_days,omitempty" json:"due_within_days,omitempty"`
+		All           []filterExpr `bson:"all,omitempty" json:"all,omitempty"`
+		Any           []filterExpr `bson:"any,omitempty" json:"any,omitempty"`
+	}
+	savedFilter struct {
+		ID        primitive.ObjectID `bson:"_id" json:"_id"`
+		Name      string             `bson:"name" json:"name" validate:"required,max=200"`
+		Filter    filterExpr         `bson:"filter" json:"filter"`
+		Notify    bool               `bson:"notify" json:"notify"`
+		CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	}
+)
+
+// compile validates the expression and turns it into a Mongo filter. Time
+// based conditions are resolved against now.
+func (f filterExpr) compile(now time.Time) (bson.M, error) {
+	return f.compileDepth(now, 0)
+}
+
+func (f filterExpr) compileDepth(now time.Time, depth int) (bson.M, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("filters can be nested at most %d levels deep", maxFilterDepth)
+	}
+	and := bson.A{}
+	if f.Completed != nil {
+		and = append(and, bson.M{"iscompleted": *f.Completed})
+	}
+	if f.Overdue != nil {
+		overdue := bson.M{"iscompleted": false, "due_at": bson.M{"$lt": now}}
+		if *f.Overdue {
+			and = append(and, overdue)
+		} else {
+			and = append(and, bson.M{"$nor": bson.A{overdue}})
+		}
+	}
+	if f.List != "" {
+		and = append(and, bson.M{"list": f.List})
+	}
+	if len(f.Tags) > 0 {
+		and = append(and, bson.M{"tags": bson.M{"$all": f.Tags}})
+	}
+	if len(f.AnyTags) > 0 {
+		and = append(and, bson.M{"tags": bson.M{"$in": f.AnyTags}})
+	}
+	if f.TitleContains != "" {
+		if titlesSealed() {
+			return nil, errors.New("title_contains is not available while titles are encrypted")
+		}
+		and = append(and, bson.M{"title": bson.M{"$regex": regexp.QuoteMeta(f.TitleContains), "$options": "i"}})
+	}
+	if f.DueWithinDays != nil {
+		if *f.DueWithinDays < 0 {
+			return nil, errors.New("due_within_days must not be negative")
+		}
+		and = append(and, bson.M{"due_at": bson.M{"$gte": now, "$lt": now.AddDate(0, 0, *f.DueWithinDays)}})
+	}
+	for _, sub := range f.All {
+		compiled, err := sub.compileDepth(now, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, compiled)
+	}
+	if len(f.Any) > 0 {
+		or := bson.A{}
+		for _, sub := range f.Any {
+			compiled, err := sub.compileDepth(now, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, compiled)
+		}
+		and = append(and, bson.M{"$or": or})
+	}
+	if len(and) == 0 {
+		return nil, errors.New("filter has no conditions")
+	}
+	return bson.M{"$and": and}, nil
+}
+
+// matches reports whether t satisfies the expression. It mirrors compile so
+// saved searches can be checked against a single todo without a query.
+func (f filterExpr) matches(t todoModel, now time.Time) bool {
+	if f.Completed != nil && t.IsCompleted != *f.Completed {
+		return false
+	}
+	if f.Overdue != nil {
+		overdue := !t.IsCompleted && t.DueAt != nil && t.DueAt.Before(now)
+		if overdue != *f.Overdue {
+			return false
+		}
+	}
+	if f.List != "" && t.List != f.List {
+		return false
+	}
+	has := map[string]bool{}
+	for _, tag := range t.Tags {
+		has[tag] = true
+	}
+	for _, tag := range f.Tags {
+		if !has[tag] {
+			return false
+		}
+	}
+	if len(f.AnyTags) > 0 {
+		found := false
+		for _, tag := range f.AnyTags {
+			found = found || has[tag]
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.TitleContains != "" && !strings.Contains(strings.ToLower(t.Title), strings.ToLower(f.TitleContains)) {
+		return false
+	}
+	if f.DueWithinDays != nil {
+		if t.DueAt == nil || t.DueAt.Before(now) || !t.DueAt.Before(now.AddDate(0, 0, *f.DueWithinDays)) {
+			return false
+		}
+	}
+	for _, sub := range f.All {
+		if !sub.matches(t, now) {
+			return false
+		}
+	}
+	if len(f.Any) > 0 {
+		found := false
+		for _, sub := range f.Any {
+			if sub.matches(t, now) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// notifySavedSearches checks newly created todos against the saved filters
+// that have notifications enabled and records a notification per match, so
+// the collection never has to be rescanned.
+func notifySavedSearches(todos ...todoModel) {
+	if len(todos) == 0 {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cur, err := filtersCollection.Find(ctx, bson.M{"notify": true})
+	filters := []savedFilter{}
+	if err == nil {
+		err = cur.All(ctx, &filters)
+	}
+	if err != nil {
+		log.Printf("saved searches: %s\n", err)
+		return
+	}
+	now := time.Now()
+	for _, f := range filters {
+		for _, t := range todos {
+			if f.Filter.matches(t, now) {
+				notify(ctx, notification{
+					Type:     "saved_search_match",
+					Message:  fmt.Sprintf("%s matches your saved search %q", outboundTitle(t), f.Name),
+					TodoID:   t.ID.Hex(),
+					FilterID: f.ID.Hex(),
+				})
+			}
+		}
+	}
+}
+
+func filterHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listFilters)
+	r.Post("/", createFilter)
+	r.Get("/{id}", getFilter)
+	r.Delete("/{id}", deleteFilter)
+	r.Get("/{id}/todos", filterTodos)
+	return r
+}
+
+func listFilters(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := filtersCollection.Find(ctx, bson.M{})
+	filters := []savedFilter{}
+	if err == nil {
+		err = cur.All(ctx, &filters)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch filters",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": filters,
+	})
+}
+
+func createFilter(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var f savedFilter
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	f.Name = strings.TrimSpace(f.Name)
+	if err := validate.Struct(&f); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Name is required",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if _, err := f.Filter.compile(time.Now()); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid filter",
+			"error":   err.Error(),
+		})
+		return
+	}
+	f.ID = primitive.NewObjectID()
+	f.CreatedAt = time.Now()
+	if _, err := filtersCollection.InsertOne(ctx, f); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Filter creation failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message":   "Filter creation successful",
+		"filter_id": f.ID.Hex(),
+	})
+}
+
+// findFilter loads the filter named by the {id} URL parameter, writing the
+// error response itself when that fails.
+func findFilter(ctx context.Context, w http.ResponseWriter, r *http.Request) (*savedFilter, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	var f savedFilter
+	if err := filtersCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&f); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, renderer.M{
+			"message": "Filter not found",
+		})
+		return nil, false
+	}
+	return &f, true
+}
+
+func getFilter(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if f, ok := findFilter(ctx, w, r); ok {
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"data": f,
+		})
+	}
+}
+
+func deleteFilter(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f, ok := findFilter(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := filtersCollection.DeleteOne(ctx, bson.M{"_id": f.ID}); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Error deleting the filter",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":   "Filter deletion successful",
+		"filter_id": f.ID.Hex(),
+	})
+}
+
+// filterTodos lists the todos a saved filter matches. Archived todos are
+// left out unless ?archived= asks for them, as in the other lists.
+func filterTodos(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	f, ok := findFilter(ctx, w, r)
+	if !ok {
+		return
+	}
+	query, err := f.Filter.compile(time.Now())
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Stored filter is invalid",
+			"error":   err.Error(),
+		})
+		return
+	}
+	archivedFilter(query, r)
+	cur, err := collection.Find(ctx, query)
+	todos := []todoModel{}
+	if err == nil {
+		err = cur.All(ctx, &todos)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	todoList := []todo{}
+	for _, t := range todos {
+		todoList = append(todoList, newTodo(t))
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"filter": f.Name,
+		"data":   todoList,
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	historyCollectionName = "todo_history"
+	maxHistoryEntries     = 100
+)
+
+var historyCollection *mongo.Collection
+
+// historyEntry records one edit of a todo as per-field changes. Fields are
+// named as in the API. Set-like fields (tags, blocked_by) report what was
+// added and removed rather than the whole before and after.
+type historyEntry struct {
+	ID      primitive.ObjectID `bson:"_id" json:"_id"`
+	TodoID  todoID             `bson:"todo_id" json:"todo_id"`
+	At      time.Time          `bson:"at" json:"at"`
+	Changes []fieldChange      `bson:"changes" json:"changes"`
+}
+
+type fieldChange struct {
+	Field   string        `bson:"field" json:"field"`
+	From    interface{}   `bson:"from,omitempty" json:"from,omitempty"`
+	To      interface{}   `bson:"to,omitempty" json:"to,omitempty"`
+	Added   []interface{} `bson:"added,omitempty" json:"added,omitempty"`
+	Removed []interface{} `bson:"removed,omitempty" json:"removed,omitempty"`
+}
+
+var (
+	// historyIgnored are fields that change on every write or are not data.
+	historyIgnored = map[string]bool{"updated_at": true, "_links": true}
+	historySets    = map[string]bool{"tags": true, "blocked_by": true}
+)
+
+// diffTodos compares the API representation of two versions of a todo.
+func diffTodos(before, after todoModel) []fieldChange {
+	from, to := todoFieldsMap(before), todoFieldsMap(after)
+	changes := []fieldChange{}
+	for k := range mergeKeys(from, to) {
+		if historyIgnored[k] || reflect.DeepEqual(from[k], to[k]) {
+			continue
+		}
+		switch {
+		case historySets[k]:
+			added, removed := setDiff(from[k], to[k])
+			changes = append(changes, fieldChange{Field: k, Added: added, Removed: removed})
+		case k == "custom_fields":
+			oldFields, _ := from[k].(map[string]interface{})
+			newFields, _ := to[k].(map[string]interface{})
+			for key := range mergeKeys(oldFields, newFields) {
+				if !reflect.DeepEqual(oldFields[key], newFields[key]) {
+					changes = append(changes, fieldChange{Field: k + "." + key, From: oldFields[key], To: newFields[key]})
+				}
+			}
+		default:
+			changes = append(changes, fieldChange{Field: k, From: from[k], To: to[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func todoFieldsMap(t todoModel) map[string]interface{} {
+	raw, _ := json.Marshal(newTodo(t))
+	fields := map[string]interface{}{}
+	json.Unmarshal(raw, &fields)
+	return fields
+}
+
+func setDiff(from, to interface{}) (added, removed []interface{}) {
+	oldItems, _ := from.([]interface{})
+	newItems, _ := to.([]interface{})
+	contains := func(items []interface{}, v interface{}) bool {
+		for _, item := range items {
+			if reflect.DeepEqual(item, v) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range newItems {
+		if !contains(oldItems, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range oldItems {
+		if !contains(newItems, v) {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+func mergeKeys(a, b map[string]interface{}) map[string]bool {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+// recordHistory stores what changed between two versions of a todo and
+// reports whether anything did. Edits that change nothing are not recorded.
+func recordHistory(before, after todoModel) bool {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changes := diffTodos(before, after)
+	if len(changes) == 0 {
+		return false
+	}
+	if err := convertTitleChanges(before.ID, changes, sealTitle); err != nil {
+		log.Printf("history: %s\n", err)
+		return true
+	}
+	if _, err := historyCollection.InsertOne(ctx, historyEntry{
+		ID:      primitive.NewObjectID(),
+		TodoID:  before.ID,
+		At:      time.Now(),
+		Changes: changes,
+	}); err != nil {
+		log.Printf("history: %s\n", err)
+	}
+	return true
+}
+
+// convertTitleChanges seals or opens the values of title changes, so history
+// does not keep titles in plain text while they are encrypted.
+func convertTitleChanges(id todoID, changes []fieldChange, convert func(todoID, string) (string, error)) error {
+	for i := range changes {
+		if changes[i].Field != "title" {
+			continue
+		}
+		for _, v := range []*interface{}{&changes[i].From, &changes[i].To} {
+			if s, ok := (*v).(string); ok {
+				converted, err := convert(id, s)
+				if err != nil {
+					return err
+				}
+				*v = converted
+			}
+		}
+	}
+	return nil
+}
+
+// todoHistory returns the latest edits of a todo, newest first.
+func todoHistory(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	cur, err := historyCollection.Find(ctx, bson.M{"todo_id": id},
+		options.Find().SetSort(bson.M{"at": -1}).SetLimit(maxHistoryEntries))
+	entries := []historyEntry{}
+	if err == nil {
+		err = cur.All(ctx, &entries)
+	}
+	for _, e := range entries {
+		if err == nil {
+			err = convertTitleChanges(e.TodoID, e.Changes, openTitle)
+		}
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": entries,
+	})
+}
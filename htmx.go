@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The /ui endpoints answer with HTML fragments from static/partials, for a
+// page driven by htmx: forms post there and the response replaces part of
+// the page. Writes go through the API handlers, so they are validated the
+// same way; a refused form gets the form_errors fragment with
+// HX-Retarget set to #form-errors.
+func uiHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(readOnlyDuringMaintenance)
+	r.Get("/todos", listColumnFragment)
+	r.Post("/todos", createTodoFragment)
+	r.Post("/todos/validate", validateTodoFragment)
+	r.Get("/todos/{id}", todoRowFragment)
+	r.Post("/todos/{id}/toggle", toggleTodoFragment)
+	r.Delete("/todos/{id}", deleteTodoFragment)
+	return r
+}
+
+func renderFragment(w http.ResponseWriter, status int, name string, data interface{}) {
+	tmpl, err := htmlTemplate("partials/*.html")
+	var b bytes.Buffer
+	if err == nil {
+		err = tmpl.ExecuteTemplate(&b, name, data)
+	}
+	if err != nil {
+		templateError(w, name, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
+
+// formErrors answers a refused form. htmx does not swap error responses
+// unless told to, so the errors go where the page expects them.
+func formErrors(w http.ResponseWriter, status int, errs []string) {
+	w.Header().Set("HX-Retarget", "#form-errors")
+	w.Header().Set("HX-Reswap", "outerHTML")
+	renderFragment(w, status, "form_errors", errs)
+}
+
+// callAPI runs an API handler for r with body as its JSON request and
+// returns the status and decoded response.
+func callAPI(h http.HandlerFunc, r *http.Request, body interface{}) (int, renderer.M) {
+	r = r.Clone(r.Context())
+	r.Body = http.NoBody
+	if body != nil {
+		data, _ := json.Marshal(body)
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	rec := &operationRecorder{header: http.Header{}}
+	h(rec, r)
+	res := renderer.M{}
+	json.Unmarshal(rec.body.Bytes(), &res)
+	return max(rec.status, http.StatusOK), res
+}
+
+// apiErrors returns the message and error of a refused API call.
+func apiErrors(res renderer.M) []string {
+	errs := []string{}
+	for _, key := range []string{"message", "error"} {
+		if s, ok := res[key].(string); ok && s != "" {
+			errs = append(errs, s)
+		}
+	}
+	if len(errs) == 0 {
+		errs = append(errs, "The request failed")
+	}
+	return errs
+}
+
+// todoForm is the form the fragments take. Tags are comma separated.
+type todoForm struct {
+	Title string   `json:"title"`
+	List  string   `json:"list,omitempty"`
+	Due   string   `json:"due,omitempty"`
+	Color string   `json:"color,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+func parseTodoForm(r *http.Request) (todoForm, error) {
+	if err := r.ParseForm(); err != nil {
+		return todoForm{}, err
+	}
+	f := todoForm{
+		Title: strings.TrimSpace(r.PostForm.Get("title")),
+		List:  strings.TrimSpace(r.PostForm.Get("list")),
+		Due:   strings.TrimSpace(r.PostForm.Get("due")),
+		Color: strings.TrimSpace(r.PostForm.Get("color")),
+	}
+	for _, tag := range strings.Split(r.PostForm.Get("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+	return f, nil
+}
+
+// check returns what is wrong with the form before it is sent.
+func (f todoForm) check(r *http.Request) []string {
+	errs := []string{}
+	if f.Title == "" {
+		errs = append(errs, withMessage(r, "title_required", nil)["message"].(string))
+	}
+	if f.Due != "" {
+		if _, err := resolveDue(r, f.Due); err != nil {
+			errs = append(errs, withMessage(r, "invalid_due_date", nil)["message"].(string)+": "+err.Error())
+		}
+	}
+	if _, ok := normalizeColor(f.Color); !ok {
+		errs = append(errs, "color must be a hex value like #1e90ff or one of "+strings.Join(colorPalette, ", "))
+	}
+	return errs
+}
+
+// listColumnFragment renders the todos matching the list filters as a
+// column, titled by ?list=.
+func listColumnFragment(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter, err := todoFilter(r)
+	if errors.Is(err, errUnknownZone) || errors.Is(err, errInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := filter["archived"]; !ok {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	opts := options.Find().SetSort(bson.D{
+		{Key: "pinned", Value: -1},
+		{Key: "sort_key", Value: 1},
+		{Key: "createdat", Value: 1},
+		{Key: "_id", Value: 1},
+	})
+	cur, err := listCollection.Find(ctx, filter, opts)
+	models := []todoModel{}
+	if err == nil {
+		err = cur.All(ctx, &models)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch todos", http.StatusInternalServerError)
+		return
+	}
+	todos := make([]todo, len(models))
+	for i, t := range models {
+		todos[i] = newTodo(t)
+	}
+	renderFragment(w, http.StatusOK, "list_column", struct {
+		List  string
+		Todos []todo
+	}{r.URL.Query().Get("list"), todos})
+}
+
+func todoRowFragment(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeTodoRow(ctx, w, http.StatusOK, id)
+}
+
+func writeTodoRow(ctx context.Context, w http.ResponseWriter, status int, id todoID) {
+	var t todoModel
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.Error(w, "Todo not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch todo", http.StatusInternalServerError)
+		return
+	}
+	renderFragment(w, status, "todo_row", newTodo(t))
+}
+
+// validateTodoFragment checks the form as it is filled in, without saving
+// it. A valid form gets an empty error list.
+func validateTodoFragment(w http.ResponseWriter, r *http.Request) {
+	f, err := parseTodoForm(r)
+	if err != nil {
+		renderFragment(w, http.StatusOK, "form_errors", []string{err.Error()})
+		return
+	}
+	renderFragment(w, http.StatusOK, "form_errors", f.check(r))
+}
+
+func createTodoFragment(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f, err := parseTodoForm(r)
+	if err != nil {
+		formErrors(w, http.StatusBadRequest, []string{err.Error()})
+		return
+	}
+	if errs := f.check(r); len(errs) > 0 {
+		formErrors(w, http.StatusUnprocessableEntity, errs)
+		return
+	}
+	status, res := callAPI(createTodo, r, f)
+	if status != http.StatusCreated {
+		formErrors(w, status, apiErrors(res))
+		return
+	}
+	id, err := parseTodoID(res["todo_id"].(string))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Trigger", "todo-created")
+	writeTodoRow(ctx, w, http.StatusCreated, id)
+}
+
+func toggleTodoFragment(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if status, res := callAPI(toggleCompleted, r, nil); status != http.StatusOK {
+		http.Error(w, strings.Join(apiErrors(res), ": "), status)
+		return
+	}
+	writeTodoRow(ctx, w, http.StatusOK, id)
+}
+
+// deleteTodoFragment answers with an empty body, which removes the row it
+// replaces.
+func deleteTodoFragment(w http.ResponseWriter, r *http.Request) {
+	if _, err := parseTodoID(chi.URLParam(r, "id")); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if status, res := callAPI(deleteTodo, r, nil); status != http.StatusOK {
+		http.Error(w, strings.Join(apiErrors(res), ": "), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+}
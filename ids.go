@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// idFormat picks how new todos are identified: "objectid" (the default),
+// "uuidv7" stored as a string or "uuidv7-binary" stored as BinData subtype 4.
+// Existing todos keep their IDs, so a collection may hold ObjectIDs and
+// UUIDs. UUIDs are written in the configured form only, so the server
+// refuses to start when todos hold them in the other one.
+var idFormat = loadIDFormat()
+
+func loadIDFormat() string {
+	format := env("TODO_ID_FORMAT", "objectid")
+	switch format {
+	case "objectid", "uuidv7", "uuidv7-binary":
+		return format
+	}
+	log.Fatalf("TODO_ID_FORMAT: unknown format %q", format)
+	return ""
+}
+
+var errIDFormat = errors.New("ids")
+
+// checkIDFormat returns an error when todos store UUIDs in the other form
+// than idFormat, which lookups by ID would no longer find.
+func checkIDFormat(ctx context.Context) error {
+	other, name := "binData", "uuidv7-binary"
+	if idFormat == "uuidv7-binary" {
+		other, name = "string", "uuidv7"
+	}
+	n, err := collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$type": other}},
+		options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: TODO_ID_FORMAT is %s but todos hold UUIDs stored as %s; switch the UUID storage form only on an empty collection", errIDFormat, idFormat, name)
+	}
+	return nil
+}
+
+// todoID is either an ObjectID or a UUID. Both render as text in the API:
+// 24 hex digits for an ObjectID and the usual dashed form for a UUID.
+type todoID struct {
+	oid    primitive.ObjectID
+	uuid   [16]byte
+	isUUID bool
+}
+
+func newTodoID() todoID {
+	if idFormat == "objectid" {
+		return todoID{oid: primitive.NewObjectID()}
+	}
+	return todoID{uuid: newUUIDv7(), isUUID: true}
+}
+
+// parseTodoID accepts either form regardless of idFormat.
+func parseTodoID(s string) (todoID, error) {
+	if len(s) == 36 && s[8] == '-' && s[13] == '-' && s[18] == '-' && s[23] == '-' {
+		var id todoID
+		raw := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+		if _, err := hex.Decode(id.uuid[:], []byte(raw)); err != nil {
+			return todoID{}, fmt.Errorf("invalid todo ID %q", s)
+		}
+		id.isUUID = true
+		return id, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return todoID{}, fmt.Errorf("invalid todo ID %q", s)
+	}
+	return todoID{oid: oid}, nil
+}
+
+// parseClientTodoID accepts the ID a client chose for a new todo, which has
+// to be an RFC 9562 UUID of any version, v4 and v7 included. The server may
+// hand out v7 UUIDs too; their random bits make a collision as unlikely as
+// between any two UUIDs, and one is refused with todo_exists. The ID is
+// read case-insensitively and always returned in lower case.
+func parseClientTodoID(s string) (todoID, error) {
+	id, err := parseTodoID(s)
+	if err != nil {
+		return id, err
+	}
+	if !id.isUUID || id.uuid == [16]byte{} || id.uuid[8]&0xc0 != 0x80 {
+		return todoID{}, fmt.Errorf("todo ID %q is not an RFC 9562 UUID", s)
+	}
+	return id, nil
+}
+
+// Hex returns the ID as it appears in the API.
+func (id todoID) Hex() string {
+	if !id.isUUID {
+		return id.oid.Hex()
+	}
+	h := hex.EncodeToString(id.uuid[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (id todoID) String() string { return id.Hex() }
+
+func (id todoID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	switch {
+	case !id.isUUID:
+		return bson.MarshalValue(id.oid)
+	case idFormat == "uuidv7-binary":
+		return bson.MarshalValue(primitive.Binary{Subtype: bsontype.BinaryUUID, Data: id.uuid[:]})
+	default:
+		return bson.MarshalValue(id.Hex())
+	}
+}
+
+func (id *todoID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	if oid, ok := raw.ObjectIDOK(); ok {
+		*id = todoID{oid: oid}
+		return nil
+	}
+	if s, ok := raw.StringValueOK(); ok {
+		parsed, err := parseTodoID(s)
+		if err != nil {
+			return err
+		}
+		*id = parsed
+		return nil
+	}
+	if subtype, b, ok := raw.BinaryOK(); ok && subtype == bsontype.BinaryUUID && len(b) == 16 {
+		*id = todoID{isUUID: true}
+		copy(id.uuid[:], b)
+		return nil
+	}
+	return fmt.Errorf("cannot read a todo ID from BSON %s", t)
+}
+
+func (id todoID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.Hex())
+}
+
+func (id *todoID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := parseTodoID(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+var uuidClock struct {
+	sync.Mutex
+	ms  int64
+	seq uint16
+}
+
+// newUUIDv7 returns a UUID whose first 48 bits are the Unix time in
+// milliseconds, so IDs sort by creation time. Within one millisecond the
+// 12 bit rand_a field counts up to keep them ordered.
+func newUUIDv7() [16]byte {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	uuidClock.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= uuidClock.ms {
+		uuidClock.seq++
+		if uuidClock.seq > 0xfff {
+			uuidClock.ms++
+			uuidClock.seq = 0
+		}
+		ms = uuidClock.ms
+	} else {
+		uuidClock.ms = ms
+		uuidClock.seq = binary.BigEndian.Uint16(u[6:8]) & 0x7ff
+	}
+	seq := uuidClock.seq
+	uuidClock.Unlock()
+	binary.BigEndian.PutUint64(u[0:8], uint64(ms)<<16)
+	u[6] = 0x70 | byte(seq>>8)
+	u[7] = byte(seq)
+	u[8] = u[8]&0x3f | 0x80
+	return u
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxImportRows   = 10000
+	importBatchSize = 500
+)
+
+type (
+	importRow struct {
+		Title       string     `json:"title" validate:"required,max=500"`
+		IsCompleted bool       `json:"is_completed"`
+		List        string     `json:"list" validate:"max=200"`
+		Tags        []string   `json:"tags" validate:"dive,required,max=100"`
+		DueAt       *time.Time `json:"due_at"`
+	}
+	importResult struct {
+		Row    int    `bson:"row" json:"row"`
+		Status string `bson:"status" json:"status"`
+		TodoID string `bson:"todo_id,omitempty" json:"todo_id,omitempty"`
+		Error  string `bson:"error,omitempty" json:"error,omitempty"`
+	}
+	// importJobPayload is an import queued with ?async=true. Parse errors
+	// are keyed by row index.
+	importJobPayload struct {
+		Rows        []importRow       `bson:"rows"`
+		ParseErrors map[string]string `bson:"parse_errors,omitempty"`
+	}
+)
+
+// importTodos bulk-creates todos from a JSON array or, when the request is
+// sent as text/csv, a CSV file whose header names the columns (the same
+// layout the CSV export produces). Each row is validated on its own and the
+// response reports what happened to every row. With ?async=true the import
+// is queued as a job instead.
+func importTodos(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var rows []importRow
+	var parseErrs map[int]error
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		rows, parseErrs, err = parseImportCSV(r.Body)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&rows)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if len(rows) > maxImportRows {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{
+			"message": fmt.Sprintf("At most %d rows can be imported at once", maxImportRows),
+		})
+		return
+	}
+	if wantsAsync(r) {
+		payload := importJobPayload{Rows: rows, ParseErrors: map[string]string{}}
+		for i, err := range parseErrs {
+			payload.ParseErrors[strconv.Itoa(i)] = err.Error()
+		}
+		j, err := enqueueJob(ctx, "import", payload)
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+				"message": "Import failed",
+				"error":   err.Error(),
+			})
+			return
+		}
+		acceptedJob(w, j)
+		return
+	}
+
+	results, counts, err := insertImportRows(ctx, rows, parseErrs)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Import failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	status := http.StatusOK
+	if counts["created"] > 0 {
+		status = http.StatusCreated
+	}
+	rnd.JSON(w, status, renderer.M{
+		"message": "Import finished",
+		"created": counts["created"],
+		"skipped": counts["skipped"],
+		"failed":  counts["failed"],
+		"rows":    results,
+	})
+}
+
+func runImportJob(ctx context.Context, raw bson.Raw) (interface{}, error) {
+	var payload importJobPayload
+	if err := bson.Unmarshal(raw, &payload); err != nil {
+		return nil, err
+	}
+	parseErrs := map[int]error{}
+	for key, msg := range payload.ParseErrors {
+		i, _ := strconv.Atoi(key)
+		parseErrs[i] = errors.New(msg)
+	}
+	results, counts, err := insertImportRows(ctx, payload.Rows, parseErrs)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{
+		"created": counts["created"],
+		"skipped": counts["skipped"],
+		"failed":  counts["failed"],
+		"rows":    results,
+	}, nil
+}
+
+// insertImportRows validates rows and inserts the valid ones with unordered
+// bulk writes of importBatchSize rows, reporting progress after each when
+// running as a job. Row numbers in the results are 1-based.
+func insertImportRows(ctx context.Context, rows []importRow, parseErrs map[int]error) ([]importResult, map[string]int, error) {
+	results := make([]importResult, len(rows))
+	created := []todoModel{}
+	progress := jobProgress{Total: len(rows)}
+	for start := 0; start < len(rows); start += importBatchSize {
+		end := min(start+importBatchSize, len(rows))
+		batch, err := insertImportBatch(ctx, rows, parseErrs, start, end, results)
+		if err != nil {
+			return nil, nil, err
+		}
+		created = append(created, batch...)
+		progress.Processed = end
+		for _, res := range results[start:end] {
+			if res.Status == "failed" {
+				progress.Failed++
+				progress.Errors = append(progress.Errors, fmt.Sprintf("row %d: %s", res.Row, res.Error))
+			}
+		}
+		reportJobProgress(ctx, progress)
+	}
+	go todosCreated(created...)
+	go backfillTodos()
+	counts := map[string]int{"created": 0, "skipped": 0, "failed": 0}
+	for _, res := range results {
+		counts[res.Status]++
+	}
+	return results, counts, nil
+}
+
+// insertImportBatch imports rows[start:end], filling in their results, and
+// returns the todos it created.
+func insertImportBatch(ctx context.Context, rows []importRow, parseErrs map[int]error, start, end int, results []importResult) ([]todoModel, error) {
+	models := []mongo.WriteModel{}
+	modelRows := []int{}
+	modelTodos := []todoModel{}
+	for i := start; i < end; i++ {
+		row := rows[i]
+		results[i] = importResult{Row: i + 1}
+		if err := parseErrs[i]; err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			continue
+		}
+		row.Title = strings.TrimSpace(row.Title)
+		if row.Title == "" && row.List == "" && len(row.Tags) == 0 && row.DueAt == nil {
+			results[i].Status = "skipped"
+			continue
+		}
+		err := validate.Struct(&row)
+		if err == nil {
+			err = checkTodoContent(row.Title, row.Tags, nil)
+		}
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			continue
+		}
+		now := time.Now()
+		t := todoModel{
+			ID:          newTodoID(),
+			Title:       row.Title,
+			IsCompleted: row.IsCompleted,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			List:        row.List,
+			Tags:        row.Tags,
+			DueAt:       row.DueAt,
+		}
+		if t.IsCompleted {
+			t.CompletedAt = &now
+		}
+		models = append(models, mongo.NewInsertOneModel().SetDocument(t))
+		modelRows = append(modelRows, i)
+		modelTodos = append(modelTodos, t)
+		results[i].Status = "created"
+		results[i].TodoID = t.ID.Hex()
+	}
+	if len(models) > 0 {
+		_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, writeErr := range bulkErr.WriteErrors {
+				i := modelRows[writeErr.Index]
+				results[i].Status = "failed"
+				results[i].TodoID = ""
+				results[i].Error = writeErr.Message
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	created := []todoModel{}
+	for j, i := range modelRows {
+		if results[i].Status == "created" {
+			created = append(created, modelTodos[j])
+		}
+	}
+	return created, nil
+}
+
+// parseImportCSV reads rows keyed by the header line. Rows with values that
+// cannot be converted are still returned, with an entry in the error map, so
+// the report keeps the original row numbering.
+func parseImportCSV(body io.Reader) ([]importRow, map[int]error, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, nil, errors.New("csv header must contain a title column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := []importRow{}
+	parseErrs := map[int]error{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		row := importRow{
+			Title: csvUnescape(field(record, "title")),
+			List:  csvUnescape(field(record, "list")),
+		}
+		if tags := csvUnescape(field(record, "tags")); tags != "" {
+			for _, tag := range strings.Split(tags, ";") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					row.Tags = append(row.Tags, tag)
+				}
+			}
+		}
+		if completed := field(record, "is_completed"); completed != "" {
+			row.IsCompleted, err = strconv.ParseBool(completed)
+			if err != nil {
+				parseErrs[len(rows)] = fmt.Errorf("is_completed: %w", err)
+			}
+		}
+		if due := field(record, "due_at"); due != "" {
+			dueAt, err := time.Parse(time.RFC3339, due)
+			if err != nil {
+				parseErrs[len(rows)] = fmt.Errorf("due_at: %w", err)
+			}
+			row.DueAt = &dueAt
+		}
+		rows = append(rows, row)
+	}
+	return rows, parseErrs, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultIncludeLimit = 20
+	maxIncludeLimit     = 100
+)
+
+// todoRelation loads related data for a todo, returning at most limit items
+// for relations that are lists.
+type todoRelation func(ctx context.Context, t todoModel, limit int64) (interface{}, error)
+
+// todoRelations are what GET /todo/{id}?include= can expand.
+var todoRelations = map[string]todoRelation{
+	"list":         includeList,
+	"blockers":     includeBlockers,
+	"blocking":     includeBlocking,
+	"time_entries": includeTimeEntries,
+	"pomodoros":    includePomodoros,
+}
+
+// requestIncludes parses ?include=list,blockers and ?include_limit=.
+func requestIncludes(r *http.Request) ([]string, int64, error) {
+	q := r.URL.Query()
+	if q.Get("include") == "" {
+		return nil, 0, nil
+	}
+	names := []string{}
+	for _, name := range strings.Split(q.Get("include"), ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := todoRelations[name]; !ok {
+			supported := []string{}
+			for s := range todoRelations {
+				supported = append(supported, s)
+			}
+			sort.Strings(supported)
+			return nil, 0, fmt.Errorf("unknown relation %q, expected one of %s", name, strings.Join(supported, ", "))
+		}
+		names = append(names, name)
+	}
+	limit := int64(defaultIncludeLimit)
+	if raw := q.Get("include_limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 {
+			return nil, 0, errors.New("include_limit must be a positive integer")
+		}
+		limit = min(n, maxIncludeLimit)
+	}
+	return names, limit, nil
+}
+
+func expandTodo(ctx context.Context, t todoModel, names []string, limit int64) (map[string]interface{}, error) {
+	included := map[string]interface{}{}
+	for _, name := range names {
+		v, err := todoRelations[name](ctx, t, limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		included[name] = v
+	}
+	return included, nil
+}
+
+func includeList(ctx context.Context, t todoModel, _ int64) (interface{}, error) {
+	if t.List == "" {
+		return nil, nil
+	}
+	settings := listSettings{Name: t.List}
+	err := listsCollection.FindOne(ctx, bson.M{"name": t.List}).Decode(&settings)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func includeBlockers(ctx context.Context, t todoModel, limit int64) (interface{}, error) {
+	if len(t.BlockedBy) == 0 {
+		return []todo{}, nil
+	}
+	return findTodos(ctx, bson.M{"_id": bson.M{"$in": t.BlockedBy}}, limit)
+}
+
+func includeBlocking(ctx context.Context, t todoModel, limit int64) (interface{}, error) {
+	return findTodos(ctx, bson.M{"blocked_by": t.ID}, limit)
+}
+
+func findTodos(ctx context.Context, filter bson.M, limit int64) ([]todo, error) {
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"sort_key": 1}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		return nil, err
+	}
+	todoList := []todo{}
+	for _, t := range todos {
+		todoList = append(todoList, newTodo(t))
+	}
+	return todoList, nil
+}
+
+func includeTimeEntries(ctx context.Context, t todoModel, limit int64) (interface{}, error) {
+	cur, err := timeEntriesCollection.Find(ctx, bson.M{"todo_id": t.ID},
+		options.Find().SetSort(bson.M{"begin": -1}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	entries := []timeEntry{}
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func includePomodoros(ctx context.Context, t todoModel, limit int64) (interface{}, error) {
+	cur, err := pomodorosCollection.Find(ctx, bson.M{"todo_id": t.ID},
+		options.Find().SetSort(bson.M{"started_at": -1}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	pomodoros := []pomodoro{}
+	if err := cur.All(ctx, &pomodoros); err != nil {
+		return nil, err
+	}
+	return pomodoros, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// todoIndexes are created on startup. Creating an index that already exists is
+// a no-op, so this is safe to run on every boot.
+var todoIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "createdat", Value: 1}}},
+	{Keys: bson.D{{Key: "updatedat", Value: 1}}},
+	{Keys: bson.D{{Key: "completed_at", Value: 1}}},
+	{Keys: bson.D{{Key: "due_at", Value: 1}}},
+	{Keys: bson.D{{Key: "reminder_at", Value: 1}}},
+	{Keys: bson.D{{Key: "sort_key", Value: 1}}},
+	{Keys: bson.D{{Key: "title_key", Value: 1}, {Key: "createdat", Value: -1}}},
+	{Keys: bson.D{{Key: "tags", Value: 1}}},
+	{Keys: bson.D{{Key: "title_grams", Value: 1}}},
+	{Keys: bson.D{{Key: "blocked_by", Value: 1}}},
+	{Keys: bson.D{{Key: "location.point", Value: "2dsphere"}}},
+}
+
+type collectionIndexes struct {
+	collection *mongo.Collection
+	indexes    []mongo.IndexModel
+}
+
+// requiredIndexes lists the indexes of every collection, for ensureIndexes
+// and the doctor command.
+func requiredIndexes() []collectionIndexes {
+	return []collectionIndexes{
+		{collection, todoIndexes},
+		{customFieldsCollection, []mongo.IndexModel{{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}}},
+		{timeEntriesCollection, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "todo_id", Value: 1}, {Key: "begin", Value: 1}}},
+			{Keys: bson.D{{Key: "begin", Value: 1}}},
+			{
+				Keys: bson.D{{Key: "todo_id", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"running": true}),
+			},
+		}},
+		{pomodorosCollection, []mongo.IndexModel{{
+			Keys: bson.D{{Key: "completed_at", Value: 1}},
+		}}},
+		{historyCollection, []mongo.IndexModel{{
+			Keys: bson.D{{Key: "todo_id", Value: 1}, {Key: "at", Value: -1}},
+		}}},
+		{jobsCollection, jobIndexes},
+		{automationsCollection, automationIndexes},
+		{rulesCollection, ruleIndexes},
+		{ruleRunsCollection, ruleRunIndexes},
+		{tombstonesCollection, tombstoneIndexes},
+		{operationsCollection, operationIndexes},
+		{listsCollection, []mongo.IndexModel{{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}}},
+	}
+}
+
+func ensureIndexes() {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, c := range requiredIndexes() {
+		if _, err := c.collection.Indexes().CreateMany(ctx, c.indexes); err != nil {
+			log.Printf("indexes: %s\n", err)
+		}
+	}
+}
+
+// backfillTodos fills in derived fields on todos written without them, either
+// before the field existed or by a bulk import.
+func backfillTodos() {
+	backfillSortKeys()
+	backfillTitleKeys()
+	backfillTitleGrams()
+	backfillUpdatedAt()
+}
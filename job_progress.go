@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// Jobs that report progress store it on the job document, so any instance
+// can stream it to a client with GET /jobs/{id}/progress as server-sent
+// events: a progress event whenever it changes and a done event with the
+// job once it has finished.
+const (
+	jobProgressPoll      = time.Second
+	jobProgressHeartbeat = 15 * time.Second
+	// maxProgressErrors caps the errors kept in the progress. The job result
+	// still has all of them.
+	maxProgressErrors = 100
+)
+
+type jobProgress struct {
+	Processed int       `bson:"processed" json:"processed"`
+	Total     int       `bson:"total" json:"total"`
+	Failed    int       `bson:"failed" json:"failed"`
+	Errors    []string  `bson:"errors,omitempty" json:"errors,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+type jobIDKey struct{}
+
+func withJobID(ctx context.Context, id primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, jobIDKey{}, id)
+}
+
+// reportJobProgress stores p on the job ctx runs, if any. Failing to store it
+// is logged and does not fail the job.
+func reportJobProgress(ctx context.Context, p jobProgress) {
+	id, ok := ctx.Value(jobIDKey{}).(primitive.ObjectID)
+	if !ok {
+		return
+	}
+	if len(p.Errors) > maxProgressErrors {
+		p.Errors = p.Errors[:maxProgressErrors]
+	}
+	p.UpdatedAt = time.Now()
+	if _, err := jobsCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"progress": p}}); err != nil {
+		log.Printf("jobs: progress of %s: %s\n", id.Hex(), err)
+	}
+}
+
+func streamJobProgress(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	j, err := loadJob(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+		return
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("jobs: %s\n", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	poll := time.NewTicker(jobProgressPoll)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(jobProgressHeartbeat)
+	defer heartbeat.Stop()
+	var sent *jobProgress
+	for {
+		if j.Progress != nil && !reflect.DeepEqual(j.Progress, sent) {
+			if writeEvent(w, "progress", j.Progress) != nil {
+				return
+			}
+			sent = j.Progress
+		}
+		if j.Status == jobSucceeded || j.Status == jobDead {
+			writeEvent(w, "done", j)
+			rc.Flush()
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			continue
+		case <-poll.C:
+		}
+		if j, err = loadJob(ctx, id); err != nil {
+			return
+		}
+	}
+}
+
+func loadJob(ctx context.Context, id primitive.ObjectID) (job, error) {
+	var j job
+	err := jobsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&j)
+	return j, err
+}
+
+// writeEvent writes one server-sent event with v as JSON data.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Heavy operations can run as jobs: the request stores a job and returns
+// 202 with its URL, and a worker runs it. A job that fails is retried with
+// backoff up to its max attempts, unless the failure is permanent, and then
+// left as dead until an admin retries it by hand. A worker that dies
+// mid-job loses its lease and the job is picked up again once the lease runs
+// out. Finished jobs are removed after jobKeepFinished, dead ones after
+// jobKeepDead.
+const (
+	jobsCollectionName = "jobs"
+	jobPollInterval    = 2 * time.Second
+	jobRetryBackoff    = 30 * time.Second
+	jobKeepFinished    = 7 * 24 * time.Hour
+	jobKeepDead        = 30 * 24 * time.Hour
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobDead      = "dead"
+)
+
+var jobsCollection *mongo.Collection
+
+type job struct {
+	ID          primitive.ObjectID `bson:"_id" json:"_id"`
+	Type        string             `bson:"type" json:"type"`
+	Status      string             `bson:"status" json:"status"`
+	Payload     bson.Raw           `bson:"payload,omitempty" json:"-"`
+	Result      interface{}        `bson:"result,omitempty" json:"result,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	Progress    *jobProgress       `bson:"progress,omitempty" json:"progress,omitempty"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	MaxAttempts int                `bson:"max_attempts" json:"max_attempts"`
+	RunAt       time.Time          `bson:"run_at" json:"run_at"`
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	FinishedAt  *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"-"`
+}
+
+// jobType is how a kind of job runs. timeout doubles as the lease, so a job
+// is given up on before another worker may take it over. Jobs of an
+// adminOnly type are only visible under /admin/jobs, and noRetry jobs cannot
+// be retried by hand either.
+type jobType struct {
+	run         func(ctx context.Context, payload bson.Raw) (interface{}, error)
+	timeout     time.Duration
+	maxAttempts int
+	adminOnly   bool
+	noRetry     bool
+}
+
+// permanentError marks a job failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error { return permanentError{err} }
+
+var jobTypes map[string]jobType
+
+// The job types are set in init because jobs may queue further jobs, such
+// as an import sending email notifications.
+func init() {
+	jobTypes = map[string]jobType{
+		// Imports are not retried: a failed import may have created some of
+		// its todos, and running it again would duplicate them.
+		"import": {run: runImportJob, timeout: 10 * time.Minute, maxAttempts: 1, noRetry: true},
+		"backup": {run: runBackupJob, timeout: time.Hour, maxAttempts: 3, adminOnly: true},
+		"email":  {run: runEmailJob, timeout: time.Minute, maxAttempts: 5, adminOnly: true},
+		"push":   {run: runPushJob, timeout: time.Minute, maxAttempts: 5, adminOnly: true},
+	}
+}
+
+var jobIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+	{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	},
+}
+
+func enqueueJob(ctx context.Context, kind string, payload interface{}) (job, error) {
+	jt, ok := jobTypes[kind]
+	if !ok {
+		return job{}, fmt.Errorf("unknown job type %q", kind)
+	}
+	now := time.Now()
+	j := job{
+		ID:          primitive.NewObjectID(),
+		Type:        kind,
+		Status:      jobQueued,
+		MaxAttempts: jt.maxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+	}
+	if payload != nil {
+		raw, err := bson.Marshal(payload)
+		if err != nil {
+			return job{}, err
+		}
+		j.Payload = raw
+	}
+	_, err := jobsCollection.InsertOne(ctx, j)
+	return j, err
+}
+
+// acceptedJob answers a request whose work was queued.
+func acceptedJob(w http.ResponseWriter, j job) {
+	location := apiPrefix("v1") + "/jobs/"
+	if jobTypes[j.Type].adminOnly {
+		location = apiPrefix("v1") + "/admin/jobs/"
+	}
+	w.Header().Set("Location", location+j.ID.Hex())
+	rnd.JSON(w, http.StatusAccepted, renderer.M{
+		"message": "Job queued",
+		"data":    j,
+	})
+}
+
+// wantsAsync reports whether the client asked for the work to be queued,
+// with ?async=true or Prefer: respond-async.
+func wantsAsync(r *http.Request) bool {
+	return r.URL.Query().Get("async") == "true" || r.Header.Get("Prefer") == "respond-async"
+}
+
+// jobWorker runs queued jobs one at a time until ctx is done. It pauses
+// during maintenance.
+func jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if enabled, _, _ := maintenanceState(); enabled {
+			continue
+		}
+		for ctx.Err() == nil {
+			j, err := claimJob(ctx)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				break
+			}
+			if err != nil {
+				log.Printf("jobs: %s\n", err)
+				break
+			}
+			runJob(ctx, j)
+		}
+	}
+}
+
+// claimJob takes the oldest due job, or a running one whose lease expired.
+func claimJob(ctx context.Context) (job, error) {
+	now := time.Now()
+	var j job
+	err := jobsCollection.FindOne(ctx, bson.M{"$or": bson.A{
+		bson.M{"status": jobQueued, "run_at": bson.M{"$lte": now}},
+		bson.M{"status": jobRunning, "locked_until": bson.M{"$lt": now}},
+	}}, options.FindOne().SetSort(bson.M{"run_at": 1})).Decode(&j)
+	if err != nil {
+		return j, err
+	}
+	jt := jobTypes[j.Type]
+	lease := now.Add(jt.timeout)
+	// Claim it only if no other worker did in the meantime.
+	err = jobsCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": j.ID, "status": j.Status, "attempts": j.Attempts},
+		bson.M{
+			"$set":   bson.M{"status": jobRunning, "locked_until": lease},
+			"$inc":   bson.M{"attempts": 1},
+			"$unset": bson.M{"progress": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&j)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return claimJob(ctx)
+	}
+	return j, err
+}
+
+func runJob(ctx context.Context, j job) {
+	jt, ok := jobTypes[j.Type]
+	var result interface{}
+	err := fmt.Errorf("unknown job type %q", j.Type)
+	if j.Attempts > j.MaxAttempts {
+		// Reclaimed after its lease ran out on the last attempt.
+		err = errors.New("the worker stopped during the last attempt")
+	} else if ok {
+		runCtx, cancel := context.WithTimeout(withJobID(ctx, j.ID), jt.timeout)
+		result, err = jt.run(runCtx, j.Payload)
+		cancel()
+	}
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"status": jobSucceeded, "result": result, "finished_at": now,
+		"expires_at": now.Add(jobKeepFinished)},
+		"$unset": bson.M{"locked_until": "", "error": ""}}
+	switch {
+	case err == nil:
+	case j.Attempts < j.MaxAttempts && !errors.As(err, new(permanentError)):
+		backoff := jobRetryBackoff << (j.Attempts - 1)
+		update = bson.M{"$set": bson.M{"status": jobQueued, "error": err.Error(), "run_at": now.Add(backoff)},
+			"$unset": bson.M{"locked_until": ""}}
+	default:
+		update = bson.M{"$set": bson.M{"status": jobDead, "error": err.Error(), "finished_at": now,
+			"expires_at": now.Add(jobKeepDead)},
+			"$unset": bson.M{"locked_until": ""}}
+		log.Printf("jobs: %s job %s is dead after %d attempts: %s\n", j.Type, j.ID.Hex(), j.Attempts, err)
+	}
+	// Record the outcome even when ctx was cancelled by shutdown.
+	writeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := jobsCollection.UpdateOne(writeCtx, bson.M{"_id": j.ID}, update); err != nil {
+		log.Printf("jobs: %s\n", err)
+	}
+}
+
+// jobHandlers let clients follow the jobs they queued. Listing and retrying
+// jobs is left to adminJobHandlers.
+func jobHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Use(hideAdminJobs)
+	r.Get("/{id}", fetchJob)
+	r.Get("/{id}/progress", streamJobProgress)
+	return r
+}
+
+func adminJobHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listJobs)
+	r.Get("/{id}", fetchJob)
+	r.Get("/{id}/progress", streamJobProgress)
+	r.Post("/{id}/retry", retryJob)
+	return r
+}
+
+// hideAdminJobs answers 404 for jobs of an adminOnly type, such as backups
+// and outgoing email.
+func hideAdminJobs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		var j job
+		err = jobsCollection.FindOne(ctx, bson.M{"_id": id},
+			options.FindOne().SetProjection(bson.M{"type": 1})).Decode(&j)
+		if err == nil && jobTypes[j.Type].adminOnly {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listJobs returns the latest jobs, optionally only those with ?status=,
+// such as ?status=dead for the dead letters.
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	cur, err := jobsCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(100))
+	jobs := []job{}
+	if err == nil {
+		err = cur.All(ctx, &jobs)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch jobs",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": jobs,
+	})
+}
+
+func fetchJob(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var j job
+	err = jobsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&j)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch job",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": j,
+	})
+}
+
+// retryJob queues a dead job again with a fresh set of attempts. Job types
+// marked noRetry, such as imports, are refused.
+func retryJob(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	noRetry := []string{}
+	for kind, jt := range jobTypes {
+		if jt.noRetry {
+			noRetry = append(noRetry, kind)
+		}
+	}
+	var j job
+	err = jobsCollection.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": jobDead, "type": bson.M{"$nin": noRetry}},
+		bson.M{"$set": bson.M{"status": jobQueued, "attempts": 0, "run_at": time.Now()},
+			"$unset": bson.M{"finished_at": "", "expires_at": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&j)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusConflict, renderer.M{
+			"message": "Only dead jobs can be retried, and imports never are",
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to retry job",
+			"error":   err.Error(),
+		})
+		return
+	}
+	acceptedJob(w, j)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// JSON:API output is opt-in with Accept: application/vnd.api+json. Todos
+// become "todos" resources whose list, tags and blockers are relationships;
+// lists and tags are returned once each under included. Responses that carry
+// no todos keep their fields under meta, and failures become an errors array.
+const jsonAPIContentType = "application/vnd.api+json"
+
+type jsonAPIResource struct {
+	Type          string                 `json:"type"`
+	ID            string                 `json:"id"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Relationships map[string]interface{} `json:"relationships,omitempty"`
+	Links         map[string]string      `json:"links,omitempty"`
+}
+
+type jsonAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func jsonToJSONAPI(status int, body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	if status >= http.StatusBadRequest {
+		return json.Marshal(map[string]interface{}{"errors": []map[string]interface{}{jsonAPIError(status, fields)}})
+	}
+
+	doc := map[string]interface{}{}
+	included := map[jsonAPIIdentifier]jsonAPIResource{}
+	switch data := fields["data"].(type) {
+	case map[string]interface{}:
+		if isTodoJSON(data) {
+			doc["data"] = todoResource(data, included)
+			delete(fields, "data")
+		}
+	case []interface{}:
+		resources := []jsonAPIResource{}
+		for _, item := range data {
+			t, ok := item.(map[string]interface{})
+			if !ok || !isTodoJSON(t) {
+				resources = nil
+				break
+			}
+			resources = append(resources, todoResource(t, included))
+		}
+		if resources != nil {
+			doc["data"] = resources
+			delete(fields, "data")
+		}
+	}
+	if len(included) > 0 {
+		resources := make([]jsonAPIResource, 0, len(included))
+		for _, res := range included {
+			resources = append(resources, res)
+		}
+		sort.Slice(resources, func(i, j int) bool {
+			if resources[i].Type != resources[j].Type {
+				return resources[i].Type < resources[j].Type
+			}
+			return resources[i].ID < resources[j].ID
+		})
+		doc["included"] = resources
+	}
+	if links, ok := fields["_links"].(map[string]interface{}); ok {
+		doc["links"] = flattenLinks(links)
+		delete(fields, "_links")
+	}
+	if len(fields) > 0 {
+		doc["meta"] = fields
+	}
+	if _, ok := doc["data"]; !ok {
+		if _, ok := doc["meta"]; !ok {
+			doc["meta"] = map[string]interface{}{}
+		}
+	}
+	return json.Marshal(doc)
+}
+
+func jsonAPIError(status int, fields map[string]interface{}) map[string]interface{} {
+	e := map[string]interface{}{"status": strconv.Itoa(status)}
+	if code, ok := fields["code"]; ok {
+		e["code"] = code
+	}
+	if message, ok := fields["message"]; ok {
+		e["title"] = message
+	}
+	if detail, ok := fields["error"]; ok {
+		if s, isString := detail.(string); isString {
+			e["detail"] = s
+		} else {
+			e["detail"] = fmt.Sprint(detail)
+		}
+	}
+	return e
+}
+
+func isTodoJSON(t map[string]interface{}) bool {
+	_, hasID := t["_id"].(string)
+	_, hasTitle := t["title"]
+	return hasID && hasTitle
+}
+
+// todoResource converts a todo and records its list and tags in included.
+func todoResource(t map[string]interface{}, included map[jsonAPIIdentifier]jsonAPIResource) jsonAPIResource {
+	res := jsonAPIResource{
+		Type:          "todos",
+		ID:            t["_id"].(string),
+		Attributes:    map[string]interface{}{},
+		Relationships: map[string]interface{}{},
+	}
+	related := func(typ, id string) jsonAPIIdentifier {
+		ref := jsonAPIIdentifier{typ, id}
+		if typ != "todos" {
+			included[ref] = jsonAPIResource{Type: typ, ID: id, Attributes: map[string]interface{}{"name": id}}
+		}
+		return ref
+	}
+	list, _ := t["list"].(string)
+	if list != "" {
+		res.Relationships["list"] = map[string]interface{}{"data": related("lists", list)}
+	} else {
+		res.Relationships["list"] = map[string]interface{}{"data": nil}
+	}
+	for _, rel := range []struct{ field, name, typ string }{
+		{"tags", "tags", "tags"},
+		{"blocked_by", "blockers", "todos"},
+	} {
+		refs := []jsonAPIIdentifier{}
+		items, _ := t[rel.field].([]interface{})
+		for _, item := range items {
+			if id, ok := item.(string); ok {
+				refs = append(refs, related(rel.typ, id))
+			}
+		}
+		res.Relationships[rel.name] = map[string]interface{}{"data": refs}
+	}
+	if links, ok := t["_links"].(map[string]interface{}); ok {
+		res.Links = flattenLinks(links)
+	}
+	for k, v := range t {
+		switch k {
+		case "_id", "_links", "list", "tags", "blocked_by":
+		default:
+			res.Attributes[k] = v
+		}
+	}
+	return res
+}
+
+// flattenLinks turns {"self": {"href": "..."}} into {"self": "..."}.
+func flattenLinks(links map[string]interface{}) map[string]string {
+	flat := map[string]string{}
+	for rel, l := range links {
+		if m, ok := l.(map[string]interface{}); ok {
+			if href, ok := m["href"].(string); ok {
+				flat[rel] = href
+			}
+		}
+	}
+	return flat
+}
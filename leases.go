@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Schedulers that must run on one instance at a time hold a lease in the
+// leases collection. The holder renews it every third of TODO_LEASE_TTL; if
+// it dies, another instance takes over once the lease expires.
+const leasesCollectionName = "leases"
+
+var (
+	leasesCollection *mongo.Collection
+	leaseTTL         = loadLeaseTTL()
+	instanceID       = newInstanceID()
+)
+
+func loadLeaseTTL() time.Duration {
+	d, err := time.ParseDuration(env("TODO_LEASE_TTL", "30s"))
+	if err != nil || d < 3*time.Second {
+		return 30 * time.Second
+	}
+	return d
+}
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// acquireLease takes or renews the named lease for this instance. It
+// reports false while another instance holds it.
+func acquireLease(ctx context.Context, name string) (bool, error) {
+	now := time.Now()
+	_, err := leasesCollection.UpdateOne(ctx,
+		bson.M{"_id": name, "$or": bson.A{bson.M{"holder": instanceID}, bson.M{"expires_at": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"holder": instanceID, "expires_at": now.Add(leaseTTL)}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease exists and is held by someone else, so the upsert
+		// tried to insert a second one.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func releaseLease(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := leasesCollection.DeleteOne(ctx, bson.M{"_id": name, "holder": instanceID}); err != nil {
+		log.Printf("lease %s: %s\n", name, err)
+	}
+}
+
+// runAsLeader runs job while this instance holds the named lease, waiting
+// for it when another instance has it. job is cancelled as soon as the
+// lease cannot be renewed. When job returns on its own the lease is given
+// up for good.
+func runAsLeader(ctx context.Context, name string, job func(context.Context)) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+	var cancel context.CancelFunc
+	// done is closed when job returns; it is nil while job is not running.
+	var done chan struct{}
+	for {
+		held, err := acquireLease(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("lease %s: %s\n", name, err)
+		}
+		switch {
+		case held && cancel == nil:
+			log.Printf("lease %s: acquired by %s\n", name, instanceID)
+			var jobCtx context.Context
+			jobCtx, cancel = context.WithCancel(ctx)
+			done = make(chan struct{})
+			go func(done chan struct{}) {
+				defer close(done)
+				job(jobCtx)
+			}(done)
+		case !held && cancel != nil:
+			log.Printf("lease %s: lost\n", name)
+			cancel()
+			<-done
+			cancel, done = nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			if cancel != nil {
+				cancel()
+				<-done
+				releaseLease(name)
+			}
+			return
+		case <-done:
+			cancel()
+			releaseLease(name)
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+)
+
+// link is a HAL style hypermedia link. Clients should follow these rather
+// than building URLs themselves.
+type link struct {
+	Href string `json:"href"`
+}
+
+// todoLinks are the actions available on a single todo.
+func todoLinks(id string) map[string]link {
+	self := apiPrefix("v1") + "/todo/" + id
+	return map[string]link{
+		"self":         {self},
+		"toggle":       {self + "/toggle"},
+		"pin":          {self + "/pin"},
+		"star":         {self + "/star"},
+		"move":         {self + "/move"},
+		"clone":        {self + "/clone"},
+		"snooze":       {self + "/snooze"},
+		"time_entries": {self + "/time-entries"},
+		"blockers":     {self + "/blockers"},
+		"archive":      {self + "/archive"},
+		"history":      {self + "/history"},
+	}
+}
+
+// collectionLinks links a list response to itself and, when it is
+// paginated, to its neighbouring pages.
+func collectionLinks(r *http.Request, p page, paginated bool, total int64) map[string]link {
+	links := map[string]link{
+		"self": {r.URL.RequestURI()},
+	}
+	if paginated {
+		for _, l := range pageLinks(r, p, total) {
+			links[l.rel] = link{l.href}
+		}
+	}
+	return links
+}
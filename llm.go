@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+const subtaskPrompt = "Break the user's task into 3 to 7 short, concrete subtasks. " +
+	"Reply with a JSON array of strings and nothing else."
+
+// llmConfig points at an OpenAI compatible chat completions endpoint. The
+// feature is off unless TODO_LLM_URL is set.
+type llmConfig struct {
+	URL    string
+	APIKey string
+	Model  string
+}
+
+var (
+	llm        = llmConfig{URL: env("TODO_LLM_URL", ""), APIKey: mustSecret("TODO_LLM_API_KEY", ""), Model: env("TODO_LLM_MODEL", "gpt-4o-mini")}
+	llmLimiter = newRateLimiter(llmRatePerMinute(), time.Minute)
+)
+
+func llmRatePerMinute() int {
+	n, err := strconv.Atoi(env("TODO_LLM_RATE", "10"))
+	if err != nil || n < 1 {
+		return 10
+	}
+	return n
+}
+
+// suggestSubtasks asks the configured LLM to split a todo into smaller steps.
+// Nothing is saved: clients accept suggestions by sending them back as the
+// todo's checklist.
+func suggestSubtasks(w http.ResponseWriter, r *http.Request) {
+	if llm.URL == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !llmLimiter.allow(w, time.Now()) {
+		rnd.JSON(w, http.StatusTooManyRequests, renderer.M{
+			"message": "Too many suggestion requests, try again in a minute",
+		})
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	var t todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, renderer.M{
+			"message": "Todo not found",
+		})
+		return
+	}
+	suggestions, err := llm.complete(ctx, subtaskPrompt, t.Title)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadGateway, renderer.M{
+			"message": "Failed to get suggestions",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"todo_id":     t.ID.Hex(),
+		"suggestions": parseSuggestions(suggestions),
+	})
+}
+
+func (c llmConfig) complete(ctx context.Context, system, user string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": c.Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("llm provider returned %s", res.Status)
+	}
+	var out struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if len(out.Choices) == 0 {
+		return "", errors.New("llm provider returned no choices")
+	}
+	return out.Choices[0].Message.Content, nil
+}
+
+// parseSuggestions reads the JSON array the prompt asks for, falling back to
+// one suggestion per line for models that answer with a plain list.
+func parseSuggestions(content string) []string {
+	suggestions := []string{}
+	content = strings.TrimSpace(content)
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "```json"), "```")
+	if err := json.Unmarshal([]byte(content), &suggestions); err == nil {
+		return suggestions
+	}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*0123456789.) "))
+		if line != "" {
+			suggestions = append(suggestions, line)
+		}
+	}
+	return suggestions
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultGeofenceMeters = 100
+	maxGeofenceMeters     = 100000
+	maxNearMeters         = 50000
+)
+
+type (
+	// todoLocation is a geofence for location based reminders. Clients send
+	// lat and lng; the coordinates are stored as a GeoJSON point so they can
+	// be queried through a 2dsphere index.
+	todoLocation struct {
+		Label  string   `bson:"label,omitempty" json:"label,omitempty"`
+		Lat    float64  `bson:"-" json:"lat"`
+		Lng    float64  `bson:"-" json:"lng"`
+		Radius float64  `bson:"radius" json:"radius"`
+		Point  geoPoint `bson:"point" json:"-"`
+	}
+	geoPoint struct {
+		Type        string    `bson:"type"`
+		Coordinates []float64 `bson:"coordinates"`
+	}
+)
+
+// check validates the coordinates sent by a client and fills in the point.
+func (l *todoLocation) check() error {
+	if l.Lat < -90 || l.Lat > 90 || l.Lng < -180 || l.Lng > 180 {
+		return errors.New("lat must be within ±90 and lng within ±180")
+	}
+	if l.Radius == 0 {
+		l.Radius = defaultGeofenceMeters
+	}
+	if l.Radius < 0 || l.Radius > maxGeofenceMeters {
+		return errors.New("radius must be between 1 and 100000 meters")
+	}
+	l.Point = geoPoint{Type: "Point", Coordinates: []float64{l.Lng, l.Lat}}
+	return nil
+}
+
+// forClient copies the stored point back into lat and lng.
+func (l *todoLocation) forClient() *todoLocation {
+	if l == nil || len(l.Point.Coordinates) != 2 {
+		return l
+	}
+	out := *l
+	out.Lng, out.Lat = l.Point.Coordinates[0], l.Point.Coordinates[1]
+	return &out
+}
+
+// nearTodos returns todos whose location is within ?radius= meters (1000 by
+// default) of ?lat= and ?lng=, nearest first. The usual list filters apply.
+func nearTodos(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, latErr := strconv.ParseFloat(q.Get("lat"), 64)
+	lng, lngErr := strconv.ParseFloat(q.Get("lng"), 64)
+	radius := 1000.0
+	var radiusErr error
+	if v := q.Get("radius"); v != "" {
+		radius, radiusErr = strconv.ParseFloat(v, 64)
+	}
+	if latErr != nil || lngErr != nil || radiusErr != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 || radius <= 0 || radius > maxNearMeters {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "lat and lng are required and radius must be between 1 and 50000 meters",
+		})
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	filter["location.point"] = bson.M{"$near": bson.M{
+		"$geometry":    geoPoint{Type: "Point", Coordinates: []float64{lng, lat}},
+		"$maxDistance": radius,
+	}}
+	cur, err := collection.Find(ctx, filter)
+	todos := []todoModel{}
+	if err == nil {
+		err = cur.All(ctx, &todos)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	todoList := []todo{}
+	for _, t := range todos {
+		todoList = append(todoList, newTodo(t))
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": todoList,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const maxLookupIDs = 500
+
+// lookupTodos answers POST /todo/lookup with {"ids": [...]}. GET /todo/?ids=
+// is the same lookup for clients that prefer a query string.
+func lookupTodos(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	writeLookup(w, r, body.IDs)
+}
+
+// writeLookup fetches the todos with a single $in query and returns them in
+// the order the IDs were given. IDs that match no todo are listed under
+// missing.
+func writeLookup(w http.ResponseWriter, r *http.Request, hexes []string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if len(hexes) == 0 || len(hexes) > maxLookupIDs {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": fmt.Sprintf("give between 1 and %d ids", maxLookupIDs),
+		}))
+		return
+	}
+	ids := make([]todoID, len(hexes))
+	for i, hex := range hexes {
+		id, err := parseTodoID(strings.TrimSpace(hex))
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+				"error": fmt.Sprintf("%q is not a todo ID", hex),
+			}))
+			return
+		}
+		ids[i] = id
+	}
+	cur, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	todos := []todoModel{}
+	if err == nil {
+		err = cur.All(ctx, &todos)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	byID := map[todoID]todoModel{}
+	for _, t := range todos {
+		byID[t.ID] = t
+	}
+	todoList := []todo{}
+	missing := []string{}
+	for _, id := range ids {
+		if t, ok := byID[id]; ok {
+			todoList = append(todoList, newTodo(t))
+		} else {
+			missing = append(missing, id.Hex())
+		}
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":    todoList,
+		"missing": missing,
+	})
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +28,10 @@ import (
 
 var rnd *renderer.Render
 var collection *mongo.Collection
+
+// listCollection is the todo collection used for list queries, which may be
+// routed to secondaries with TODO_LIST_READ_PREFERENCE.
+var listCollection *mongo.Collection
 var validate = validator.New()
 
 const (
@@ -35,39 +43,207 @@ const (
 
 type (
 	todoModel struct {
-		ID          primitive.ObjectID `bson:"_id"`
-		Title       string             `json:"title"`
-		IsCompleted bool               `json:"is_completed" validate:"required"`
-		CreatedAt   time.Time          `json:"created_at" validate:"required"`
-		UpdatedAt   time.Time          `json:"updated_at"`
+		ID           todoID                 `bson:"_id"`
+		Title        string                 `json:"title"`
+		IsCompleted  bool                   `json:"is_completed" validate:"required"`
+		CreatedAt    time.Time              `json:"created_at" validate:"required"`
+		UpdatedAt    time.Time              `json:"updated_at"`
+		List         string                 `bson:"list,omitempty" json:"list,omitempty"`
+		Tags         []string               `bson:"tags,omitempty" json:"tags,omitempty"`
+		Checklist    []checklistItem        `bson:"checklist,omitempty" json:"checklist,omitempty"`
+		ReminderAt   *time.Time             `bson:"reminder_at,omitempty" json:"reminder_at,omitempty"`
+		DueAt        *time.Time             `bson:"due_at,omitempty" json:"due_at,omitempty"`
+		CompletedAt  *time.Time             `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+		CustomFields map[string]interface{} `bson:"custom_fields,omitempty" json:"custom_fields,omitempty"`
+		Status       string                 `bson:"status,omitempty" json:"status,omitempty"`
+		SortKey      string                 `bson:"sort_key,omitempty" json:"sort_key,omitempty"`
+		Pinned       bool                   `bson:"pinned,omitempty" json:"pinned,omitempty"`
+		Starred      bool                   `bson:"starred,omitempty" json:"starred,omitempty"`
+		Color        string                 `bson:"color,omitempty" json:"color,omitempty"`
+		TitleKey     string                 `bson:"title_key,omitempty" json:"-"`
+		TitleGrams   []string               `bson:"title_grams,omitempty" json:"-"`
+		Tracked      int64                  `bson:"tracked_seconds,omitempty" json:"tracked_seconds,omitempty"`
+		Pomodoros    int                    `bson:"pomodoros,omitempty" json:"pomodoros,omitempty"`
+		Estimated    *int                   `bson:"estimated_minutes,omitempty" json:"estimated_minutes,omitempty"`
+		Remaining    *int                   `bson:"remaining_minutes,omitempty" json:"remaining_minutes,omitempty"`
+		BlockedBy    []todoID               `bson:"blocked_by,omitempty" json:"blocked_by,omitempty"`
+		Blocked      bool                   `bson:"blocked,omitempty" json:"blocked,omitempty"`
+		Overdue      bool                   `bson:"overdue,omitempty" json:"overdue,omitempty"`
+		Escalations  int                    `bson:"escalations,omitempty" json:"escalations,omitempty"`
+		EscalatedAt  *time.Time             `bson:"escalated_at,omitempty" json:"escalated_at,omitempty"`
+		Snoozes      []snooze               `bson:"snoozes,omitempty" json:"snoozes,omitempty"`
+		Location     *todoLocation          `bson:"location,omitempty" json:"location,omitempty"`
+		Archived     bool                   `bson:"archived,omitempty" json:"archived,omitempty"`
+		ArchivedAt   *time.Time             `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
 	}
 	todo struct {
-		ID          string    `json:"_id"`
-		Title       string    `json:"title"`
-		IsCompleted bool      `json:"is_completed"`
-		CreatedAt   time.Time `json:"created_at"`
-		UpdatedAt   time.Time `json:"updated_at"`
+		ID           string                 `json:"_id"`
+		Title        string                 `json:"title"`
+		IsCompleted  bool                   `json:"is_completed"`
+		CreatedAt    time.Time              `json:"created_at"`
+		UpdatedAt    time.Time              `json:"updated_at"`
+		List         string                 `json:"list,omitempty"`
+		Tags         []string               `json:"tags,omitempty"`
+		Checklist    []checklistItem        `json:"checklist,omitempty"`
+		ReminderAt   *time.Time             `json:"reminder_at,omitempty"`
+		DueAt        *time.Time             `json:"due_at,omitempty"`
+		CompletedAt  *time.Time             `json:"completed_at,omitempty"`
+		CustomFields map[string]interface{} `json:"custom_fields,omitempty"`
+		Status       string                 `json:"status,omitempty"`
+		SortKey      string                 `json:"sort_key,omitempty"`
+		Pinned       bool                   `json:"pinned"`
+		Starred      bool                   `json:"starred"`
+		Color        string                 `json:"color,omitempty"`
+		Tracked      int64                  `json:"tracked_seconds,omitempty"`
+		Pomodoros    int                    `json:"pomodoros,omitempty"`
+		Estimated    *int                   `json:"estimated_minutes,omitempty"`
+		Remaining    *int                   `json:"remaining_minutes,omitempty"`
+		BlockedBy    []string               `json:"blocked_by,omitempty"`
+		Blocked      bool                   `json:"blocked"`
+		Overdue      bool                   `json:"overdue"`
+		Snoozes      []snooze               `json:"snoozes,omitempty"`
+		Location     *todoLocation          `json:"location,omitempty"`
+		Archived     bool                   `json:"archived"`
+		ArchivedAt   *time.Time             `json:"archived_at,omitempty"`
+		Links        map[string]link        `json:"_links,omitempty"`
+		// Due is accepted on create and update as a natural language
+		// alternative to due_at and is never returned.
+		Due string `json:"due,omitempty"`
+	}
+	checklistItem struct {
+		Title       string `bson:"title" json:"title"`
+		IsCompleted bool   `bson:"is_completed" json:"is_completed"`
 	}
 )
 
+func env(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
 func init() {
 	rnd = renderer.New()
+	database.Secret = secret
 	var client *mongo.Client = database.DBInstance()
-	collection = database.OpenCollection(client, collectionName)
+	openCollections(client.Database(dbName))
+}
+
+// openCollections points every collection at db. It runs before the server
+// starts, once for the configured database and again for --demo.
+func openCollections(db *mongo.Database) {
+	collection = db.Collection(collectionName)
+	listCollection = collection
+	if mode := env("TODO_LIST_READ_PREFERENCE", ""); mode != "" {
+		rp, err := database.ReadPreference(mode)
+		checkErr(err)
+		listCollection, err = collection.Clone(options.Collection().SetReadPreference(rp))
+		checkErr(err)
+	}
+	filtersCollection = db.Collection(filtersCollectionName)
+	notificationsCollection = db.Collection(notificationsCollectionName)
+	customFieldsCollection = db.Collection(customFieldsCollectionName)
+	listsCollection = db.Collection(listsCollectionName)
+	timeEntriesCollection = db.Collection(timeEntriesCollectionName)
+	pomodorosCollection = db.Collection(pomodorosCollectionName)
+	settingsCollection = db.Collection(settingsCollectionName)
+	automationsCollection = db.Collection(automationsCollectionName)
+	devicesCollection = db.Collection(devicesCollectionName)
+	rulesCollection = db.Collection(rulesCollectionName)
+	ruleRunsCollection = db.Collection(ruleRunsCollectionName)
+	tombstonesCollection = db.Collection(tombstonesCollectionName)
+	operationsCollection = db.Collection(operationsCollectionName)
+	// History values are free-form, so read nested documents as maps that
+	// render as JSON objects.
+	historyCollection = db.Collection(historyCollectionName,
+		options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true}))
+	leasesCollection = db.Collection(leasesCollectionName)
+	// Job results are free-form as well.
+	jobsCollection = db.Collection(jobsCollectionName,
+		options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true}))
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	err := rnd.Template(w, http.StatusOK, []string{"./static/home.html"}, nil)
-	checkErr(err)
+	if spaEnabled() {
+		serveSPAFile(w, r, "index.html")
+		return
+	}
+	tmpl, err := htmlTemplate("home.html")
+	var b bytes.Buffer
+	if err == nil {
+		err = tmpl.Execute(&b, nil)
+	}
+	if err != nil {
+		templateError(w, "home.html", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
 }
 
 func main() {
-	stopChannel := make(chan os.Signal)
+	flag.Parse()
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *devFlag {
+		log.Println("Starting in dev mode, templates are read on every request")
+	} else if err := parseTemplates(); err != nil {
+		log.Fatal(err)
+	}
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if *demoFlag {
+		if err := startDemo(jobsCtx); err != nil {
+			log.Fatal(err)
+		}
+	}
+	idCtx, cancelID := context.WithTimeout(context.Background(), 10*time.Second)
+	err := checkIDFormat(idCtx)
+	cancelID()
+	if errors.Is(err, errIDFormat) {
+		log.Fatal(err)
+	} else if err != nil {
+		log.Printf("ids: %s\n", err)
+	}
+	if *maintenanceFlag {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := setMaintenance(ctx, true, 0); err != nil {
+			log.Fatal(err)
+		}
+		cancel()
+		log.Println("Starting in maintenance mode, writes are refused")
+	}
+	stopChannel := make(chan os.Signal, 1)
 	signal.Notify(stopChannel, os.Interrupt)
 	r := chi.NewRouter()
+	routes := &routeTable{routes: r}
+	r.Use(middleware.RequestID, requestIDHeader)
+	// Overridden methods are applied first, so the log shows the real one.
+	r.Use(methodOverride)
 	r.Use(middleware.Logger)
+	r.Use(methodHandling(routes), limitRequestBody)
+	r.MethodNotAllowed(routes.methodNotAllowed)
 	r.Get("/", homeHandler)
-	r.Mount("/todo", todoHandlers())
+	r.Get("/feeds/{token}.ics", icsFeed)
+	r.Get("/unsubscribe/{token}", unsubscribe)
+	r.Post("/unsubscribe/{token}", unsubscribe)
+	r.Mount("/ui", uiHandlers())
+	mountAPI(r)
+
+	go runAsLeader(jobsCtx, "backups", backupScheduler)
+	go runAsLeader(jobsCtx, "overdue", overdueScheduler)
+	go runAsLeader(jobsCtx, "automations", automationScheduler)
+	go runAsLeader(jobsCtx, "digest", digestScheduler)
+	go runAsLeader(jobsCtx, "reminders", reminderScheduler)
+	go runAsLeader(jobsCtx, "telemetry", telemetryScheduler)
+	go jobWorker(jobsCtx)
+	go ensureIndexes()
+	go backfillTodos()
 
 	srv := &http.Server{
 		Addr:         port,
@@ -89,6 +265,7 @@ func main() {
 	}()
 	<-stopChannel
 	log.Println("Shutting down server....")
+	stopJobs()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	srv.Shutdown(ctx)
 	defer cancel()
@@ -98,48 +275,304 @@ func main() {
 
 func todoHandlers() http.Handler {
 	rg := chi.NewRouter()
+	rg.Use(contentNegotiation)
 	rg.Group(func(r chi.Router) {
 		r.Get("/", fetchTodos)
 		r.Post("/", createTodo)
-		r.Put("/{id}", updateTodo)
-		r.Delete("/{id}", deleteTodo)
+		r.Get("/count", countTodos)
+		r.Post("/lookup", lookupTodos)
+		r.Get("/export", exportTodos)
+		r.Get("/stats", todoStats)
+		r.Get("/aggregate", todoAggregate)
+		r.Get("/calendar", todoCalendar)
+		r.Get("/search", searchTodos)
+		r.Get("/near", nearTodos)
+		r.Get("/suggest", autocomplete)
+		r.Get("/suggest-tags", suggestTags)
+		r.With(longRunning).Post("/import", importTodos)
+		r.With(longRunning).Post("/import/trello", importTrello)
+		r.With(longRunning).Post("/import/microsoft", importMicrosoftTodo)
+		r.Get("/{id}", fetchTodo)
+		r.With(ifUnmodifiedSince).Put("/{id}", updateTodo)
+		r.Post("/{id}/toggle", toggleCompleted)
+		r.Get("/{id}/history", todoHistory)
+		r.Post("/{id}/archive", setArchived(true))
+		r.Post("/{id}/unarchive", setArchived(false))
+		r.Post("/{id}/move", moveTodo)
+		r.Post("/{id}/pin", toggleFlag("pinned"))
+		r.Post("/{id}/star", toggleFlag("starred"))
+		r.Post("/{id}/clone", cloneTodo)
+		r.Post("/{id}/suggest-subtasks", suggestSubtasks)
+		r.Post("/{id}/timer/start", startTimer)
+		r.Post("/{id}/timer/stop", stopTimer)
+		r.Mount("/{id}/time-entries", timeEntryHandlers())
+		r.Post("/{id}/pomodoros", startPomodoro)
+		r.Post("/{id}/snooze", snoozeTodo)
+		r.Mount("/{id}/blockers", blockerHandlers())
+		r.With(ifUnmodifiedSince).Delete("/{id}", deleteTodo)
 	})
 	return rg
 }
 
 func fetchTodos(w http.ResponseWriter, r *http.Request) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	res, err := collection.Find(ctx, bson.M{})
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		writeLookup(w, r, strings.Split(ids, ","))
+		return
+	}
+	stream := listStreamMode(r)
+	timeout := 10 * time.Second
+	if stream != "" {
+		timeout = 5 * time.Minute
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	// _id breaks ties, so pages neither repeat nor skip todos created in
+	// the same instant.
+	opts := options.Find().SetSort(bson.D{
+		{Key: "pinned", Value: -1},
+		{Key: "sort_key", Value: 1},
+		{Key: "createdat", Value: 1},
+		{Key: "_id", Value: 1},
+	})
+	filter, err := todoFilter(r)
+	if err != nil {
+		defer cancel()
+		filterError(w, r, err)
+		return
+	}
+	p, paginated, err := requestPage(r)
+	if err != nil {
+		defer cancel()
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_page", nil))
+		return
+	}
+	if paginated {
+		opts = p.apply(opts)
+	}
+	fields, projection, err := requestFields(r)
+	if err != nil {
+		defer cancel()
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_fields", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	if projection != nil {
+		opts = opts.SetProjection(projection)
+	}
+	present := todoPresenter(fields)
+	total, err := listCollection.CountDocuments(ctx, filter)
+	var res *mongo.Cursor
+	if err == nil {
+		res, err = listCollection.Find(ctx, filter, opts)
+	}
 	todos := []todoModel{}
 	if err != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Failed to fetch todo",
-			"error":   err,
-		})
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", renderer.M{
+			"error": err,
+		}))
 		defer cancel()
 		return
 	}
+	if stream != "" {
+		defer cancel()
+		defer res.Close(ctx)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		if paginated {
+			setPageLinks(w, r, p, total)
+		}
+		streamTodos(ctx, w, res, stream, present, collectionLinks(r, p, paginated, total))
+		return
+	}
 	if err := res.All(ctx, &todos); err != nil {
 		defer cancel()
 		log.Fatal(err)
 		return
 	}
-	todoList := []todo{}
+	todoList := []interface{}{}
 	for _, t := range todos {
-		todoList = append(todoList, todo{
-			ID:          t.ID.Hex(),
-			Title:       t.Title,
-			IsCompleted: t.IsCompleted,
-			CreatedAt:   t.CreatedAt,
-			UpdatedAt:   t.UpdatedAt,
-		})
+		todoList = append(todoList, present(t))
+	}
+	defer cancel()
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if paginated {
+		setPageLinks(w, r, p, total)
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":   todoList,
+		"_links": collectionLinks(r, p, paginated, total),
+	})
+}
+
+// fetchTodo returns one todo, with the relations named in ?include= when
+// given.
+func fetchTodo(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	includes, limit, err := requestIncludes(r)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_include", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	var t todoModel
+	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusNotFound, withMessage(r, "todo_not_found", nil))
+		return
+	}
+	var included map[string]interface{}
+	if err == nil && includes != nil {
+		included, err = expandTodo(ctx, t, includes, limit)
 	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	res := renderer.M{
+		"data": newTodo(t),
+	}
+	w.Header().Set("Last-Modified", t.UpdatedAt.UTC().Format(http.TimeFormat))
+	if included != nil {
+		res["included"] = included
+	}
+	rnd.JSON(w, http.StatusOK, res)
+}
+
+// countTodos returns how many todos match the list filters without fetching
+// them.
+func countTodos(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	total, err := listCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "fetch_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 	rnd.JSON(w, http.StatusOK, renderer.M{
-		"data": todoList,
+		"count": total,
 	})
 }
 
+// errInvalidFilter is returned by todoFilter for a parameter it cannot read.
+var errInvalidFilter = errors.New("invalid filter")
+
+// todoFilter builds the Mongo filter shared by the list and export endpoints
+// from the ?completed=, ?status=, ?list=, ?tag=, ?color=, ?pinned=,
+// ?starred=, ?blocked=, ?max_effort=, ?due=today, ?cf.<key>= and ?archived=
+// query parameters. "Today" is taken in the request's time zone. The error
+// is errInvalidFilter or one requestLocation returned, for filterError.
+func todoFilter(r *http.Request) (bson.M, error) {
+	filter := bson.M{}
+	q := r.URL.Query()
+	if completed := q.Get("completed"); completed != "" {
+		filter["iscompleted"] = completed == "true"
+	}
+	if list := q.Get("list"); list != "" {
+		filter["list"] = list
+	}
+	if tag := q.Get("tag"); tag != "" {
+		filter["tags"] = tag
+	}
+	if status := q.Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if color, ok := normalizeColor(q.Get("color")); ok && color != "" {
+		filter["color"] = color
+	}
+	flagFilter(filter, r, "pinned")
+	flagFilter(filter, r, "starred")
+	flagFilter(filter, r, "blocked")
+	effortFilter(filter, r)
+	if q.Get("due") == "today" {
+		loc, err := requestLocation(r)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		filter["due_at"] = bson.M{"$gte": today, "$lt": today.AddDate(0, 0, 1)}
+	}
+	if err := customFieldFilter(filter, r); err != nil {
+		return nil, err
+	}
+	archivedFilter(filter, r)
+	return filter, nil
+}
+
+// filterError answers a request todoFilter refused.
+func filterError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errInvalidFilter) {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_filter", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	locationError(w, err)
+}
+
+// andFilter adds clause to filter under $and, so operators such as $or that
+// other parts of the filter may use are not replaced.
+func andFilter(filter bson.M, clause bson.M) {
+	clauses, _ := filter["$and"].(bson.A)
+	filter["$and"] = append(clauses, clause)
+}
+
+func newTodo(t todoModel) todo {
+	blockedBy := []string{}
+	for _, id := range t.BlockedBy {
+		blockedBy = append(blockedBy, id.Hex())
+	}
+	return todo{
+		ID:           t.ID.Hex(),
+		Title:        t.Title,
+		IsCompleted:  t.IsCompleted,
+		CreatedAt:    t.CreatedAt,
+		UpdatedAt:    t.UpdatedAt,
+		List:         t.List,
+		Tags:         t.Tags,
+		Checklist:    t.Checklist,
+		ReminderAt:   t.ReminderAt,
+		DueAt:        t.DueAt,
+		CompletedAt:  t.CompletedAt,
+		CustomFields: t.CustomFields,
+		Status:       statusOf(t),
+		SortKey:      t.SortKey,
+		Pinned:       t.Pinned,
+		Starred:      t.Starred,
+		Color:        t.Color,
+		Tracked:      t.Tracked,
+		Pomodoros:    t.Pomodoros,
+		Estimated:    t.Estimated,
+		Remaining:    t.Remaining,
+		BlockedBy:    blockedBy,
+		Blocked:      t.Blocked,
+		Overdue:      t.Overdue,
+		Snoozes:      t.Snoozes,
+		Location:     t.Location.forClient(),
+		Archived:     t.Archived,
+		ArchivedAt:   t.ArchivedAt,
+		Links:        todoLinks(t.ID.Hex()),
+	}
+}
+
 func createTodo(w http.ResponseWriter, r *http.Request) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 	var t todo
@@ -150,129 +583,397 @@ func createTodo(w http.ResponseWriter, r *http.Request) {
 	}
 	validationErr := validate.Struct(&t)
 	if validationErr != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Error parsing your request",
-			"error":   validationErr,
-		})
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": validationErr,
+		}))
 		defer cancel()
 		return
 	}
 	if t.Title == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Title is required",
-		})
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "title_required", nil))
+		defer cancel()
+		return
+	}
+	if err := checkTodoContent(t.Title, t.Tags, t.Checklist); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_todo", renderer.M{
+			"error": err.Error(),
+		}))
+		defer cancel()
+		return
+	}
+	// Offline clients pick the ID themselves so it stays stable after they
+	// sync. The response carries it in lower case, the form it is stored
+	// and returned in from then on.
+	id := newTodoID()
+	if t.ID != "" {
+		clientID, err := parseClientTodoID(t.ID)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_todo_id", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		if err := tombstonesCollection.FindOne(ctx, bson.M{"_id": clientID}).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+			status, code := http.StatusConflict, "todo_deleted"
+			if err != nil {
+				status, code = http.StatusInternalServerError, "create_failed"
+			}
+			rnd.JSON(w, status, withMessage(r, code, renderer.M{
+				"todo_id": t.ID,
+			}))
+			defer cancel()
+			return
+		}
+		id = clientID
+	}
+	if t.Status == "" {
+		t.Status = workflow.Initial
+	}
+	status := workflow.status(t.Status)
+	if status == nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "unknown_status", renderer.M{
+			"status": t.Status,
+		}))
+		defer cancel()
+		return
+	}
+	customFields, err := checkCustomFields(ctx, t.CustomFields, true)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_custom_fields", renderer.M{
+			"error": err.Error(),
+		}))
+		defer cancel()
+		return
+	}
+	color, ok := normalizeColor(t.Color)
+	if !ok {
+		invalidColor(w, t.Color)
+		defer cancel()
+		return
+	}
+	if err := checkEffort(t.Estimated, t.Remaining); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_effort", renderer.M{
+			"error": err.Error(),
+		}))
+		defer cancel()
+		return
+	}
+	if t.Location != nil {
+		if err := t.Location.check(); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_location", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+	}
+	if t.Due != "" {
+		dueAt, err := resolveDue(r, t.Due)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_due_date", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		t.DueAt = dueAt
+	}
+	if r.URL.Query().Get("force") != "true" {
+		existing, err := findDuplicate(ctx, storedTitleKey(t.Title), time.Now())
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "create_failed", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		if existing != nil {
+			rnd.JSON(w, http.StatusConflict, withMessage(r, "duplicate_todo", renderer.M{
+				"todo_id": existing.ID.Hex(),
+			}))
+			defer cancel()
+			return
+		}
+	}
+	sortKeys, err := nextSortKeys(ctx, 1)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "create_failed", renderer.M{
+			"error": err.Error(),
+		}))
 		defer cancel()
 		return
 	}
 	todoModel := todoModel{
-		ID:          primitive.NewObjectID(),
-		Title:       t.Title,
-		IsCompleted: false,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		ID:           id,
+		Title:        t.Title,
+		IsCompleted:  status.Done,
+		List:         strings.TrimSpace(t.List),
+		Tags:         t.Tags,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		DueAt:        t.DueAt,
+		CustomFields: customFields,
+		Status:       status.Key,
+		SortKey:      sortKeys[0],
+		Color:        color,
+		TitleKey:     storedTitleKey(t.Title),
+		TitleGrams:   titleGrams(t.Title),
+		Estimated:    t.Estimated,
+		Remaining:    t.Remaining,
+		Location:     t.Location,
+	}
+	if status.Done {
+		todoModel.CompletedAt = &todoModel.CreatedAt
 	}
 	result, insertErr := collection.InsertOne(ctx, todoModel)
+	if mongo.IsDuplicateKeyError(insertErr) {
+		defer cancel()
+		rnd.JSON(w, http.StatusConflict, withMessage(r, "todo_exists", renderer.M{
+			"todo_id": id.Hex(),
+		}))
+		return
+	}
 	if insertErr != nil {
 		defer cancel()
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Todo Creation failed",
-			"error":   insertErr,
-		})
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "create_failed", renderer.M{
+			"error": insertErr,
+		}))
 		return
 	}
 	defer cancel()
-	rnd.JSON(w, http.StatusCreated, renderer.M{
-		"message": "Todo creation successful",
+	go todosCreated(todoModel)
+	rnd.JSON(w, http.StatusCreated, withMessage(r, "created", renderer.M{
 		"result":  result,
 		"todo_id": todoModel.ID.Hex(),
-	})
+	}))
 }
 
 func deleteTodo(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimSpace(chi.URLParam(r, "id"))
 	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-	objectId, err := primitive.ObjectIDFromHex(id)
+	objectId, err := parseTodoID(id)
 	if err != nil {
 		log.Panic(id)
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Error Parsing your request",
-			"error":   err,
-		})
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err,
+		}))
 		defer cancel()
 		return
 	}
 	filter := bson.M{"_id": objectId}
+	conditional := unmodifiedSince(r, filter)
 	res, deleteErr := collection.DeleteOne(ctx, filter)
+	if deleteErr == nil && res.DeletedCount == 0 && conditional && conditionFailed(ctx, w, r, objectId) {
+		defer cancel()
+		return
+	}
+	if deleteErr == nil && res.DeletedCount > 0 {
+		deleteErr = deleteTodoData(ctx, objectId)
+	}
 	if deleteErr != nil {
-		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-			"message": "Error deleting the todo",
-			"error":   deleteErr,
-		})
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "delete_failed", renderer.M{
+			"error": deleteErr,
+		}))
 		defer cancel()
 		return
 	}
 	defer cancel()
-	rnd.JSON(w, http.StatusOK, renderer.M{
-		"message": "Todo deletion successful",
+	go blockerChanged(objectId, true)
+	rnd.JSON(w, http.StatusOK, withMessage(r, "deleted", renderer.M{
 		"todo_id": id,
 		"result":  res,
-	})
+	}))
 }
 
 func updateTodo(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimSpace(chi.URLParam(r, "id"))
 	fmt.Print(id)
 	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-	objectID, err := primitive.ObjectIDFromHex(id)
+	objectID, err := parseTodoID(id)
 	if err != nil {
 		defer cancel()
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Error Parsing your request",
-			"error":   err,
-		})
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err,
+		}))
 		return
 	}
 
-	var todo todo
+	var todo struct {
+		todo
+		IsCompleted *bool `json:"is_completed"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&todo); err != nil {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Bad request",
-		})
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", nil))
+		defer cancel()
+		return
+	}
+	if err := checkTodoContent(todo.Title, todo.Tags, todo.Checklist); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_todo", renderer.M{
+			"error": err.Error(),
+		}))
+		defer cancel()
+		return
 	}
 	var updateObj primitive.D
+	statusChanged := false
+	newStatus := ""
 
-	if todo.Title != "" || &(todo.Title) != nil {
-		updateObj = append(updateObj, bson.E{Key: "title", Value: todo.Title})
-		todo.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: todo.UpdatedAt})
-		filter := bson.M{"_id": objectID}
-		upsert := true
-		opts := options.UpdateOptions{
-			Upsert: &upsert,
+	// Fields left out of the body are kept, so a client may send only what
+	// changed.
+	if todo.Title != "" {
+		title, err := sealTitle(objectID, todo.Title)
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "update_failed", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		updateObj = append(updateObj, bson.E{Key: "title", Value: title})
+		updateObj = append(updateObj, bson.E{Key: "title_key", Value: storedTitleKey(todo.Title)})
+		updateObj = append(updateObj, bson.E{Key: "title_grams", Value: titleGrams(todo.Title)})
+	}
+	if todo.Due != "" {
+		dueAt, err := resolveDue(r, todo.Due)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_due_date", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		todo.DueAt = dueAt
+	}
+	if todo.DueAt != nil {
+		updateObj = append(updateObj, bson.E{Key: "due_at", Value: todo.DueAt})
+	}
+	if err := checkEffort(todo.Estimated, todo.Remaining); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_effort", renderer.M{
+			"error": err.Error(),
+		}))
+		defer cancel()
+		return
+	}
+	if todo.Location != nil {
+		if err := todo.Location.check(); err != nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_location", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		updateObj = append(updateObj, bson.E{Key: "location", Value: todo.Location})
+	}
+	if todo.Estimated != nil {
+		updateObj = append(updateObj, bson.E{Key: "estimated_minutes", Value: *todo.Estimated})
+	}
+	if todo.Remaining != nil {
+		updateObj = append(updateObj, bson.E{Key: "remaining_minutes", Value: *todo.Remaining})
+	}
+	if todo.List != "" {
+		updateObj = append(updateObj, bson.E{Key: "list", Value: strings.TrimSpace(todo.List)})
+	}
+	if todo.Tags != nil {
+		updateObj = append(updateObj, bson.E{Key: "tags", Value: todo.Tags})
+	}
+	if todo.Checklist != nil {
+		updateObj = append(updateObj, bson.E{Key: "checklist", Value: todo.Checklist})
+	}
+	if todo.Color != "" {
+		color, ok := normalizeColor(todo.Color)
+		if !ok {
+			invalidColor(w, todo.Color)
+			defer cancel()
+			return
 		}
-		result, err := collection.UpdateOne(ctx, filter, bson.D{
-			{Key: "$set", Value: updateObj},
-		}, &opts)
+		updateObj = append(updateObj, bson.E{Key: "color", Value: color})
+	}
+	if len(todo.CustomFields) > 0 {
+		customFields, err := checkCustomFields(ctx, todo.CustomFields, false)
 		if err != nil {
-			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
-				"message": "Update Failed",
-				"error":   err,
-			})
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "invalid_custom_fields", renderer.M{
+				"error": err.Error(),
+			}))
+			defer cancel()
+			return
+		}
+		for key, value := range customFields {
+			updateObj = append(updateObj, bson.E{Key: "custom_fields." + key, Value: value})
+		}
+	}
+	filter := bson.M{"_id": objectID}
+	var before todoModel
+	existed := collection.FindOne(ctx, filter).Decode(&before) == nil
+	target := ""
+	if todo.Status != "" {
+		if workflow.status(todo.Status) == nil {
+			rnd.JSON(w, http.StatusBadRequest, withMessage(r, "unknown_status", renderer.M{
+				"status": todo.Status,
+			}))
+			defer cancel()
+			return
+		}
+		target = todo.Status
+	} else if todo.IsCompleted != nil {
+		// Clients that predate the workflow only toggle is_completed, so
+		// they move straight between the initial and done statuses. They
+		// send it on every edit, so the status only changes when the todo
+		// is not already in the state they ask for.
+		isCompletedField.mark(w, r, "is_completed")
+		if status, changed := completionTarget(statusOf(before), *todo.IsCompleted); changed || !existed {
+			target = status
+		}
+	}
+	if target != "" {
+		if existed && !workflow.canMove(statusOf(before), target) {
+			rnd.JSON(w, http.StatusConflict, withMessage(r, "transition_not_allowed", renderer.M{
+				"from": statusOf(before),
+				"to":   target,
+			}))
 			defer cancel()
 			return
 		}
+		updateObj = append(updateObj, statusUpdate(target, time.Now())...)
+		statusChanged = true
+		newStatus = target
+	}
+	todo.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	updateObj = append(updateObj, bson.E{Key: "updatedat", Value: todo.UpdatedAt})
+	cascade, cascaded := cascadeChecklist(cascadeRequested(r), newStatus, before.Checklist, todo.Checklist)
+	updateObj = append(updateObj, cascade...)
+	upsert := true
+	opts := options.UpdateOptions{
+		Upsert: &upsert,
+	}
+	conditional := unmodifiedSince(r, filter)
+	result, err := collection.UpdateOne(ctx, filter, bson.D{
+		{Key: "$set", Value: updateObj},
+	}, &opts)
+	// Under a condition, the upsert of a todo that changed collides with it.
+	if conditional && mongo.IsDuplicateKeyError(err) && conditionFailed(ctx, w, r, objectID) {
 		defer cancel()
-		rnd.JSON(w, http.StatusOK, renderer.M{
-			"message": "Update Successful",
-			"todo_id": id,
-			"result":  result,
-		})
-	} else {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Title is required",
-		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "update_failed", renderer.M{
+			"error": err,
+		}))
 		defer cancel()
+		return
+	}
+	defer cancel()
+	if statusChanged {
+		go blockerChanged(objectID, false)
+	}
+	if existed {
+		go todoUpdated(before)
 	}
+	rnd.JSON(w, http.StatusOK, withMessage(r, "updated", renderer.M{
+		"todo_id":  id,
+		"result":   result,
+		"cascaded": cascaded,
+	}))
 }
 
 func checkErr(err error) {
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testDatabase points the collections at a scratch database, dropped when
+// the test ends. Tests that need it are skipped without a reachable MongoDB.
+func testDatabase(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client := collection.Database().Client()
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skip("MongoDB is not reachable:", err)
+	}
+	previous := collection.Database()
+	db := client.Database(dbName + "_test")
+	openCollections(db)
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		openCollections(previous)
+	})
+}
+
+func TestUpdateTodoPartialKeepsTitle(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	id := newTodoID()
+	title, err := sealTitle(id, "Water the plants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = collection.InsertOne(ctx, bson.M{
+		"_id":         id,
+		"title":       title,
+		"iscompleted": false,
+		"createdat":   time.Now(),
+		"updatedat":   time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/"+id.Hex(), strings.NewReader(`{"is_completed":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	todoHandlers().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT answered %d: %s", w.Code, w.Body)
+	}
+
+	var stored todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := openTitle(id, stored.Title); got != "Water the plants" {
+		t.Errorf("title = %q after a PUT without one", got)
+	}
+	if !stored.IsCompleted {
+		t.Error("is_completed was not updated")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maintenance puts the API in read-only mode for migrations and failovers:
+// writes are refused with 503 and Retry-After while reads are served as
+// usual. It is turned on with --maintenance or TODO_MAINTENANCE=true and
+// toggled at runtime with PUT /admin/maintenance, which stays writable.
+// The state is kept in the settings collection, so it applies to every
+// replica within settingsRefresh.
+const (
+	maintenanceID         = "maintenance"
+	maintenanceRetryAfter = 2 * time.Minute
+)
+
+type maintenanceSettings struct {
+	Enabled    bool      `bson:"enabled"`
+	Since      time.Time `bson:"since,omitempty"`
+	RetryAfter int       `bson:"retry_after,omitempty"`
+}
+
+var maintenance = struct {
+	sync.Mutex
+	maintenanceSettings
+	readAt time.Time
+}{}
+
+var maintenanceFlag = flag.Bool("maintenance", env("TODO_MAINTENANCE", "") == "true", "start in read-only maintenance mode")
+
+// setMaintenance stores the state. since is kept while the mode stays on.
+func setMaintenance(ctx context.Context, enabled bool, retryAfter time.Duration) error {
+	set := bson.M{"enabled": enabled}
+	if enabled {
+		set["since"] = bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$enabled", true}}, "$since", "$$NOW"}}
+	}
+	if retryAfter > 0 {
+		set["retry_after"] = int(retryAfter.Seconds())
+	}
+	_, err := settingsCollection.UpdateOne(ctx, bson.M{"_id": maintenanceID},
+		mongo.Pipeline{{{Key: "$set", Value: set}}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	maintenance.Lock()
+	maintenance.readAt = time.Time{}
+	maintenance.Unlock()
+	return nil
+}
+
+// maintenanceState returns the stored state, read again once it is older
+// than settingsRefresh. When the read fails the last known state is kept.
+func maintenanceState() (enabled bool, since time.Time, retryAfter time.Duration) {
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	if time.Since(maintenance.readAt) >= settingsRefresh {
+		var ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		var m maintenanceSettings
+		err := settingsCollection.FindOne(ctx, bson.M{"_id": maintenanceID}).Decode(&m)
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) {
+			maintenance.maintenanceSettings = m
+		} else {
+			log.Printf("maintenance: %s\n", err)
+		}
+		maintenance.readAt = time.Now()
+	}
+	m := maintenance.maintenanceSettings
+	retryAfter = time.Duration(m.RetryAfter) * time.Second
+	if retryAfter == 0 {
+		retryAfter = maintenanceRetryAfter
+	}
+	return m.Enabled, m.Since, retryAfter
+}
+
+// readOnlyDuringMaintenance refuses every request that is not a read while
+// maintenance mode is on.
+func readOnlyDuringMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled, _, retryAfter := maintenanceState()
+		switch {
+		case !enabled, r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			rnd.JSON(w, http.StatusServiceUnavailable, withMessage(r, "maintenance", nil))
+		}
+	})
+}
+
+func getMaintenance(w http.ResponseWriter, r *http.Request) {
+	enabled, since, retryAfter := maintenanceState()
+	body := renderer.M{
+		"enabled":     enabled,
+		"retry_after": int(retryAfter.Seconds()),
+	}
+	if enabled {
+		body["since"] = since
+	}
+	rnd.JSON(w, http.StatusOK, body)
+}
+
+// updateMaintenance takes {"enabled": true, "retry_after": 300}, with
+// retry_after in seconds and kept as it was when left out.
+func updateMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled    *bool `json:"enabled"`
+		RetryAfter int   `json:"retry_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil || body.RetryAfter < 0 {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Give enabled and optionally retry_after in seconds",
+		})
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := setMaintenance(ctx, *body.Enabled, time.Duration(body.RetryAfter)*time.Second); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to update maintenance mode",
+			"error":   err.Error(),
+		})
+		return
+	}
+	getMaintenance(w, r)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+const defaultLocale = "en"
+
+// messages is the catalog of user-facing API messages, keyed by locale and
+// then by the code sent alongside each message. Clients should branch on the
+// code; the message is for display.
+var messages = map[string]map[string]string{
+	"en": {
+		"bad_request":            "Error parsing your request",
+		"title_required":         "Title is required",
+		"todo_not_found":         "Todo not found",
+		"fetch_failed":           "Failed to fetch todo",
+		"create_failed":          "Todo creation failed",
+		"created":                "Todo creation successful",
+		"update_failed":          "Update failed",
+		"updated":                "Update successful",
+		"delete_failed":          "Error deleting the todo",
+		"deleted":                "Todo deletion successful",
+		"clone_failed":           "Todo clone failed",
+		"cloned":                 "Todo clone successful",
+		"duplicate_todo":         "A matching open todo was just created, pass ?force=true to add it anyway",
+		"invalid_todo_id":        "The ID of a new todo must be a UUID",
+		"todo_exists":            "A todo with this ID already exists",
+		"todo_deleted":           "The todo with this ID was deleted",
+		"unknown_status":         "Unknown status",
+		"transition_not_allowed": "Status transition not allowed",
+		"invalid_custom_fields":  "Invalid custom fields",
+		"invalid_todo":           "Invalid todo",
+		"invalid_effort":         "Invalid effort",
+		"invalid_location":       "Invalid location",
+		"invalid_due_date":       "Invalid due date",
+		"invalid_filter":         "Invalid filter",
+		"invalid_page":           "page and per_page must be positive integers",
+		"invalid_fields":         "Unknown field requested",
+		"invalid_include":        "Invalid include",
+		"precondition_failed":    "The todo was modified since the given time",
+		"maintenance":            "The service is read-only for maintenance, try again later",
+	},
+	"de": {
+		"bad_request":            "Die Anfrage konnte nicht gelesen werden",
+		"title_required":         "Ein Titel ist erforderlich",
+		"todo_not_found":         "Aufgabe nicht gefunden",
+		"fetch_failed":           "Aufgaben konnten nicht geladen werden",
+		"create_failed":          "Aufgabe konnte nicht erstellt werden",
+		"created":                "Aufgabe erstellt",
+		"update_failed":          "Aktualisierung fehlgeschlagen",
+		"updated":                "Aktualisierung erfolgreich",
+		"delete_failed":          "Aufgabe konnte nicht gelöscht werden",
+		"deleted":                "Aufgabe gelöscht",
+		"clone_failed":           "Aufgabe konnte nicht kopiert werden",
+		"cloned":                 "Aufgabe kopiert",
+		"duplicate_todo":         "Eine gleiche offene Aufgabe wurde gerade erstellt, mit ?force=true trotzdem anlegen",
+		"invalid_todo_id":        "Die ID einer neuen Aufgabe muss eine UUID sein",
+		"todo_exists":            "Eine Aufgabe mit dieser ID existiert bereits",
+		"todo_deleted":           "Die Aufgabe mit dieser ID wurde gelöscht",
+		"unknown_status":         "Unbekannter Status",
+		"transition_not_allowed": "Dieser Statuswechsel ist nicht erlaubt",
+		"invalid_custom_fields":  "Ungültige benutzerdefinierte Felder",
+		"invalid_todo":           "Ungültige Aufgabe",
+		"invalid_effort":         "Ungültiger Aufwand",
+		"invalid_location":       "Ungültiger Ort",
+		"invalid_due_date":       "Ungültiges Fälligkeitsdatum",
+		"invalid_filter":         "Ungültiger Filter",
+		"invalid_page":           "page und per_page müssen positive ganze Zahlen sein",
+		"invalid_fields":         "Unbekanntes Feld angefordert",
+		"invalid_include":        "Ungültige Erweiterung",
+		"precondition_failed":    "Die Aufgabe wurde seit dem angegebenen Zeitpunkt geändert",
+		"maintenance":            "Wegen Wartungsarbeiten ist nur Lesen möglich, bitte später erneut versuchen",
+	},
+}
+
+// requestLocale picks the best supported locale from Accept-Language.
+func requestLocale(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	candidates := []candidate{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		base, _, _ := strings.Cut(strings.ToLower(lang), "-")
+		if _, ok := messages[base]; ok && q > 0 {
+			candidates = append(candidates, candidate{base, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) == 0 {
+		return defaultLocale
+	}
+	return candidates[0].lang
+}
+
+// withMessage adds the code and its message in the request's locale to
+// fields, which may be nil.
+func withMessage(r *http.Request, code string, fields renderer.M) renderer.M {
+	if fields == nil {
+		fields = renderer.M{}
+	}
+	locale := requestLocale(r)
+	message, ok := messages[locale][code]
+	if !ok {
+		message = messages[defaultLocale][code]
+	}
+	fields["code"] = code
+	fields["message"] = message
+	return fields
+}
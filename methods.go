@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var routableMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// routeTable is a flat copy of every route, used to look up which methods a
+// path supports. Matching against the real router is not reliable because
+// chi answers any method on the root of a mounted subrouter.
+type routeTable struct {
+	once   sync.Once
+	routes chi.Routes
+	flat   *chi.Mux
+}
+
+func (t *routeTable) match(method, path string) bool {
+	t.once.Do(func() {
+		t.flat = chi.NewRouter()
+		noop := func(http.ResponseWriter, *http.Request) {}
+		chi.Walk(t.routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			t.flat.MethodFunc(method, route, noop)
+			return nil
+		})
+	})
+	// The root of a mounted router is reachable with and without the slash.
+	return t.flat.Match(chi.NewRouteContext(), method, path) ||
+		(!strings.HasSuffix(path, "/") && t.flat.Match(chi.NewRouteContext(), method, path+"/"))
+}
+
+// allowed lists the methods served for path, including the HEAD and OPTIONS
+// handling added by methodHandling.
+func (t *routeTable) allowed(path string) []string {
+	allowed := []string{}
+	for _, m := range routableMethods {
+		if t.match(m, path) {
+			allowed = append(allowed, m)
+			if m == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+	}
+	if len(allowed) > 0 {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	return allowed
+}
+
+// overridableMethods are the methods a POST may carry in
+// X-HTTP-Method-Override, for clients behind proxies that only pass GET and
+// POST. A safe method is never overridden.
+var overridableMethods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// methodOverride applies X-HTTP-Method-Override before routing, so the
+// request is handled exactly as if it had been sent with that method. Other
+// methods than POST ignore the header.
+func methodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		override := strings.ToUpper(strings.TrimSpace(r.Header.Get("X-HTTP-Method-Override")))
+		if r.Method != http.MethodPost || override == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !slices.Contains(overridableMethods, override) {
+			writeProblem(w, r, http.StatusBadRequest, "X-HTTP-Method-Override must be one of "+strings.Join(overridableMethods, ", "), nil)
+			return
+		}
+		log.Printf("method override: POST %s as %s from %s\n", r.URL.Path, override, r.RemoteAddr)
+		r.Method = override
+		r.Header.Del("X-HTTP-Method-Override")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodNotAllowed is the router's handler for a path matched with the wrong
+// method, which methodHandling normally answers first.
+func (t *routeTable) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	methodNotAllowed(w, r, t.allowed(requestPath(r)))
+}
+
+func requestPath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.Path
+}
+
+// methodHandling answers OPTIONS with the methods a path supports and serves
+// HEAD from the GET handler with the Content-Length the GET response would
+// have. Unsupported methods get a 405 whose Allow header lists them all.
+func methodHandling(table *routeTable) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := requestPath(r)
+			switch {
+			case r.Method == http.MethodOptions || r.Method == http.MethodHead:
+				allowed := table.allowed(path)
+				if len(allowed) == 0 {
+					routeNotFound(w, r)
+					return
+				}
+				if r.Method == http.MethodOptions {
+					w.Header().Set("Allow", strings.Join(allowed, ", "))
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				if !table.match(http.MethodGet, path) {
+					methodNotAllowed(w, r, allowed)
+					return
+				}
+				chi.RouteContext(r.Context()).RouteMethod = http.MethodGet
+				hw := &headWriter{ResponseWriter: w}
+				next.ServeHTTP(hw, r)
+				hw.finish()
+			case !table.match(r.Method, path):
+				if allowed := table.allowed(path); len(allowed) > 0 {
+					methodNotAllowed(w, r, allowed)
+					return
+				}
+				next.ServeHTTP(w, r)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// headWriter discards the body of a GET response served for HEAD and counts
+// it, holding the status back so Content-Length can still be set.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.length += len(p)
+	return len(p), nil
+}
+
+func (w *headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *headWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
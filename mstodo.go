@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+const graphTodoListsURL = "https://graph.microsoft.com/v1.0/me/todo/lists"
+
+type (
+	msTodoExport struct {
+		Value []msTodoList `json:"value"`
+	}
+	msTodoList struct {
+		ID          string       `json:"id"`
+		DisplayName string       `json:"displayName"`
+		Tasks       []msTodoTask `json:"tasks"`
+	}
+	msTodoTask struct {
+		Title            string            `json:"title"`
+		Status           string            `json:"status"`
+		Categories       []string          `json:"categories"`
+		IsReminderOn     bool              `json:"isReminderOn"`
+		ReminderDateTime *msDateTimeZone   `json:"reminderDateTime"`
+		ChecklistItems   []msChecklistItem `json:"checklistItems"`
+	}
+	msChecklistItem struct {
+		DisplayName string `json:"displayName"`
+		IsChecked   bool   `json:"isChecked"`
+	}
+	msDateTimeZone struct {
+		DateTime string `json:"dateTime"`
+		TimeZone string `json:"timeZone"`
+	}
+)
+
+func (d *msDateTimeZone) time() (*time.Time, error) {
+	loc, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05.9999999", d.DateTime, loc)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// importMicrosoftTodo creates todos from Microsoft To Do. The request body is a
+// Graph collection of todoTaskList objects with their tasks expanded. When an
+// X-Graph-Token header is sent instead, the lists are fetched from the Graph API
+// on behalf of that token. Steps become checklist items and categories become tags.
+func importMicrosoftTodo(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	var export msTodoExport
+	if token := r.Header.Get("X-Graph-Token"); token != "" {
+		lists, err := fetchGraphTodoLists(ctx, token)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadGateway, renderer.M{
+				"message": "Failed to fetch Microsoft To Do lists",
+				"error":   err.Error(),
+			})
+			return
+		}
+		export.Value = lists
+	} else if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing Microsoft To Do export",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	docs := []interface{}{}
+	created := []todoModel{}
+	ids := []string{}
+	for _, list := range export.Value {
+		for _, task := range list.Tasks {
+			title := strings.TrimSpace(task.Title)
+			if title == "" {
+				continue
+			}
+			checklist := []checklistItem{}
+			for _, step := range task.ChecklistItems {
+				checklist = append(checklist, checklistItem{
+					Title:       step.DisplayName,
+					IsCompleted: step.IsChecked,
+				})
+			}
+			now := time.Now()
+			t := todoModel{
+				ID:          newTodoID(),
+				Title:       title,
+				IsCompleted: task.Status == "completed",
+				CreatedAt:   now,
+				UpdatedAt:   now,
+				List:        list.DisplayName,
+				Tags:        task.Categories,
+				Checklist:   checklist,
+			}
+			if task.IsReminderOn && task.ReminderDateTime != nil {
+				if reminder, err := task.ReminderDateTime.time(); err == nil {
+					t.ReminderAt = reminder
+				}
+			}
+			if t.IsCompleted {
+				t.CompletedAt = &now
+			}
+			docs = append(docs, t)
+			created = append(created, t)
+			ids = append(ids, t.ID.Hex())
+		}
+	}
+	if len(docs) == 0 {
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"message":  "Nothing to import",
+			"imported": 0,
+		})
+		return
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Microsoft To Do import failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	go todosCreated(created...)
+	go backfillTodos()
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message":  "Microsoft To Do import successful",
+		"imported": len(ids),
+		"todo_ids": ids,
+	})
+}
+
+func fetchGraphTodoLists(ctx context.Context, token string) ([]msTodoList, error) {
+	lists := []msTodoList{}
+	if err := fetchGraphPages(ctx, token, graphTodoListsURL, func(raw json.RawMessage) error {
+		var page []msTodoList
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return err
+		}
+		lists = append(lists, page...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for i := range lists {
+		tasksURL := graphTodoListsURL + "/" + url.PathEscape(lists[i].ID) + "/tasks?$expand=checklistItems"
+		if err := fetchGraphPages(ctx, token, tasksURL, func(raw json.RawMessage) error {
+			var page []msTodoTask
+			if err := json.Unmarshal(raw, &page); err != nil {
+				return err
+			}
+			lists[i].Tasks = append(lists[i].Tasks, page...)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return lists, nil
+}
+
+func fetchGraphPages(ctx context.Context, token, next string, fn func(json.RawMessage) error) error {
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Value    json.RawMessage `json:"value"`
+			NextLink string          `json:"@odata.nextLink"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("graph api returned %s", res.Status)
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(page.Value); err != nil {
+			return err
+		}
+		next = page.NextLink
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// Handlers only ever read and write JSON. Other formats are translated at the
+// edge: msgpack request bodies are turned into JSON before the handlers see
+// them, and JSON responses are re-encoded when the client ranks another
+// format above JSON in Accept.
+const msgpackContentType = "application/msgpack"
+
+// responseFormat is an alternative encoding for JSON responses.
+type responseFormat struct {
+	contentType string
+	mediaTypes  []string
+	encode      func(status int, body []byte) ([]byte, error)
+}
+
+var responseFormats = []responseFormat{
+	{msgpackContentType, []string{msgpackContentType, "application/x-msgpack"}, bodyOnly(jsonToMsgpack)},
+	{"application/xml", []string{"application/xml", "text/xml"}, bodyOnly(jsonToXML)},
+	{jsonAPIContentType, []string{jsonAPIContentType}, jsonToJSONAPI},
+}
+
+// bodyOnly adapts an encoding that does not depend on the response status.
+func bodyOnly(encode func([]byte) ([]byte, error)) func(int, []byte) ([]byte, error) {
+	return func(_ int, body []byte) ([]byte, error) {
+		return encode(body)
+	}
+}
+
+func isMsgpack(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == msgpackContentType || mediaType == "application/x-msgpack"
+}
+
+// preferredFormat returns the format Accept ranks highest, or nil when JSON
+// is at least as acceptable as every alternative.
+func preferredFormat(r *http.Request) *responseFormat {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		weights[mediaType] = max(weights[mediaType], q)
+	}
+	best := max(weights["application/json"], weights["application/*"], weights["*/*"])
+	var preferred *responseFormat
+	for i, f := range responseFormats {
+		for _, mediaType := range f.mediaTypes {
+			if q := weights[mediaType]; q > 0 && q > best {
+				best, preferred = q, &responseFormats[i]
+			}
+		}
+	}
+	return preferred
+}
+
+// contentNegotiation translates request and response bodies.
+func contentNegotiation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if f := preferredFormat(r); f != nil {
+			tw := &transcodingWriter{ResponseWriter: w, format: f}
+			defer tw.finish()
+			w = tw
+		}
+		if isMsgpack(r.Header.Get("Content-Type")) {
+			raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+			if err != nil {
+				readFailed(w, r, err)
+				return
+			}
+			body, err := msgpackToJSON(raw)
+			if err != nil {
+				rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+					"error": err.Error(),
+				}))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Type", "application/json")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// transcodingWriter holds back JSON responses so they can be re-encoded.
+// Other content types, such as exports, pass straight through.
+type transcodingWriter struct {
+	http.ResponseWriter
+	format      *responseFormat
+	status      int
+	passthrough bool
+	buf         bytes.Buffer
+}
+
+func (w *transcodingWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	w.passthrough = mediaType != "application/json"
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *transcodingWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *transcodingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.passthrough {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *transcodingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *transcodingWriter) finish() {
+	if w.status == 0 || w.passthrough {
+		return
+	}
+	body := w.buf.Bytes()
+	if encoded, err := w.format.encode(w.status, body); err == nil {
+		body = encoded
+		w.Header().Set("Content-Type", w.format.contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notificationPrefsID is the settings document saying which notifications go
+// where. Like the other preferences it applies to the whole deployment.
+const notificationPrefsID = "notifications"
+
+const (
+	channelInApp = "in_app"
+	channelEmail = "email"
+	channelPush  = "push"
+)
+
+var (
+	notificationTypes    = []string{"overdue", "overdue_escalation", "saved_search_match", "unblocked", "rule", "digest", "reminder"}
+	notificationChannels = []string{channelInApp, channelEmail, channelPush}
+)
+
+// notificationPrefs maps a notification type to the channels it is sent to.
+// Types that are not listed only go to the in-app feed; an empty list mutes
+// a type. No pushes are sent during the quiet hours, which are read in the
+// time zone of the preferences.
+type notificationPrefs struct {
+	Channels   map[string][]string `bson:"channels" json:"channels"`
+	QuietHours *quietHours         `bson:"quiet_hours,omitempty" json:"quiet_hours,omitempty"`
+}
+
+// quietHours is a daily period such as 22:00 to 7am.
+type quietHours struct {
+	Start string `bson:"start" json:"start"`
+	End   string `bson:"end" json:"end"`
+}
+
+// minuteOfDay reads a time of day as accepted in due dates.
+func minuteOfDay(s string) (int, error) {
+	hour, minute, ok := parseClock(strings.ToLower(strings.TrimSpace(s)))
+	if !ok {
+		return 0, fmt.Errorf("%q is not a time of day like 22:00 or 7am", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// contains reports whether t falls in the quiet hours. A period whose end is
+// before its start runs over midnight.
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	start, err := minuteOfDay(q.Start)
+	end, endErr := minuteOfDay(q.End)
+	if err != nil || endErr != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// length returns how long the quiet hours last each day.
+func (q *quietHours) length() time.Duration {
+	if q == nil {
+		return 0
+	}
+	start, err := minuteOfDay(q.Start)
+	end, endErr := minuteOfDay(q.End)
+	if err != nil || endErr != nil {
+		return 0
+	}
+	return time.Duration((end-start+24*60)%(24*60)) * time.Minute
+}
+
+var (
+	notificationPrefsMu     sync.Mutex
+	cachedNotificationPrefs *notificationPrefs
+	notificationPrefsReadAt time.Time
+)
+
+// loadNotificationPrefs returns the stored preferences, cached like the
+// other preferences for settingsRefresh.
+func loadNotificationPrefs(ctx context.Context) (notificationPrefs, error) {
+	notificationPrefsMu.Lock()
+	defer notificationPrefsMu.Unlock()
+	if cachedNotificationPrefs != nil && time.Since(notificationPrefsReadAt) < settingsRefresh {
+		return *cachedNotificationPrefs, nil
+	}
+	p := notificationPrefs{Channels: map[string][]string{}}
+	err := settingsCollection.FindOne(ctx, bson.M{"_id": notificationPrefsID}).Decode(&p)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return p, err
+	}
+	cachedNotificationPrefs, notificationPrefsReadAt = &p, time.Now()
+	return p, nil
+}
+
+// wants reports whether notifications of type kind go to channel.
+func (p notificationPrefs) wants(kind, channel string) bool {
+	channels, ok := p.Channels[kind]
+	if !ok {
+		return channel == channelInApp
+	}
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// notificationChannelsFor returns the channels a notification of type kind
+// goes to. The in-app feed is used when the preferences cannot be read, so
+// notifications are not lost to a database hiccup.
+func notificationChannelsFor(ctx context.Context, kind string) []string {
+	p, err := loadNotificationPrefs(ctx)
+	if err != nil {
+		log.Printf("notify: %s\n", err)
+		return []string{channelInApp}
+	}
+	loc, err := preferredLocation(ctx)
+	if err != nil {
+		loc = time.UTC
+	}
+	channels := []string{}
+	for _, c := range notificationChannels {
+		if c == channelPush && p.QuietHours.contains(time.Now().In(loc)) {
+			continue
+		}
+		if p.wants(kind, c) {
+			channels = append(channels, c)
+		}
+	}
+	return channels
+}
+
+func (p notificationPrefs) check() error {
+	if q := p.QuietHours; q != nil {
+		for _, s := range []string{q.Start, q.End} {
+			if _, err := minuteOfDay(s); err != nil {
+				return fmt.Errorf("quiet_hours: %w", err)
+			}
+		}
+	}
+	for kind, channels := range p.Channels {
+		if !containsString(notificationTypes, kind) {
+			return fmt.Errorf("unknown notification type %q, use one of %v", kind, notificationTypes)
+		}
+		for _, c := range channels {
+			if !containsString(notificationChannels, c) {
+				return fmt.Errorf("unknown channel %q, use one of %v", c, notificationChannels)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func getNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := loadNotificationPrefs(ctx)
+	var optOuts []emailOptOut
+	if err == nil {
+		optOuts, err = loadEmailOptOuts(ctx)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch notification settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	// Unsubscribed lists who opted out of which emails through the links in
+	// them; it is not changed by updates.
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":         p,
+		"types":        notificationTypes,
+		"channels":     notificationChannels,
+		"unsubscribed": optOuts,
+	})
+}
+
+func updateNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var p notificationPrefs
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if p.Channels == nil {
+		p.Channels = map[string][]string{}
+	}
+	if err := p.check(); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid notification settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	notificationPrefsMu.Lock()
+	defer notificationPrefsMu.Unlock()
+	// Replaced whole, so quiet hours left out of the body are removed.
+	_, err := settingsCollection.ReplaceOne(ctx, bson.M{"_id": notificationPrefsID}, p, options.Replace().SetUpsert(true))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Settings update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	cachedNotificationPrefs, notificationPrefsReadAt = &p, time.Now()
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Settings update successful",
+		"data":    p,
+	})
+}
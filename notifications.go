@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const notificationsCollectionName = "notifications"
+
+var notificationsCollection *mongo.Collection
+
+// notification is an in-app message about a todo, listed by GET /notifications.
+type notification struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	Type      string             `bson:"type" json:"type"`
+	Message   string             `bson:"message" json:"message"`
+	TodoID    string             `bson:"todo_id,omitempty" json:"todo_id,omitempty"`
+	FilterID  string             `bson:"filter_id,omitempty" json:"filter_id,omitempty"`
+	RuleID    string             `bson:"rule_id,omitempty" json:"rule_id,omitempty"`
+	Read      bool               `bson:"read" json:"read"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	// template and data make up the email for notifications with more to
+	// say than Message. By default the notification template shows n.
+	template string
+	data     interface{}
+	// collapseID is passed on to pushes, see pushMessage.
+	collapseID string
+}
+
+// notify sends n to the channels the notification settings choose for its
+// type.
+func notify(ctx context.Context, n notification) {
+	n.ID = primitive.NewObjectID()
+	n.CreatedAt = time.Now()
+	channels := notificationChannelsFor(ctx, n.Type)
+	if containsString(channels, channelEmail) && emailEnabled() {
+		template, data := n.template, n.data
+		if template == "" {
+			template, data = "notification", n
+		}
+		if err := queueEmail(ctx, n.Type, template, data); err != nil {
+			log.Printf("notify: %s\n", err)
+		}
+	}
+	if containsString(channels, channelPush) && pushEnabled() {
+		if err := queuePush(ctx, pushMessage{Title: "Todo", Body: n.Message, Type: n.Type, TodoID: n.TodoID, CollapseID: n.collapseID}); err != nil {
+			log.Printf("notify: %s\n", err)
+		}
+	}
+	if !containsString(channels, channelInApp) {
+		return
+	}
+	if _, err := notificationsCollection.InsertOne(ctx, n); err != nil {
+		log.Printf("notify: %s\n", err)
+	}
+}
+
+func notificationHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listNotifications)
+	r.Post("/{id}/read", markNotificationRead)
+	return r
+}
+
+func listNotifications(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{}
+	if r.URL.Query().Get("unread") == "true" {
+		filter["read"] = false
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(200)
+	cur, err := notificationsCollection.Find(ctx, filter, opts)
+	notifications := []notification{}
+	if err == nil {
+		err = cur.All(ctx, &notifications)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch notifications",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": notifications,
+	})
+}
+
+func markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	res, err := notificationsCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Update Failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if res.MatchedCount == 0 {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "Notification not found",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Notification marked as read",
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Endpoints wrapped in longRunning answer as usual when they finish within
+// operationThreshold (TODO_ASYNC_THRESHOLD). Otherwise the client gets 202
+// with a Location to /operations/{id} while the handler keeps running, and
+// its response becomes the operation's result. Unlike jobs, operations run
+// on the instance that took the request and are not retried; one whose
+// instance stopped is reported as failed after operationMaxDuration.
+const (
+	operationsCollectionName = "operations"
+	operationMaxDuration     = time.Hour
+	operationsKept           = 7 * 24 * time.Hour
+)
+
+const (
+	operationRunning   = "running"
+	operationSucceeded = "succeeded"
+	operationFailed    = "failed"
+)
+
+var operationsCollection *mongo.Collection
+
+var operationThreshold = loadOperationThreshold()
+
+func loadOperationThreshold() time.Duration {
+	d, err := time.ParseDuration(env("TODO_ASYNC_THRESHOLD", "10s"))
+	if err != nil {
+		return 10 * time.Second
+	}
+	return d
+}
+
+var operationIndexes = []mongo.IndexModel{{
+	Keys:    bson.D{{Key: "created_at", Value: 1}},
+	Options: options.Index().SetExpireAfterSeconds(int32(operationsKept.Seconds())),
+}}
+
+type operation struct {
+	ID         primitive.ObjectID `bson:"_id" json:"_id"`
+	Status     string             `bson:"status" json:"status"`
+	Method     string             `bson:"method" json:"method"`
+	Path       string             `bson:"path" json:"path"`
+	StatusCode int                `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Result     interface{}        `bson:"result,omitempty" json:"result,omitempty"`
+	Errors     []string           `bson:"errors,omitempty" json:"errors,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	FinishedAt *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// operationRecorder holds the response of a handler that may outlive its
+// request.
+type operationRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *operationRecorder) Header() http.Header { return rec.header }
+
+func (rec *operationRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *operationRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// writeTo sends the recorded response.
+func (rec *operationRecorder) writeTo(w http.ResponseWriter) {
+	for key, values := range rec.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(max(rec.status, http.StatusOK))
+	w.Write(rec.body.Bytes())
+}
+
+// longRunning is middleware for endpoints that answer with JSON and may take
+// longer than operationThreshold.
+func longRunning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is read up front, since it cannot be read once the
+		// request was answered.
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			readFailed(w, r, err)
+			return
+		}
+		r = r.WithContext(detachedContext(r.Context()))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec := &operationRecorder{header: http.Header{}}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("operation %s %s: %v\n", r.Method, r.URL.Path, p)
+					rec.header = http.Header{}
+					rec.status = http.StatusInternalServerError
+					rec.body.Reset()
+					json.NewEncoder(&rec.body).Encode(renderer.M{"message": "The operation failed"})
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		}()
+		timer := time.NewTimer(operationThreshold)
+		defer timer.Stop()
+		select {
+		case <-done:
+			rec.writeTo(w)
+			return
+		case <-timer.C:
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		op := operation{
+			ID:        primitive.NewObjectID(),
+			Status:    operationRunning,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			CreatedAt: time.Now(),
+		}
+		if _, err := operationsCollection.InsertOne(ctx, op); err != nil {
+			// Without a record to poll the client has to wait it out.
+			log.Printf("operations: %s\n", err)
+			<-done
+			rec.writeTo(w)
+			return
+		}
+		go func() {
+			<-done
+			finishOperation(op.ID, rec)
+		}()
+		acceptedOperation(w, op)
+	})
+}
+
+// detachedContext keeps the values of a request context for a handler that
+// may outlive the request. chi reuses its route context once the request
+// is answered, so it is copied.
+func detachedContext(ctx context.Context) context.Context {
+	ctx = context.WithoutCancel(ctx)
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		clone := chi.NewRouteContext()
+		clone.Routes = rctx.Routes
+		clone.RoutePath = rctx.RoutePath
+		clone.RouteMethod = rctx.RouteMethod
+		clone.URLParams.Keys = append(clone.URLParams.Keys, rctx.URLParams.Keys...)
+		clone.URLParams.Values = append(clone.URLParams.Values, rctx.URLParams.Values...)
+		clone.RoutePatterns = append(clone.RoutePatterns, rctx.RoutePatterns...)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, clone)
+	}
+	return ctx
+}
+
+// acceptedOperation answers a request that is still running.
+func acceptedOperation(w http.ResponseWriter, op operation) {
+	w.Header().Set("Location", apiPrefix("v1")+"/operations/"+op.ID.Hex())
+	rnd.JSON(w, http.StatusAccepted, renderer.M{
+		"message": "The operation is still running",
+		"data":    op,
+	})
+}
+
+// finishOperation stores the response rec holds as the result. Error
+// responses fail the operation with their message and error.
+func finishOperation(id primitive.ObjectID, rec *operationRecorder) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status := max(rec.status, http.StatusOK)
+	set := bson.M{"status": operationSucceeded, "status_code": status, "finished_at": time.Now()}
+	var result interface{}
+	if json.Unmarshal(rec.body.Bytes(), &result) == nil {
+		set["result"] = result
+	}
+	if status >= http.StatusBadRequest {
+		set["status"] = operationFailed
+		failure := []string{}
+		if fields, ok := result.(map[string]interface{}); ok {
+			for _, key := range []string{"message", "error"} {
+				if s, ok := fields[key].(string); ok && s != "" {
+					failure = append(failure, s)
+				}
+			}
+		}
+		if len(failure) == 0 {
+			failure = append(failure, http.StatusText(status))
+		}
+		set["errors"] = failure
+	}
+	if _, err := operationsCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set}); err != nil {
+		log.Printf("operations: %s\n", err)
+	}
+}
+
+func operationHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/{id}", fetchOperation)
+	return r
+}
+
+func fetchOperation(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var op operation
+	err = operationsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&op)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch operation",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if op.Status == operationRunning && time.Since(op.CreatedAt) > operationMaxDuration {
+		op.Status = operationFailed
+		op.Errors = []string{"the server stopped before the operation finished"}
+	}
+	if op.Status == operationRunning {
+		w.Header().Set("Retry-After", "2")
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": op,
+	})
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Manual ordering uses fractional indexing: every todo has a sort_key that
+// compares correctly as a plain string, and a todo is moved by giving it a key
+// between its new neighbours. Nothing else is rewritten.
+//
+// A key is a variable length integer followed by an optional base-62
+// fraction. The first byte of the integer gives its length ('a' is two bytes,
+// 'b' three, ..., and 'Z', 'Y', ... for negatives), so appending to either end
+// of the list keeps keys short and only inserts between neighbours grow them.
+const sortKeyDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var errInvalidSortKeys = errors.New("sort keys are out of order")
+
+// keyBetween returns a key strictly between a and b. An empty a means the
+// start of the list and an empty b its end.
+func keyBetween(a, b string) (string, error) {
+	if a != "" && b != "" && a >= b {
+		return "", errInvalidSortKeys
+	}
+	intA, fracA, err := splitSortKey(a)
+	if err != nil {
+		return "", err
+	}
+	intB, fracB, err := splitSortKey(b)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case a == "" && b == "":
+		return "a0", nil
+	case a == "":
+		if fracB != "" {
+			return intB, nil
+		}
+		if i, ok := stepInteger(intB, -1); ok {
+			return i, nil
+		}
+		return "", errInvalidSortKeys
+	case b == "":
+		if i, ok := stepInteger(intA, 1); ok {
+			return i, nil
+		}
+		return intA + midpoint(fracA, ""), nil
+	case intA == intB:
+		return intA + midpoint(fracA, fracB), nil
+	}
+	if i, ok := stepInteger(intA, 1); ok && i < b {
+		return i, nil
+	}
+	return intA + midpoint(fracA, ""), nil
+}
+
+func splitSortKey(key string) (string, string, error) {
+	if key == "" {
+		return "", "", nil
+	}
+	var n int
+	switch head := key[0]; {
+	case head >= 'a' && head <= 'z':
+		n = int(head-'a') + 2
+	case head >= 'A' && head <= 'Z':
+		n = int('Z'-head) + 2
+	default:
+		return "", "", errInvalidSortKeys
+	}
+	if len(key) < n || strings.HasSuffix(key[n:], "0") {
+		return "", "", errInvalidSortKeys
+	}
+	return key[:n], key[n:], nil
+}
+
+// stepInteger adds delta (1 or -1) to the integer part of a key, changing its
+// length when it runs out of digits. It reports false at either limit.
+func stepInteger(x string, delta int) (string, bool) {
+	head, digits := x[0], []byte(x[1:])
+	wrapFrom, wrapTo := sortKeyDigits[len(sortKeyDigits)-1], sortKeyDigits[0]
+	if delta < 0 {
+		wrapFrom, wrapTo = wrapTo, wrapFrom
+	}
+	i := len(digits) - 1
+	for ; i >= 0 && digits[i] == wrapFrom; i-- {
+		digits[i] = wrapTo
+	}
+	if i >= 0 {
+		digits[i] = sortKeyDigits[strings.IndexByte(sortKeyDigits, digits[i])+delta]
+		return string(head) + string(digits), true
+	}
+	switch {
+	case delta > 0 && head == 'Z':
+		return "a" + string(sortKeyDigits[0]), true
+	case delta < 0 && head == 'a':
+		return "Z" + string(sortKeyDigits[len(sortKeyDigits)-1]), true
+	case head == 'z' || head == 'A':
+		return "", false
+	}
+	head = byte(int(head) + delta)
+	if (delta > 0 && head > 'a') || (delta < 0 && head < 'Z') {
+		digits = append(digits, wrapTo)
+	} else {
+		digits = digits[1:]
+	}
+	return string(head) + string(digits), true
+}
+
+// midpoint returns a fraction between a and b, where an empty b means one.
+// Neither may end in a zero digit.
+func midpoint(a, b string) string {
+	if b != "" {
+		n := 0
+		for n < len(b) {
+			digit := byte('0')
+			if n < len(a) {
+				digit = a[n]
+			}
+			if digit != b[n] {
+				break
+			}
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:])
+		}
+	}
+	digitA := 0
+	if a != "" {
+		digitA = strings.IndexByte(sortKeyDigits, a[0])
+	}
+	digitB := len(sortKeyDigits)
+	if b != "" {
+		digitB = strings.IndexByte(sortKeyDigits, b[0])
+	}
+	if digitB-digitA > 1 {
+		return string(sortKeyDigits[(digitA+digitB+1)/2])
+	}
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(sortKeyDigits[digitA]) + midpoint(rest, "")
+}
+
+// nextSortKeys returns n increasing keys that sort after every existing todo.
+func nextSortKeys(ctx context.Context, n int) ([]string, error) {
+	var last struct {
+		SortKey string `bson:"sort_key"`
+	}
+	opts := options.FindOne().SetSort(bson.M{"sort_key": -1}).SetProjection(bson.M{"sort_key": 1})
+	err := collection.FindOne(ctx, bson.M{"sort_key": bson.M{"$exists": true}}, opts).Decode(&last)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	keys := make([]string, n)
+	prev := last.SortKey
+	for i := range keys {
+		if keys[i], err = keyBetween(prev, ""); err != nil {
+			return nil, err
+		}
+		prev = keys[i]
+	}
+	return keys, nil
+}
+
+// backfillSortKeys gives todos created before manual ordering existed a key,
+// in creation order, after all ordered todos.
+func backfillSortKeys() {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	missing := bson.M{"sort_key": bson.M{"$exists": false}}
+	cur, err := collection.Find(ctx, missing, options.Find().
+		SetSort(bson.M{"createdat": 1}).SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Printf("sort keys: %s\n", err)
+		return
+	}
+	ids := []struct {
+		ID todoID `bson:"_id"`
+	}{}
+	if err := cur.All(ctx, &ids); err != nil || len(ids) == 0 {
+		return
+	}
+	keys, err := nextSortKeys(ctx, len(ids))
+	if err != nil {
+		log.Printf("sort keys: %s\n", err)
+		return
+	}
+	models := make([]mongo.WriteModel, len(ids))
+	for i, doc := range ids {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.ID, "sort_key": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{"$set": bson.M{"sort_key": keys[i]}})
+	}
+	if _, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Printf("sort keys: %s\n", err)
+	}
+}
+
+// moveTodo places a todo between two others. The body names the todo that
+// should come right after it (before) and/or right before it (after); when
+// only one is given the other neighbour is looked up.
+func moveTodo(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	var body struct {
+		Before string `json:"before"`
+		After  string `json:"after"`
+	}
+	if err == nil {
+		err = json.NewDecoder(r.Body).Decode(&body)
+	}
+	if err != nil || (body.Before == "" && body.After == "") {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Give the ID of the todo to move before or after",
+		})
+		return
+	}
+
+	lower, upper := "", ""
+	if body.After != "" {
+		if lower, err = sortKeyOf(ctx, body.After); err != nil {
+			sortKeyError(w, err)
+			return
+		}
+	}
+	if body.Before != "" {
+		if upper, err = sortKeyOf(ctx, body.Before); err != nil {
+			sortKeyError(w, err)
+			return
+		}
+	}
+	if body.Before == "" {
+		upper, err = neighbourKey(ctx, id, lower, true)
+	} else if body.After == "" {
+		lower, err = neighbourKey(ctx, id, upper, false)
+	}
+	if err != nil {
+		sortKeyError(w, err)
+		return
+	}
+	key, err := keyBetween(lower, upper)
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "The after todo must come before the before todo",
+		})
+		return
+	}
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"sort_key": key, "updatedat": time.Now()}})
+	if err != nil {
+		sortKeyError(w, err)
+		return
+	}
+	if res.MatchedCount == 0 {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "Todo not found",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":  "Move successful",
+		"todo_id":  id.Hex(),
+		"sort_key": key,
+	})
+}
+
+func sortKeyOf(ctx context.Context, hex string) (string, error) {
+	id, err := parseTodoID(hex)
+	if err != nil {
+		return "", mongo.ErrNoDocuments
+	}
+	var t todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+		return "", err
+	}
+	if t.SortKey == "" {
+		return "", errInvalidSortKeys
+	}
+	return t.SortKey, nil
+}
+
+// neighbourKey finds the key of the todo next to key, ignoring the todo being
+// moved. It returns "" at either end of the list.
+func neighbourKey(ctx context.Context, moving todoID, key string, next bool) (string, error) {
+	op, order := "$lt", -1
+	if next {
+		op, order = "$gt", 1
+	}
+	var t todoModel
+	err := collection.FindOne(ctx,
+		bson.M{"_id": bson.M{"$ne": moving}, "sort_key": bson.M{op: key}},
+		options.FindOne().SetSort(bson.M{"sort_key": order}),
+	).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return t.SortKey, err
+}
+
+func sortKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "Todo not found",
+		})
+	case errors.Is(err, errInvalidSortKeys):
+		rnd.JSON(w, http.StatusConflict, renderer.M{
+			"message": "Ordering is still being initialised, try again shortly",
+		})
+	default:
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Move failed",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxPerPage = 500
+
+var errInvalidPage = errors.New("page and per_page must be positive integers")
+
+// page is a window of a list response selected with ?page= (1-based) and
+// ?per_page=. Lists are only paginated when either parameter is given, so
+// existing clients keep getting everything.
+type page struct {
+	Number, Size int
+}
+
+// requestPage reads the pagination parameters. It reports false when the
+// request asked for the whole list.
+func requestPage(r *http.Request) (page, bool, error) {
+	q := r.URL.Query()
+	if q.Get("page") == "" && q.Get("per_page") == "" {
+		return page{}, false, nil
+	}
+	p := page{Number: 1, Size: 50}
+	for param, target := range map[string]*int{"page": &p.Number, "per_page": &p.Size} {
+		if raw := q.Get(param); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				return page{}, false, errInvalidPage
+			}
+			*target = n
+		}
+	}
+	p.Size = min(p.Size, maxPerPage)
+	return p, true, nil
+}
+
+func (p page) apply(opts *options.FindOptions) *options.FindOptions {
+	return opts.SetSkip(int64((p.Number - 1) * p.Size)).SetLimit(int64(p.Size))
+}
+
+func (p page) last(total int64) int {
+	return max(1, int((total+int64(p.Size)-1)/int64(p.Size)))
+}
+
+type pageLink struct {
+	rel, href string
+}
+
+// pageLinks returns the first, prev, next and last pages. The links are
+// relative to the request and keep its other query parameters.
+func pageLinks(r *http.Request, p page, total int64) []pageLink {
+	last := p.last(total)
+	link := func(number int, rel string) pageLink {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(number))
+		q.Set("per_page", strconv.Itoa(p.Size))
+		return pageLink{rel, r.URL.Path + "?" + q.Encode()}
+	}
+	links := []pageLink{link(1, "first")}
+	if p.Number > 1 {
+		links = append(links, link(min(p.Number-1, last), "prev"))
+	}
+	if p.Number < last {
+		links = append(links, link(p.Number+1, "next"))
+	}
+	return append(links, link(last, "last"))
+}
+
+// setPageLinks sends the page links as an RFC 8288 Link header.
+func setPageLinks(w http.ResponseWriter, r *http.Request, p page, total int64) {
+	header := []string{}
+	for _, l := range pageLinks(r, p, total) {
+		header = append(header, fmt.Sprintf(`<%s>; rel="%s"`, l.href, l.rel))
+	}
+	w.Header().Add("Link", strings.Join(header, ", "))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	pdfPageWidth   = 595 // A4 in points
+	pdfPageHeight  = 842
+	pdfMargin      = 56
+	pdfLineHeight  = 16
+	pdfMaxLineRune = 80
+)
+
+type pdfLine struct {
+	text string
+	size int
+	bold bool
+}
+
+// exportListPDF renders the todos of a list as a printable PDF, grouped by
+// status in workflow order with items in a Done status checked off. Lists
+// are identified by their name.
+func exportListPDF(w http.ResponseWriter, r *http.Request) {
+	list, err := listName(r)
+	if err != nil || list == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+		})
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}})
+	cur, err := collection.Find(ctx, bson.M{"list": list}, opts)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch todo",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if len(todos) == 0 {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "List not found",
+		})
+		return
+	}
+
+	lines := []pdfLine{
+		{text: list, size: 20, bold: true},
+		{text: "Exported " + time.Now().Format("2 Jan 2006 15:04"), size: 9},
+		{},
+	}
+	groups := append([]workflowStatus{}, workflow.Statuses...)
+	for _, t := range todos {
+		// Statuses since removed from the workflow get a group of their own.
+		if key := statusOf(t); !containsStatus(groups, key) {
+			groups = append(groups, workflowStatus{Key: key, Name: key})
+		}
+	}
+	for _, group := range groups {
+		lines = append(lines, pdfLine{text: group.Name, size: 14, bold: true})
+		n := 0
+		for _, t := range todos {
+			if statusOf(t) != group.Key {
+				continue
+			}
+			box := "[ ] "
+			if group.Done {
+				box = "[x] "
+			}
+			text := box + t.Title
+			if t.DueAt != nil {
+				text += "  (due " + t.DueAt.Format("2 Jan 2006") + ")"
+			}
+			for _, l := range wrapPDFText(text, pdfMaxLineRune) {
+				lines = append(lines, pdfLine{text: l, size: 11})
+			}
+			n++
+		}
+		if n == 0 {
+			lines = append(lines, pdfLine{text: "Nothing here.", size: 11})
+		}
+		lines = append(lines, pdfLine{})
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, strings.ReplaceAll(list, `"`, "")))
+	w.Write(renderPDF(lines))
+}
+
+func containsStatus(statuses []workflowStatus, key string) bool {
+	for _, s := range statuses {
+		if s.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func wrapPDFText(s string, width int) []string {
+	words := strings.Fields(s)
+	lines := []string{}
+	current := ""
+	for _, word := range words {
+		if current != "" && len([]rune(current))+1+len([]rune(word)) > width {
+			lines = append(lines, current)
+			current = "    " + word
+			continue
+		}
+		if current == "" {
+			current = word
+		} else {
+			current += " " + word
+		}
+	}
+	return append(lines, current)
+}
+
+// renderPDF lays the lines out on as many A4 pages as needed using the
+// built-in Helvetica fonts, so no font files have to be embedded.
+func renderPDF(lines []pdfLine) []byte {
+	perPage := (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+	pages := [][]pdfLine{}
+	for len(lines) > perPage {
+		pages = append(pages, lines[:perPage])
+		lines = lines[perPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1-4 are the catalog, page tree and the two fonts; each page then
+	// takes two objects, the page itself and its content stream.
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	kids := []string{}
+	for i, page := range pages {
+		pageObj := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+		var content bytes.Buffer
+		y := pdfPageHeight - pdfMargin
+		for _, l := range page {
+			if l.text != "" {
+				font := "F1"
+				if l.bold {
+					font = "F2"
+				}
+				fmt.Fprintf(&content, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, l.size, pdfMargin, y, escapePDFText(l.text))
+			}
+			y -= pdfLineHeight
+		}
+		fmt.Fprintf(&content, "BT /F1 8 Tf %d %d Td (Page %d of %d) Tj ET\n", pdfMargin, pdfMargin/2, i+1, len(pages))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, pageObj+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+// escapePDFText converts s to a WinAnsi string literal body, replacing
+// characters the standard fonts cannot show.
+func escapePDFText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
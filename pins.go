@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// toggleFlag returns a handler that flips a boolean field on the todo in a
+// single update, so two quick clicks never race each other.
+func toggleFlag(field string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		id, err := parseTodoID(chi.URLParam(r, "id"))
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{
+				"message": "Error Parsing your request",
+				"error":   err.Error(),
+			})
+			return
+		}
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			field:       bson.M{"$not": bson.A{"$" + field}},
+			"updatedat": time.Now(),
+		}}}}
+		var t todoModel
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&t)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			rnd.JSON(w, http.StatusNotFound, renderer.M{
+				"message": "Todo not found",
+			})
+			return
+		}
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+				"message": "Error updating the todo",
+				"error":   err.Error(),
+			})
+			return
+		}
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"message": "Todo updated successfully",
+			"data":    newTodo(t),
+		})
+	}
+}
+
+// flagFilter matches ?name=true against the field, treating a missing field
+// as false.
+func flagFilter(filter bson.M, r *http.Request, name string) {
+	switch r.URL.Query().Get(name) {
+	case "true":
+		filter[name] = true
+	case "false":
+		filter[name] = bson.M{"$ne": true}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const pomodorosCollectionName = "pomodoros"
+
+var (
+	pomodorosCollection *mongo.Collection
+	pomodoroMinutes     = loadPomodoroMinutes()
+)
+
+func loadPomodoroMinutes() int {
+	n, err := strconv.Atoi(env("TODO_POMODORO_MINUTES", "25"))
+	if err != nil || n < 1 {
+		return 25
+	}
+	return n
+}
+
+// pomodoro is one focus session on a todo. Only one session runs at a time:
+// starting another abandons the running one.
+type pomodoro struct {
+	ID          primitive.ObjectID `bson:"_id" json:"_id"`
+	TodoID      todoID             `bson:"todo_id" json:"todo_id"`
+	Minutes     int                `bson:"minutes" json:"minutes"`
+	StartedAt   time.Time          `bson:"started_at" json:"started_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	AbandonedAt *time.Time         `bson:"abandoned_at,omitempty" json:"abandoned_at,omitempty"`
+}
+
+var runningPomodoro = bson.M{"completed_at": bson.M{"$exists": false}, "abandoned_at": bson.M{"$exists": false}}
+
+func pomodoroHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", pomodoroReport)
+	r.Post("/{id}/complete", completePomodoro)
+	return r
+}
+
+func startPomodoro(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Minutes int `json:"minutes"`
+	}
+	// minutes is optional, so an empty or missing body is fine.
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	if body.Minutes <= 0 {
+		body.Minutes = pomodoroMinutes
+	}
+	now := time.Now()
+	p := pomodoro{
+		ID:        primitive.NewObjectID(),
+		TodoID:    id,
+		Minutes:   body.Minutes,
+		StartedAt: now,
+	}
+	_, err := pomodorosCollection.UpdateMany(ctx, runningPomodoro, bson.M{"$set": bson.M{"abandoned_at": now}})
+	if err == nil {
+		_, err = pomodorosCollection.InsertOne(ctx, p)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to start the pomodoro",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Pomodoro started",
+		"data":    p,
+	})
+}
+
+// completePomodoro ends a running session and counts it on its todo.
+func completePomodoro(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	filter := bson.M{"_id": id}
+	for k, v := range runningPomodoro {
+		filter[k] = v
+	}
+	var p pomodoro
+	err = pomodorosCollection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"completed_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusConflict, renderer.M{
+			"message": "No running pomodoro with this ID",
+		})
+		return
+	}
+	if err == nil {
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": p.TodoID}, bson.M{
+			"$inc": bson.M{"pomodoros": 1},
+			"$set": bson.M{"updatedat": time.Now()},
+		})
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to complete the pomodoro",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Pomodoro completed",
+		"data":    p,
+	})
+}
+
+// pomodoroReport counts completed sessions per day over the last ?days= days
+// (7 by default) in the ?tz= time zone, along with the running session.
+func pomodoroReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	loc, err := requestLocation(r)
+	if err != nil {
+		locationError(w, err)
+		return
+	}
+	tz := loc.String()
+	days := 7
+	if v := q.Get("days"); v != "" {
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > 366 {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{
+				"message": "days must be between 1 and 366",
+			})
+			return
+		}
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	counts, err := countPerBucket(ctx, pomodorosCollection, "completed_at", today.AddDate(0, 0, 1-days), today.AddDate(0, 0, 1), "%Y-%m-%d", tz)
+	var running *pomodoro
+	if err == nil {
+		var p pomodoro
+		err = pomodorosCollection.FindOne(ctx, runningPomodoro).Decode(&p)
+		if err == nil {
+			running = &p
+		} else if errors.Is(err, mongo.ErrNoDocuments) {
+			err = nil
+		}
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch pomodoros",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":    counts,
+		"running": running,
+		"tz":      tz,
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	middleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/thedevsaddam/renderer"
+)
+
+// Requests no route answers get RFC 9457 problem details instead of chi's
+// plain text, carrying the request ID that is also in the X-Request-Id
+// header and the log line. Handler errors keep their own JSON shape.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, extra renderer.M) {
+	body := renderer.M{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   detail,
+		"instance": r.URL.Path,
+	}
+	if id := middleware.GetReqID(r.Context()); id != "" {
+		body["request_id"] = id
+	}
+	for key, value := range extra {
+		body[key] = value
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func routeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "No route matches "+r.URL.Path, nil)
+}
+
+// methodNotAllowed answers a method the path does not support, listing the
+// ones it does in Allow and in the body.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeProblem(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path, renderer.M{
+		"allowed_methods": allowed,
+	})
+}
+
+// requestIDHeader returns the request ID set by middleware.RequestID, so
+// clients can quote it in reports.
+func requestIDHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(middleware.RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Companion apps register their push token as a device. There are no user
+// accounts, so every device gets the notifications that are routed to the
+// push channel. Each push is a job, so failed sends are retried, and devices
+// the push service no longer knows are removed.
+const devicesCollectionName = "devices"
+
+var devicesCollection *mongo.Collection
+
+type device struct {
+	Token      string    `bson:"_id" json:"token" validate:"required,max=4096"`
+	Platform   string    `bson:"platform" json:"platform" validate:"required"`
+	Name       string    `bson:"name,omitempty" json:"name,omitempty" validate:"max=200"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
+}
+
+type pushMessage struct {
+	Title  string `bson:"title" json:"title"`
+	Body   string `bson:"body" json:"body"`
+	Type   string `bson:"type" json:"type"`
+	TodoID string `bson:"todo_id,omitempty" json:"todo_id,omitempty"`
+	// CollapseID makes a push replace an earlier one with the same ID that
+	// is still shown, such as the previous reminder of a snoozed todo.
+	CollapseID string `bson:"collapse_id,omitempty" json:"collapse_id,omitempty"`
+}
+
+// pushSender delivers pushes to the devices of one platform. Devices record
+// their platform when they register.
+type pushSender interface {
+	enabled() bool
+	send(ctx context.Context, token string, m pushMessage) error
+}
+
+// errDeviceGone is returned by senders for tokens that are no longer valid,
+// such as after the app was uninstalled.
+var errDeviceGone = errors.New("the device is no longer registered")
+
+var pushSenders = map[string]pushSender{
+	"android": fcm,
+	"ios":     apns,
+}
+
+func pushEnabled() bool {
+	for _, s := range pushSenders {
+		if s.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+type pushJobPayload struct {
+	Token    string      `bson:"token"`
+	Platform string      `bson:"platform"`
+	Message  pushMessage `bson:"message"`
+}
+
+// queuePush queues m for every registered device whose platform can be
+// sent to.
+func queuePush(ctx context.Context, m pushMessage) error {
+	cur, err := devicesCollection.Find(ctx, bson.M{})
+	devices := []device{}
+	if err == nil {
+		err = cur.All(ctx, &devices)
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if s, ok := pushSenders[d.Platform]; !ok || !s.enabled() {
+			continue
+		}
+		if _, err := enqueueJob(ctx, "push", pushJobPayload{Token: d.Token, Platform: d.Platform, Message: m}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runPushJob(ctx context.Context, payload bson.Raw) (interface{}, error) {
+	var p pushJobPayload
+	if err := bson.Unmarshal(payload, &p); err != nil {
+		return nil, permanent(err)
+	}
+	s, ok := pushSenders[p.Platform]
+	if !ok || !s.enabled() {
+		return nil, permanent(fmt.Errorf("push to %s is not configured", p.Platform))
+	}
+	err := s.send(ctx, p.Token, p.Message)
+	if errors.Is(err, errDeviceGone) {
+		if _, err := devicesCollection.DeleteOne(ctx, bson.M{"_id": p.Token}); err != nil {
+			return nil, err
+		}
+		log.Printf("push: removed a %s device that is no longer registered\n", p.Platform)
+		return "device removed", nil
+	}
+	return nil, err
+}
+
+func deviceHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listDevices)
+	r.Post("/", registerDevice)
+	r.Delete("/{token}", unregisterDevice)
+	return r
+}
+
+func listDevices(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := devicesCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	devices := []device{}
+	if err == nil {
+		err = cur.All(ctx, &devices)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch devices",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": devices,
+	})
+}
+
+// registerDevice adds a device or, for a token that is already known,
+// updates its name and when it was last seen.
+func registerDevice(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var d device
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	d.Token, d.Name = strings.TrimSpace(d.Token), strings.TrimSpace(d.Name)
+	err := validate.Struct(&d)
+	if _, known := pushSenders[d.Platform]; err == nil && !known {
+		err = fmt.Errorf("unknown platform %q", d.Platform)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid device",
+			"error":   err.Error(),
+		})
+		return
+	}
+	now := time.Now()
+	err = devicesCollection.FindOneAndUpdate(ctx, bson.M{"_id": d.Token},
+		bson.M{
+			"$set":         bson.M{"platform": d.Platform, "name": d.Name, "last_seen_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&d)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Device registration failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Device registered",
+		"data":    d,
+	})
+}
+
+func unregisterDevice(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := devicesCollection.DeleteOne(ctx, bson.M{"_id": chi.URLParam(r, "token")})
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Error removing the device",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if res.DeletedCount == 0 {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "Device not found",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Device removed",
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows limit calls per fixed window across the whole process.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	count  int
+	reset  time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window}
+}
+
+// allow counts a call and reports whether it is within the limit. The
+// response gets the limit, the calls remaining and when the window resets,
+// both as the common X-RateLimit-* headers and the RateLimit-* headers of
+// the IETF draft, so clients can slow down before they are refused.
+func (l *rateLimiter) allow(w http.ResponseWriter, now time.Time) bool {
+	l.mu.Lock()
+	if now.After(l.reset) {
+		l.count, l.reset = 0, now.Add(l.window)
+	}
+	allowed := l.count < l.limit
+	if allowed {
+		l.count++
+	}
+	remaining, reset := l.limit-l.count, l.reset
+	l.mu.Unlock()
+
+	seconds := strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds())))
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	h.Set("RateLimit-Limit", strconv.Itoa(l.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", seconds)
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", l.limit, int(l.window.Seconds())))
+	if !allowed {
+		h.Set("Retry-After", seconds)
+	}
+	return allowed
+}
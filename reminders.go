@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Reminders are sent as notifications once reminder_at has passed. The
+// reminder_at a todo was last reminded for is stored as reminded_for, so
+// snoozing or moving a reminder sends it again. Reminders further in the
+// past than reminderGrace, such as after a long outage, are not sent.
+//
+// When reminders go out as pushes, none are sent during the quiet hours;
+// those that came due meanwhile are sent when the quiet hours end.
+const (
+	reminderPollInterval = 30 * time.Second
+	reminderGrace        = time.Hour
+)
+
+func reminderScheduler(ctx context.Context) {
+	ticker := time.NewTicker(reminderPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if enabled, _, _ := maintenanceState(); enabled {
+				continue
+			}
+			if err := sendReminders(ctx, time.Now()); err != nil {
+				log.Printf("reminders: %s\n", err)
+			}
+		}
+	}
+}
+
+func sendReminders(ctx context.Context, now time.Time) error {
+	grace := reminderGrace
+	if prefs, err := loadNotificationPrefs(ctx); err == nil && prefs.wants("reminder", channelPush) && pushEnabled() {
+		loc, err := preferredLocation(ctx)
+		if err != nil {
+			loc = time.UTC
+		}
+		if prefs.QuietHours.contains(now.In(loc)) {
+			return nil
+		}
+		grace += prefs.QuietHours.length()
+	}
+	cur, err := collection.Find(ctx, bson.M{
+		"reminder_at": bson.M{"$lte": now, "$gt": now.Add(-grace)},
+		"iscompleted": false,
+		"archived":    bson.M{"$ne": true},
+		"$expr":       bson.M{"$ne": bson.A{"$reminded_for", "$reminder_at"}},
+	})
+	if err != nil {
+		return err
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		return err
+	}
+	for _, t := range todos {
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": t.ID, "reminder_at": t.ReminderAt, "reminded_for": bson.M{"$ne": t.ReminderAt}},
+			bson.M{"$set": bson.M{"reminded_for": t.ReminderAt}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		notify(ctx, notification{
+			Type:       "reminder",
+			Message:    "Reminder: " + outboundTitle(t),
+			TodoID:     t.ID.Hex(),
+			collapseID: "reminder-" + t.ID.Hex(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rules run actions when something happens to a todo, such as "when a todo
+// tagged urgent is created, star it and notify me". A rule has a trigger,
+// an optional condition in the saved filter format and a list of actions.
+// Changes made by actions do not trigger rules again, so rules cannot loop.
+// There are no assignment or chat actions because todos have no owner and
+// the app has no integrations; notify writes to the in-app feed.
+const (
+	rulesCollectionName    = "rules"
+	ruleRunsCollectionName = "rule_runs"
+	ruleRunsKept           = 30 * 24 * time.Hour
+)
+
+const (
+	triggerCreated   = "created"
+	triggerUpdated   = "updated"
+	triggerCompleted = "completed"
+	triggerOverdue   = "overdue"
+)
+
+var ruleTriggers = map[string]bool{triggerCreated: true, triggerUpdated: true, triggerCompleted: true, triggerOverdue: true}
+
+var (
+	rulesCollection    *mongo.Collection
+	ruleRunsCollection *mongo.Collection
+)
+
+type (
+	rule struct {
+		ID        primitive.ObjectID `bson:"_id" json:"_id"`
+		Name      string             `bson:"name" json:"name" validate:"required,max=200"`
+		Trigger   string             `bson:"trigger" json:"trigger" validate:"required"`
+		Condition *filterExpr        `bson:"condition,omitempty" json:"condition,omitempty"`
+		Actions   []ruleAction       `bson:"actions" json:"actions"`
+		Enabled   bool               `bson:"enabled" json:"enabled"`
+		CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	}
+	// ruleAction is one of add_tags (Tags), set_list (List), set_color
+	// (Color), star, pin or notify (Message, optional).
+	ruleAction struct {
+		Type    string   `bson:"type" json:"type"`
+		Tags    []string `bson:"tags,omitempty" json:"tags,omitempty"`
+		List    string   `bson:"list,omitempty" json:"list,omitempty"`
+		Color   string   `bson:"color,omitempty" json:"color,omitempty"`
+		Message string   `bson:"message,omitempty" json:"message,omitempty"`
+	}
+	// ruleRun is the execution log entry of one rule matching one todo.
+	ruleRun struct {
+		ID      primitive.ObjectID `bson:"_id" json:"_id"`
+		RuleID  primitive.ObjectID `bson:"rule_id" json:"rule_id"`
+		Trigger string             `bson:"trigger" json:"trigger"`
+		TodoID  todoID             `bson:"todo_id" json:"todo_id"`
+		At      time.Time          `bson:"at" json:"at"`
+		Actions []string           `bson:"actions" json:"actions"`
+		Error   string             `bson:"error,omitempty" json:"error,omitempty"`
+	}
+)
+
+var ruleIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "trigger", Value: 1}, {Key: "enabled", Value: 1}}},
+}
+
+var ruleRunIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "rule_id", Value: 1}, {Key: "at", Value: -1}}},
+	{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ruleRunsKept.Seconds())),
+	},
+}
+
+func (rl *rule) check() error {
+	if !ruleTriggers[rl.Trigger] {
+		return fmt.Errorf("unknown trigger %q, use created, updated, completed or overdue", rl.Trigger)
+	}
+	if rl.Condition != nil {
+		if _, err := rl.Condition.compile(time.Now()); err != nil {
+			return err
+		}
+	}
+	if len(rl.Actions) == 0 {
+		return errors.New("a rule needs at least one action")
+	}
+	for i, a := range rl.Actions {
+		switch a.Type {
+		case "add_tags":
+			if len(a.Tags) == 0 {
+				return fmt.Errorf("action %d: add_tags needs tags", i+1)
+			}
+			if err := checkTodoContent("", a.Tags, nil); err != nil {
+				return fmt.Errorf("action %d: %w", i+1, err)
+			}
+		case "set_list":
+			rl.Actions[i].List = strings.TrimSpace(a.List)
+		case "set_color":
+			color, ok := normalizeColor(a.Color)
+			if !ok {
+				return fmt.Errorf("action %d: invalid color %q", i+1, a.Color)
+			}
+			rl.Actions[i].Color = color
+		case "star", "pin", "notify":
+		default:
+			return fmt.Errorf("action %d: unknown type %q", i+1, a.Type)
+		}
+	}
+	return nil
+}
+
+// fireRules runs the enabled rules for trigger against the todos, logging
+// every match.
+func fireRules(trigger string, todos ...todoModel) {
+	if len(todos) == 0 {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cur, err := rulesCollection.Find(ctx, bson.M{"trigger": trigger, "enabled": true})
+	rules := []rule{}
+	if err == nil {
+		err = cur.All(ctx, &rules)
+	}
+	if err != nil {
+		log.Printf("rules: %s\n", err)
+		return
+	}
+	now := time.Now()
+	for _, rl := range rules {
+		for _, t := range todos {
+			if rl.Condition != nil && !rl.Condition.matches(t, now) {
+				continue
+			}
+			run := ruleRun{ID: primitive.NewObjectID(), RuleID: rl.ID, Trigger: trigger, TodoID: t.ID, At: now}
+			run.Actions, err = applyRule(ctx, rl, t)
+			if err != nil {
+				run.Error = err.Error()
+			}
+			if _, err := ruleRunsCollection.InsertOne(ctx, run); err != nil {
+				log.Printf("rules: %s\n", err)
+			}
+		}
+	}
+}
+
+// applyRule runs the actions of rl on t and returns those that ran. Tags
+// that would take the todo past maxTags are not added; the other actions
+// still run and the error says why.
+func applyRule(ctx context.Context, rl rule, t todoModel) ([]string, error) {
+	set := bson.M{}
+	tags := []string{}
+	done := []string{}
+	for _, a := range rl.Actions {
+		switch a.Type {
+		case "add_tags":
+			tags = append(tags, a.Tags...)
+		case "set_list":
+			set["list"] = a.List
+		case "set_color":
+			set["color"] = a.Color
+		case "star":
+			set["starred"] = true
+		case "pin":
+			set["pinned"] = true
+		case "notify":
+			message := a.Message
+			if message == "" {
+				message = fmt.Sprintf("%s matched the rule %q", outboundTitle(t), rl.Name)
+			}
+			notify(ctx, notification{Type: "rule", Message: message, TodoID: t.ID.Hex(), RuleID: rl.ID.Hex()})
+		}
+		done = append(done, a.Type)
+	}
+	var skipped error
+	if merged := mergeTags(t.Tags, tags); len(merged) > maxTags {
+		skipped = fmt.Errorf("add_tags skipped: the todo would have %d tags, at most %d are allowed", len(merged), maxTags)
+		tags = nil
+		ran := done[:0]
+		for _, action := range done {
+			if action != "add_tags" {
+				ran = append(ran, action)
+			}
+		}
+		done = ran
+	}
+	if len(set) > 0 || len(tags) > 0 {
+		set["updatedat"] = time.Now()
+		update := bson.M{"$set": set}
+		if len(tags) > 0 {
+			update["$addToSet"] = bson.M{"tags": bson.M{"$each": tags}}
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": t.ID}, update); err != nil {
+			return done, err
+		}
+	}
+	return done, skipped
+}
+
+// mergeTags returns the distinct tags of both lists, as $addToSet leaves
+// them.
+func mergeTags(tags, added []string) []string {
+	merged := []string{}
+	for _, tag := range append(append([]string{}, tags...), added...) {
+		if !containsString(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// todosCreated runs what follows the creation of todos.
+func todosCreated(todos ...todoModel) {
+	notifySavedSearches(todos...)
+	fireRules(triggerCreated, todos...)
+}
+
+// todoUpdated records the history of an edit and fires the rules for it.
+// before is the todo as it was.
+func todoUpdated(before todoModel) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var after todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": before.ID}).Decode(&after); err != nil {
+		log.Printf("history: %s\n", err)
+		return
+	}
+	if !recordHistory(before, after) {
+		return
+	}
+	fireRules(triggerUpdated, after)
+	if after.IsCompleted && !before.IsCompleted {
+		fireRules(triggerCompleted, after)
+	}
+}
+
+func ruleHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listRules)
+	r.Post("/", createRule)
+	r.Get("/{id}", getRule)
+	r.Put("/{id}", updateRule)
+	r.Delete("/{id}", deleteRule)
+	r.Get("/{id}/runs", listRuleRuns)
+	return r
+}
+
+func listRules(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := rulesCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	rules := []rule{}
+	if err == nil {
+		err = cur.All(ctx, &rules)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch rules",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": rules,
+	})
+}
+
+// decodeRule reads and validates a rule from the request body, writing the
+// error response itself when that fails.
+func decodeRule(w http.ResponseWriter, r *http.Request) (*rule, bool) {
+	var rl rule
+	if err := json.NewDecoder(r.Body).Decode(&rl); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	rl.Name = strings.TrimSpace(rl.Name)
+	err := validate.Struct(&rl)
+	if err == nil {
+		err = rl.check()
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid rule",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	return &rl, true
+}
+
+func createRule(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rl, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+	rl.ID = primitive.NewObjectID()
+	rl.CreatedAt = time.Now()
+	if _, err := rulesCollection.InsertOne(ctx, rl); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Rule creation failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Rule creation successful",
+		"data":    rl,
+	})
+}
+
+// findRule loads the rule named by the {id} URL parameter, writing the error
+// response itself when that fails.
+func findRule(ctx context.Context, w http.ResponseWriter, r *http.Request) (*rule, bool) {
+	id, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	var rl rule
+	if err := rulesCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&rl); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, renderer.M{
+			"message": "Rule not found",
+		})
+		return nil, false
+	}
+	return &rl, true
+}
+
+func getRule(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if rl, ok := findRule(ctx, w, r); ok {
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"data": rl,
+		})
+	}
+}
+
+func updateRule(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	existing, ok := findRule(ctx, w, r)
+	if !ok {
+		return
+	}
+	rl, ok := decodeRule(w, r)
+	if !ok {
+		return
+	}
+	rl.ID, rl.CreatedAt = existing.ID, existing.CreatedAt
+	if _, err := rulesCollection.ReplaceOne(ctx, bson.M{"_id": rl.ID}, rl); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Rule update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Rule update successful",
+		"data":    rl,
+	})
+}
+
+func deleteRule(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rl, ok := findRule(ctx, w, r)
+	if !ok {
+		return
+	}
+	if _, err := rulesCollection.DeleteOne(ctx, bson.M{"_id": rl.ID}); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Error deleting the rule",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Rule deletion successful",
+		"rule_id": rl.ID.Hex(),
+	})
+}
+
+// listRuleRuns returns the latest executions of a rule, newest first.
+func listRuleRuns(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rl, ok := findRule(ctx, w, r)
+	if !ok {
+		return
+	}
+	cur, err := ruleRunsCollection.Find(ctx, bson.M{"rule_id": rl.ID},
+		options.Find().SetSort(bson.M{"at": -1}).SetLimit(100))
+	runs := []ruleRun{}
+	if err == nil {
+		err = cur.All(ctx, &runs)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch rule runs",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": runs,
+	})
+}
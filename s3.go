@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// s3Client is a minimal S3 client signing requests with AWS Signature V4. It
+// reads credentials from the standard AWS_* environment variables and talks to
+// AWS_S3_ENDPOINT instead of AWS when set, which covers MinIO and friends.
+type s3Client struct {
+	awsCredentials
+	endpoint string
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key" json:"key"`
+	Size         int64     `xml:"Size" json:"size"`
+	LastModified time.Time `xml:"LastModified" json:"last_modified"`
+}
+
+func newS3Client() (*s3Client, error) {
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("s3 targets: %w", err)
+	}
+	c := &s3Client{awsCredentials: creds, endpoint: strings.TrimSuffix(os.Getenv("AWS_S3_ENDPOINT"), "/")}
+	if c.endpoint == "" {
+		c.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.region)
+	}
+	return c, nil
+}
+
+// parseS3URL splits s3://bucket/key into its bucket and key.
+func parseS3URL(raw string) (bucket, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid s3 url %q", raw)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func (c *s3Client) objectURL(bucket, key string) string {
+	return c.endpoint + "/" + bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+func (c *s3Client) Put(bucket, key string, body io.ReadSeeker, size int64) error {
+	req, err := http.NewRequest(http.MethodPut, c.objectURL(bucket, key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = c.do(req)
+	return err
+}
+
+// Get returns the object body, which the caller must close.
+func (c *s3Client) Get(bucket, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.doStream(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (c *s3Client) Delete(bucket, key string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.objectURL(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *s3Client) List(bucket, prefix string) ([]s3Object, error) {
+	objects := []s3Object{}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, c.endpoint+"/"+bucket+"?"+strings.ReplaceAll(q.Encode(), "+", "%20"), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []s3Object `xml:"Contents"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (c *s3Client) do(req *http.Request) ([]byte, error) {
+	res, err := c.doStream(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+func (c *s3Client) doStream(req *http.Request) (*http.Response, error) {
+	// Payloads are sent unsigned, which S3 accepts and which lets uploads
+	// be streamed from disk.
+	c.sign(req, "s3", "UNSIGNED-PAYLOAD", time.Now().UTC())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, res.Status, msg)
+	}
+	return res, nil
+}
+
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxSearchResults    = 20
+	maxSearchCandidates = 500
+	// minGramMatch is the share of the query's trigrams a title must contain.
+	minGramMatch     = 0.5
+	atlasSearchEdits = 2
+)
+
+// searchMode picks the typo tolerant search backend: "ngram" uses trigrams
+// stored on each todo and works everywhere, "atlas" uses an Atlas Search
+// index named by TODO_ATLAS_SEARCH_INDEX with fuzzy matching.
+var (
+	searchMode       = env("TODO_SEARCH_MODE", "ngram")
+	atlasSearchIndex = env("TODO_ATLAS_SEARCH_INDEX", "default")
+)
+
+// titleGrams returns the distinct trigrams of each word in the title, padded
+// so that word starts and ends count as well. They are blind indexed while
+// titles are encrypted.
+func titleGrams(title string) []string {
+	seen := map[string]bool{}
+	grams := []string{}
+	for _, word := range strings.Fields(titleKey(title)) {
+		padded := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			gram := string(padded[i : i+3])
+			if !seen[gram] {
+				seen[gram] = true
+				grams = append(grams, blindIndex(gram))
+			}
+		}
+	}
+	return grams
+}
+
+// searchTodos finds todos whose title resembles ?q= even when it is misspelt.
+// The usual list filters can be combined with it.
+func searchTodos(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	q := r.URL.Query().Get("q")
+	if titleKey(q) == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "q is required",
+		})
+		return
+	}
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	var todos []todoModel
+	switch searchMode {
+	case "ngram":
+		todos, err = searchByGrams(ctx, q, filter)
+	case "atlas":
+		todos, err = searchAtlas(ctx, q, filter)
+	default:
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{
+			"message": "Unknown TODO_SEARCH_MODE",
+			"mode":    searchMode,
+		})
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Search failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	todoList := []todo{}
+	for _, t := range todos {
+		todoList = append(todoList, newTodo(t))
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": todoList,
+	})
+}
+
+// searchByGrams fetches todos sharing any trigram with the query and keeps
+// those containing enough of them, best matches first.
+func searchByGrams(ctx context.Context, q string, filter bson.M) ([]todoModel, error) {
+	grams := titleGrams(q)
+	filter["title_grams"] = bson.M{"$in": grams}
+	cur, err := collection.Find(ctx, filter, options.Find().SetLimit(maxSearchCandidates))
+	if err != nil {
+		return nil, err
+	}
+	candidates := []todoModel{}
+	if err := cur.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+	scores := map[todoID]float64{}
+	matches := []todoModel{}
+	for _, t := range candidates {
+		has := map[string]bool{}
+		for _, gram := range t.TitleGrams {
+			has[gram] = true
+		}
+		common := 0
+		for _, gram := range grams {
+			if has[gram] {
+				common++
+			}
+		}
+		if score := float64(common) / float64(len(grams)); score >= minGramMatch {
+			scores[t.ID] = score
+			matches = append(matches, t)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return scores[matches[i].ID] > scores[matches[j].ID]
+	})
+	if len(matches) > maxSearchResults {
+		matches = matches[:maxSearchResults]
+	}
+	return matches, nil
+}
+
+func searchAtlas(ctx context.Context, q string, filter bson.M) ([]todoModel, error) {
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$search", Value: bson.M{
+			"index": atlasSearchIndex,
+			"text": bson.M{
+				"query": q,
+				"path":  "title",
+				"fuzzy": bson.M{"maxEdits": atlasSearchEdits},
+			},
+		}}},
+		{{Key: "$match", Value: filter}},
+		{{Key: "$limit", Value: maxSearchResults}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+// backfillTitleGrams computes search trigrams for todos that have none.
+func backfillTitleGrams() {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"title_grams": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"title": 1}))
+	if err != nil {
+		log.Printf("title grams: %s\n", err)
+		return
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil || len(todos) == 0 {
+		return
+	}
+	models := make([]mongo.WriteModel, len(todos))
+	for i, t := range todos {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": t.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"title_grams": titleGrams(t.Title)}})
+	}
+	if _, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Printf("title grams: %s\n", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret settings such as TODO_MONGO_URI or TODO_ADMIN_TOKEN may hold a
+// reference instead of the value itself:
+//
+//	env:OTHER_VAR                 another environment variable
+//	file:/run/secrets/mongo_uri   a file, trailing newlines trimmed
+//	vault:secret/data/todo#uri    a field of a Vault KV secret (VAULT_ADDR, VAULT_TOKEN)
+//	aws-sm:prod/todo#uri          AWS Secrets Manager, optionally a JSON field of it
+//	aws-kms:<base64>              a ciphertext decrypted with AWS KMS
+//
+// Values without one of these prefixes are used as they are.
+type secretProvider interface {
+	secret(ref string) (string, error)
+}
+
+var secretProviders = map[string]secretProvider{
+	"env":     envSecrets{},
+	"file":    fileSecrets{},
+	"vault":   vaultSecrets{},
+	"aws-sm":  awsSecrets{},
+	"aws-kms": kmsSecrets{},
+}
+
+var secretCache sync.Map
+
+// secret reads a secret setting, resolving references once.
+func secret(key, fallback string) (string, error) {
+	raw := env(key, fallback)
+	scheme, ref, ok := strings.Cut(raw, ":")
+	provider, known := secretProviders[scheme]
+	if !ok || !known {
+		return raw, nil
+	}
+	if v, ok := secretCache.Load(raw); ok {
+		return v.(string), nil
+	}
+	v, err := provider.secret(ref)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	secretCache.Store(raw, v)
+	return v, nil
+}
+
+// mustSecret is secret for settings read at startup.
+func mustSecret(key, fallback string) string {
+	v, err := secret(key, fallback)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+type envSecrets struct{}
+
+func (envSecrets) secret(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	return v, nil
+}
+
+type fileSecrets struct{}
+
+func (fileSecrets) secret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+type vaultSecrets struct{}
+
+// secret reads path#field from Vault. KV version 2 secrets, which nest their
+// data one level deeper, are read as well.
+func (vaultSecrets) secret(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok {
+		return "", errors.New("vault references need a #field")
+	}
+	addr, token := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault %s: %s", path, res.Status)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault %s has no string field %q", path, field)
+	}
+	return v, nil
+}
+
+type awsSecrets struct{}
+
+// secret reads a Secrets Manager secret by name or ARN. With #field the
+// secret string is read as a JSON object and that field returned.
+func (awsSecrets) secret(ref string) (string, error) {
+	id, field, hasField := strings.Cut(ref, "#")
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := creds.callAWS("secretsmanager", "secretsmanager.GetSecretValue",
+		map[string]string{"SecretId": id}, &out); err != nil {
+		return "", err
+	}
+	if !hasField {
+		return out.SecretString, nil
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object", id)
+	}
+	v, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string field %q", id, field)
+	}
+	return v, nil
+}
+
+type kmsSecrets struct{}
+
+// secret decrypts a base64 ciphertext produced by KMS Encrypt.
+func (kmsSecrets) secret(ciphertext string) (string, error) {
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := creds.callAWS("kms", "TrentService.Decrypt",
+		map[string]string{"CiphertextBlob": ciphertext}, &out); err != nil {
+		return "", err
+	}
+	return string(out.Plaintext), nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// insertSeed stores lists and todos built in code, filling in the derived
+// fields createTodo would set. Lists that exist already are left as they
+// are, so seeding does not reset colors chosen since.
+func insertSeed(ctx context.Context, lists []listSettings, todos []todoModel) error {
+	for _, l := range lists {
+		if l.UpdatedAt.IsZero() {
+			l.UpdatedAt = time.Now()
+		}
+		if _, err := listsCollection.UpdateOne(ctx, bson.M{"name": l.Name}, bson.M{"$setOnInsert": l},
+			options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	if len(todos) == 0 {
+		return nil
+	}
+	keys, err := nextSortKeys(ctx, len(todos))
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, len(todos))
+	for i, t := range todos {
+		if t.ID == (todoID{}) {
+			t.ID = newTodoID()
+		}
+		if t.Status == "" {
+			t.Status = workflow.Initial
+			if t.IsCompleted {
+				t.Status = workflow.completeStatus()
+			}
+		}
+		if t.IsCompleted && t.CompletedAt == nil {
+			completedAt := t.UpdatedAt
+			t.CompletedAt = &completedAt
+		}
+		t.SortKey = keys[i]
+		t.TitleKey = storedTitleKey(t.Title)
+		t.TitleGrams = titleGrams(t.Title)
+		docs[i] = t
+	}
+	_, err = collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// seedFixtures is the --file format of the seed command. Todos use the
+// stored field names of the API, such as title, list, tags, due_at and
+// is_completed.
+type seedFixtures struct {
+	Lists []listSettings `json:"lists"`
+	Todos []todoModel    `json:"todos"`
+}
+
+// seedCommand fills a development database:
+//
+//	todo seed --file fixtures.json --count 1000 --seed 42
+//
+// Fixtures are inserted as given; --count adds that many generated todos,
+// the same ones for the same --seed.
+func seedCommand(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	file := fs.String("file", "", "JSON file with lists and todos")
+	count := fs.Int("count", 0, "number of random todos to generate")
+	seed := fs.Int64("seed", 0, "random seed for generated todos, 0 picks one")
+	fs.Parse(args)
+	if *file == "" && *count <= 0 {
+		return fmt.Errorf("seed: give --file, --count or both")
+	}
+	var fixtures seedFixtures
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &fixtures); err != nil {
+			return fmt.Errorf("seed: %s: %w", *file, err)
+		}
+	}
+	now := time.Now()
+	for i := range fixtures.Lists {
+		color, ok := normalizeColor(fixtures.Lists[i].Color)
+		if !ok || fixtures.Lists[i].Name == "" {
+			return fmt.Errorf("seed: list %d needs a name and a valid color", i+1)
+		}
+		fixtures.Lists[i].Color = color
+	}
+	for i := range fixtures.Todos {
+		t := &fixtures.Todos[i]
+		if strings.TrimSpace(t.Title) == "" {
+			return fmt.Errorf("seed: todo %d has no title", i+1)
+		}
+		if t.CreatedAt.IsZero() {
+			t.CreatedAt = now
+		}
+		if t.UpdatedAt.IsZero() {
+			t.UpdatedAt = t.CreatedAt
+		}
+	}
+	if *seed == 0 {
+		*seed = now.UnixNano()
+	}
+	lists := fixtures.Lists
+	if len(lists) == 0 && *count > 0 {
+		lists = []listSettings{{Name: "Work"}, {Name: "Home"}, {Name: "Errands"}}
+	}
+	todos := append(fixtures.Todos, randomTodos(rand.New(rand.NewSource(*seed)), *count, lists, now)...)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := insertSeed(ctx, lists, todos); err != nil {
+		return err
+	}
+	log.Printf("Seeded %d lists and %d todos (seed %d)\n", len(lists), len(todos), *seed)
+	return nil
+}
+
+var (
+	seedVerbs   = []string{"Write", "Review", "Call", "Buy", "Plan", "Fix", "Clean", "Book", "Send", "Update"}
+	seedObjects = []string{"report", "invoice", "dentist", "groceries", "garden", "presentation", "newsletter", "car", "budget", "slides"}
+	seedTags    = []string{"urgent", "email", "calls", "bills", "reading", "later"}
+)
+
+// randomTodos generates n todos spread over the lists, created within the
+// last 60 days and due within 30 days either side of now.
+func randomTodos(rng *rand.Rand, n int, lists []listSettings, now time.Time) []todoModel {
+	todos := make([]todoModel, n)
+	for i := range todos {
+		created := now.Add(-time.Duration(rng.Int63n(int64(60 * 24 * time.Hour))))
+		t := todoModel{
+			Title:     seedVerbs[rng.Intn(len(seedVerbs))] + " " + seedObjects[rng.Intn(len(seedObjects))],
+			CreatedAt: created,
+			UpdatedAt: created,
+		}
+		if len(lists) > 0 {
+			t.List = lists[rng.Intn(len(lists))].Name
+		}
+		if rng.Intn(3) == 0 {
+			t.Tags = []string{seedTags[rng.Intn(len(seedTags))]}
+		}
+		if rng.Intn(2) == 0 {
+			due := now.Add(time.Duration(rng.Int63n(int64(60*24*time.Hour))) - 30*24*time.Hour).Truncate(time.Hour)
+			t.DueAt = &due
+		}
+		if rng.Intn(10) < 3 {
+			t.IsCompleted = true
+		}
+		todos[i] = t
+	}
+	return todos
+}
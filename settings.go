@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	settingsCollectionName = "settings"
+	preferencesID          = "preferences"
+)
+
+var settingsCollection *mongo.Collection
+
+// settingsRefresh is how long settings read from the database are served
+// from memory, so a change made through another replica takes effect
+// within it.
+const settingsRefresh = 5 * time.Second
+
+// preferences are the deployment wide user preferences. There is a single
+// document because the app has no user accounts.
+type preferences struct {
+	Timezone string `bson:"timezone" json:"timezone"`
+}
+
+var (
+	preferencesMu     sync.Mutex
+	cachedPrefs       *preferences
+	preferencesReadAt time.Time
+)
+
+// loadPreferences returns the stored preferences, cached for
+// settingsRefresh after a successful read and replaced whenever they are
+// updated.
+func loadPreferences(ctx context.Context) (preferences, error) {
+	preferencesMu.Lock()
+	defer preferencesMu.Unlock()
+	if cachedPrefs != nil && time.Since(preferencesReadAt) < settingsRefresh {
+		return *cachedPrefs, nil
+	}
+	p := preferences{Timezone: "UTC"}
+	err := settingsCollection.FindOne(ctx, bson.M{"_id": preferencesID}).Decode(&p)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return p, err
+	}
+	cachedPrefs, preferencesReadAt = &p, time.Now()
+	return p, nil
+}
+
+// preferredLocation returns the time zone of the stored preferences.
+func preferredLocation(ctx context.Context) (*time.Location, error) {
+	p, err := loadPreferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return loc, nil
+}
+
+// errUnknownZone is returned by requestLocation for a ?tz= that names no
+// time zone. Its other errors come from reading the preferences.
+var errUnknownZone = errors.New("unknown time zone")
+
+// requestLocation returns the time zone to interpret dates in: ?tz= when
+// given, otherwise the stored preference, otherwise UTC.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p, err := loadPreferences(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tz = p.Timezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", errUnknownZone, tz)
+	}
+	return loc, nil
+}
+
+// locationError answers a request whose time zone could not be worked out.
+func locationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnknownZone) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Unknown time zone",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+		"message": "Failed to fetch settings",
+		"error":   err.Error(),
+	})
+}
+
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := loadPreferences(ctx)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": p,
+	})
+}
+
+func updateSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var p preferences
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "" {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "timezone must be an IANA time zone such as Europe/Berlin",
+		})
+		return
+	}
+	preferencesMu.Lock()
+	defer preferencesMu.Unlock()
+	_, err := settingsCollection.UpdateOne(ctx, bson.M{"_id": preferencesID}, bson.M{"$set": p}, options.Update().SetUpsert(true))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Settings update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	cachedPrefs, preferencesReadAt = &p, time.Now()
+	// It waits for the lock, so it sees the new time zone.
+	go rescheduleAutomations()
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Settings update successful",
+		"data":    p,
+	})
+}
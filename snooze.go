@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxSnoozeHistory is how many snoozes are kept per todo.
+const maxSnoozeHistory = 50
+
+type snooze struct {
+	Field string     `bson:"field" json:"field"`
+	From  *time.Time `bson:"from,omitempty" json:"from,omitempty"`
+	To    time.Time  `bson:"to" json:"to"`
+	At    time.Time  `bson:"at" json:"at"`
+}
+
+// snoozeTodo pushes the reminder forward, or the due date when the todo has
+// no reminder. The body gives either a duration from now ("90m", "2h") or an
+// until timestamp.
+func snoozeTodo(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	var body struct {
+		Duration string     `json:"duration"`
+		Until    *time.Time `json:"until"`
+	}
+	if err == nil {
+		err = json.NewDecoder(r.Body).Decode(&body)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	now := time.Now()
+	var until time.Time
+	switch {
+	case body.Until != nil && body.Duration == "":
+		until = *body.Until
+	case body.Duration != "" && body.Until == nil:
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{
+				"message": "duration must look like 30m or 2h",
+			})
+			return
+		}
+		until = now.Add(d)
+	}
+	if !until.After(now) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Give either a positive duration or an until time in the future",
+		})
+		return
+	}
+
+	var t todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, renderer.M{
+			"message": "Todo not found",
+		})
+		return
+	}
+	entry := snooze{Field: "reminder_at", From: t.ReminderAt, To: until, At: now}
+	if t.ReminderAt == nil && t.DueAt != nil {
+		entry.Field, entry.From = "due_at", t.DueAt
+	}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{entry.Field: until, "updatedat": now},
+		"$push": bson.M{"snoozes": bson.M{
+			"$each":  bson.A{entry},
+			"$slice": -maxSnoozeHistory,
+		}},
+	})
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Snooze failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snooze successful",
+		"todo_id": id.Hex(),
+		"data":    entry,
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// A single page app built into static/app replaces the bundled home page:
+// its files are served at their paths, and any other GET outside the API
+// gets its index.html so the app can route on the client. Without
+// static/app/index.html the home page and plain 404s are served as before.
+const spaDir = "app"
+
+// File names that carry a content hash never change, so they may be cached
+// for good; everything else is revalidated. Hashes are hex of 8 or more
+// digits, as in main.8c1d5e0f.css from webpack, or 8 base64url characters,
+// as in index-BvX3k_9a.js from Vite and Rollup. The latter must hold a
+// digit and a capital, so names like icon-192.png, favicon-32x32.png or
+// font-awesome-4.woff2 are not taken for hashed ones.
+var (
+	hexHash    = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[0-9a-z]+$`)
+	base64Hash = regexp.MustCompile(`[.-]([0-9A-Za-z_-]{8})\.[0-9a-z]+$`)
+)
+
+func hashedAsset(name string) bool {
+	if hexHash.MatchString(name) {
+		return true
+	}
+	m := base64Hash.FindStringSubmatch(name)
+	return m != nil && strings.ContainsAny(m[1], "0123456789") && strings.ContainsAny(m[1], "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
+
+func spaEnabled() bool {
+	_, err := fs.Stat(staticFS, spaDir+"/index.html")
+	return err == nil
+}
+
+// spaFallback answers requests no route matched.
+func spaFallback(w http.ResponseWriter, r *http.Request) {
+	if !spaEnabled() || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		routeNotFound(w, r)
+		return
+	}
+	// A matched pattern means the request reached a legacy API router.
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePatterns) > 0 {
+		routeNotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name != "" && name != "index.html" && serveSPAFile(w, r, name) {
+		return
+	}
+	// Missing assets stay 404 rather than getting the page.
+	if path.Ext(name) != "" && name != "index.html" {
+		routeNotFound(w, r)
+		return
+	}
+	serveSPAFile(w, r, "index.html")
+}
+
+// serveSPAFile serves name from static/app, reporting whether it exists.
+func serveSPAFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := staticFS.Open(spaDir + "/" + name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	content, ok := f.(io.ReadSeeker)
+	if err != nil || info.IsDir() || !ok {
+		return false
+	}
+	switch {
+	case name == "index.html":
+		w.Header().Set("Cache-Control", "no-cache")
+	case hashedAsset(path.Base(name)):
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	// Embedded files have no modification time, so none is sent.
+	http.ServeContent(w, r, name, time.Time{}, content)
+	return true
+}
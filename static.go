@@ -0,0 +1,116 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFS holds the page and email templates. They are compiled into the
+// binary, so it runs from any directory. TODO_STATIC_DIR reads them from a
+// directory instead, for editing them without rebuilding.
+var staticFS = loadStaticFS()
+
+var devFlag = flag.Bool("dev", env("TODO_DEV", "") == "true", "re-read templates on every request and show template errors in full")
+
+func loadStaticFS() fs.FS {
+	if dir := env("TODO_STATIC_DIR", ""); dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(embeddedStatic, "static")
+	checkErr(err)
+	return sub
+}
+
+// templateCache holds the templates parsed from staticFS, by the pattern
+// they were parsed from. With --dev it is bypassed, so edits show up on the
+// next request.
+var templateCache = struct {
+	sync.Mutex
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}{
+	html: map[string]*htmltemplate.Template{},
+	text: map[string]*texttemplate.Template{},
+}
+
+func htmlTemplate(pattern string) (*htmltemplate.Template, error) {
+	if *devFlag {
+		return htmltemplate.ParseFS(staticFS, pattern)
+	}
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	if t, ok := templateCache.html[pattern]; ok {
+		return t, nil
+	}
+	t, err := htmltemplate.ParseFS(staticFS, pattern)
+	if err == nil {
+		templateCache.html[pattern] = t
+	}
+	return t, err
+}
+
+func textTemplate(pattern string) (*texttemplate.Template, error) {
+	if *devFlag {
+		return texttemplate.ParseFS(staticFS, pattern)
+	}
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	if t, ok := templateCache.text[pattern]; ok {
+		return t, nil
+	}
+	t, err := texttemplate.ParseFS(staticFS, pattern)
+	if err == nil {
+		templateCache.text[pattern] = t
+	}
+	return t, err
+}
+
+// parseTemplates parses every template up front, so a broken one stops the
+// server on startup instead of failing requests.
+func parseTemplates() error {
+	for _, pattern := range []string{"home.html", "partials/*.html"} {
+		if _, err := htmlTemplate(pattern); err != nil {
+			return err
+		}
+	}
+	emails, err := fs.Glob(staticFS, emailTemplateDir+"/*.txt")
+	if err != nil {
+		return err
+	}
+	for _, name := range emails {
+		if _, err := textTemplate(name); err != nil {
+			return err
+		}
+		if _, err := htmlTemplate(strings.TrimSuffix(name, ".txt") + ".html"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateError answers a request whose template failed. With --dev the
+// page shows the error, which names the template and line.
+func templateError(w http.ResponseWriter, name string, err error) {
+	log.Printf("template %s: %s\n", name, err)
+	if !*devFlag {
+		http.Error(w, "Failed to render "+name, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, "<!doctype html>\n<title>Template error</title>\n<h1>Failed to render %s</h1>\n<pre>%s</pre>\n",
+		html.EscapeString(name), html.EscapeString(err.Error()))
+}
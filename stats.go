@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type countBucket struct {
+	Key   string `bson:"_id" json:"key"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// todoStats returns summary counts computed in a single $facet aggregation
+// over the todos matching the list filters.
+func todoStats(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$facet": bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":       nil,
+					"total":     bson.M{"$sum": 1},
+					"completed": bson.M{"$sum": bson.M{"$cond": bson.A{"$iscompleted", 1, 0}}},
+					"overdue": bson.M{"$sum": bson.M{"$cond": bson.A{
+						bson.M{"$and": bson.A{
+							bson.M{"$not": bson.A{"$iscompleted"}},
+							bson.M{"$gt": bson.A{"$due_at", nil}},
+							bson.M{"$lt": bson.A{"$due_at", now}},
+						}}, 1, 0,
+					}}},
+					"estimated": bson.M{"$sum": bson.M{"$cond": bson.A{
+						"$iscompleted", 0, bson.M{"$ifNull": bson.A{"$estimated_minutes", 0}},
+					}}},
+					"remaining": bson.M{"$sum": bson.M{"$cond": bson.A{
+						"$iscompleted", 0, bson.M{"$ifNull": bson.A{"$remaining_minutes", bson.M{"$ifNull": bson.A{"$estimated_minutes", 0}}}},
+					}}},
+				}},
+			},
+			"lists": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$ifNull": bson.A{"$list", ""}}, "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"tags": bson.A{
+				bson.M{"$unwind": "$tags"},
+				bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		}},
+	}
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to compute stats",
+			"error":   err.Error(),
+		})
+		return
+	}
+	var res []struct {
+		Totals []struct {
+			Total     int `bson:"total"`
+			Completed int `bson:"completed"`
+			Overdue   int `bson:"overdue"`
+			Estimated int `bson:"estimated"`
+			Remaining int `bson:"remaining"`
+		} `bson:"totals"`
+		Lists []countBucket `bson:"lists"`
+		Tags  []countBucket `bson:"tags"`
+	}
+	if err := cur.All(ctx, &res); err != nil || len(res) == 0 {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to compute stats",
+		})
+		return
+	}
+	stats := res[0]
+	var total, completed, overdue, estimated, remaining int
+	if len(stats.Totals) > 0 {
+		total, completed, overdue = stats.Totals[0].Total, stats.Totals[0].Completed, stats.Totals[0].Overdue
+		estimated, remaining = stats.Totals[0].Estimated, stats.Totals[0].Remaining
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": renderer.M{
+			"total":                  total,
+			"open":                   total - completed,
+			"completed":              completed,
+			"overdue":                overdue,
+			"open_estimated_minutes": estimated,
+			"open_remaining_minutes": remaining,
+			"per_list":               stats.Lists,
+			"per_tag":                stats.Tags,
+		},
+	})
+}
+
+// groupByFields maps the ?group_by= values of todoAggregate to document fields.
+// Array fields are unwound so each element forms its own group.
+var groupByFields = map[string]struct {
+	field string
+	array bool
+}{
+	"list": {field: "list"},
+	"tag":  {field: "tags", array: true},
+}
+
+// todoAggregate returns per-group counts and completion percentages for the
+// todos matching the list filters, for dashboard widgets.
+func todoAggregate(w http.ResponseWriter, r *http.Request) {
+	groupBy := r.URL.Query().Get("group_by")
+	group, ok := groupByFields[groupBy]
+	if !ok {
+		supported := make([]string, 0, len(groupByFields))
+		for name := range groupByFields {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message":   "Unsupported group_by",
+			"group_by":  groupBy,
+			"supported": supported,
+		})
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter, err := todoFilter(r)
+	if err != nil {
+		filterError(w, r, err)
+		return
+	}
+	pipeline := bson.A{bson.M{"$match": filter}}
+	if group.array {
+		pipeline = append(pipeline, bson.M{"$unwind": "$" + group.field})
+	}
+	pipeline = append(pipeline,
+		bson.M{"$group": bson.M{
+			"_id":       bson.M{"$ifNull": bson.A{"$" + group.field, ""}},
+			"total":     bson.M{"$sum": 1},
+			"completed": bson.M{"$sum": bson.M{"$cond": bson.A{"$iscompleted", 1, 0}}},
+		}},
+		bson.M{"$sort": bson.D{{Key: "total", Value: -1}, {Key: "_id", Value: 1}}},
+	)
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to aggregate todos",
+			"error":   err.Error(),
+		})
+		return
+	}
+	groups := []struct {
+		Key              string  `bson:"_id" json:"key"`
+		Total            int     `bson:"total" json:"total"`
+		Completed        int     `bson:"completed" json:"completed"`
+		CompletedPercent float64 `bson:"-" json:"completed_percent"`
+	}{}
+	if err := cur.All(ctx, &groups); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to aggregate todos",
+			"error":   err.Error(),
+		})
+		return
+	}
+	for i := range groups {
+		groups[i].CompletedPercent = math.Round(float64(groups[i].Completed)/float64(groups[i].Total)*1000) / 10
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"group_by": groupBy,
+		"data":     groups,
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+// streamFlushEvery is how many todos are written between flushes.
+const streamFlushEvery = 100
+
+// listStreamMode picks how GET /todo/ writes its results: "ndjson" for one
+// todo per line (?stream=ndjson or Accept: application/x-ndjson), "json" for
+// the usual envelope written as the cursor yields (?stream=json), or "" to
+// buffer the whole list.
+func listStreamMode(r *http.Request) string {
+	switch r.URL.Query().Get("stream") {
+	case "ndjson":
+		return "ndjson"
+	case "json", "true":
+		return "json"
+	}
+	if mediaType, _, _ := strings.Cut(r.Header.Get("Accept"), ";"); strings.TrimSpace(mediaType) == "application/x-ndjson" {
+		return "ndjson"
+	}
+	return ""
+}
+
+// streamTodos writes the cursor out without holding the list in memory.
+// Once the first byte is written errors can no longer change the status, so
+// they are only logged and the response is cut short.
+func streamTodos(ctx context.Context, w http.ResponseWriter, cur *mongo.Cursor, mode string, present func(todoModel) interface{}, links map[string]link) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("list: %s\n", err)
+	}
+	var err error
+	if mode == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = writeNDJSON(ctx, w, cur, present)
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = writeJSONList(ctx, w, cur, present, links)
+	}
+	if err != nil {
+		log.Printf("list: %s\n", err)
+	}
+}
+
+// writeNDJSON writes one JSON document per line as the cursor yields them,
+// flushing periodically so memory stays bounded regardless of collection size.
+func writeNDJSON(ctx context.Context, w http.ResponseWriter, cur *mongo.Cursor, present func(todoModel) interface{}) error {
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n := 0
+	for cur.Next(ctx) {
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		if err := enc.Encode(present(t)); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	return cur.Err()
+}
+
+// writeJSONList writes {"data": [...], "_links": {...}} one todo at a time.
+func writeJSONList(ctx context.Context, w http.ResponseWriter, cur *mongo.Cursor, present func(todoModel) interface{}, links map[string]link) error {
+	flusher, _ := w.(http.Flusher)
+	if _, err := w.Write([]byte(`{"data":[`)); err != nil {
+		return err
+	}
+	n := 0
+	for cur.Next(ctx) {
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		item, err := json.Marshal(present(t))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			item = append([]byte{','}, item...)
+		}
+		if _, err := w.Write(item); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	tail, err := json.Marshal(links)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(append([]byte(`],"_links":`), tail...), '}'))
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	tagModelTTL        = 5 * time.Minute
+	tagModelSample     = 5000
+	maxSuggestions     = 5
+	minSuggestionScore = 0.2
+)
+
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "from": true, "this": true,
+	"that": true, "into": true, "about": true, "some": true, "have": true, "get": true,
+}
+
+// tagModel counts, for every title word, how often todos containing it were
+// tagged or filed into each list. It is rebuilt from recent todos every few
+// minutes.
+type tagModel struct {
+	words map[string]int
+	tags  map[string]map[string]int
+	lists map[string]map[string]int
+	built time.Time
+}
+
+var (
+	tagModelMu     sync.Mutex
+	cachedTagModel *tagModel
+)
+
+func titleWords(title string) []string {
+	seen := map[string]bool{}
+	words := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) < 3 || stopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+func loadTagModel(ctx context.Context) (*tagModel, error) {
+	tagModelMu.Lock()
+	defer tagModelMu.Unlock()
+	if cachedTagModel != nil && time.Since(cachedTagModel.built) < tagModelTTL {
+		return cachedTagModel, nil
+	}
+	cur, err := collection.Find(ctx,
+		bson.M{"$or": bson.A{bson.M{"tags.0": bson.M{"$exists": true}}, bson.M{"list": bson.M{"$nin": bson.A{nil, ""}}}}},
+		options.Find().SetSort(bson.M{"createdat": -1}).SetLimit(tagModelSample).
+			SetProjection(bson.M{"title": 1, "tags": 1, "list": 1}))
+	if err != nil {
+		return nil, err
+	}
+	todos := []todoModel{}
+	if err := cur.All(ctx, &todos); err != nil {
+		return nil, err
+	}
+	m := &tagModel{
+		words: map[string]int{},
+		tags:  map[string]map[string]int{},
+		lists: map[string]map[string]int{},
+		built: time.Now(),
+	}
+	for _, t := range todos {
+		for _, word := range titleWords(t.Title) {
+			m.words[word]++
+			for _, tag := range t.Tags {
+				if m.tags[word] == nil {
+					m.tags[word] = map[string]int{}
+				}
+				m.tags[word][tag]++
+			}
+			if t.List != "" {
+				if m.lists[word] == nil {
+					m.lists[word] = map[string]int{}
+				}
+				m.lists[word][t.List]++
+			}
+		}
+	}
+	cachedTagModel = m
+	return m, nil
+}
+
+type suggestion struct {
+	Value string  `json:"value"`
+	Score float64 `json:"score"`
+}
+
+// rank averages, over the title's known words, the share of todos with that
+// word that carry each value.
+func (m *tagModel) rank(words []string, counts map[string]map[string]int) []suggestion {
+	scores := map[string]float64{}
+	known := 0
+	for _, word := range words {
+		if m.words[word] == 0 {
+			continue
+		}
+		known++
+		for value, n := range counts[word] {
+			scores[value] += float64(n) / float64(m.words[word])
+		}
+	}
+	ranked := []suggestion{}
+	for value, score := range scores {
+		if score /= float64(known); score >= minSuggestionScore {
+			ranked = append(ranked, suggestion{Value: value, Score: score})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Value < ranked[j].Value
+	})
+	if len(ranked) > maxSuggestions {
+		ranked = ranked[:maxSuggestions]
+	}
+	return ranked
+}
+
+// suggestTags proposes tags and a list for ?title= based on how earlier todos
+// with similar words were organised.
+func suggestTags(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	words := titleWords(r.URL.Query().Get("title"))
+	if len(words) == 0 {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "title is required",
+		})
+		return
+	}
+	m, err := loadTagModel(ctx)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to build suggestions",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"tags":  m.rank(words, m.tags),
+		"lists": m.rank(words, m.lists),
+	})
+}
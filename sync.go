@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Offline clients sync incrementally with GET /sync?since=<token>: the
+// response holds the todos changed since the token, the IDs of todos deleted
+// since then and the token for the next sync. Without a token every todo is
+// returned. Changes are found by updatedat, which every write of a client
+// visible field sets, and deletions are remembered as tombstones for
+// syncRetention. Tokens older than that, or older than the last restore,
+// get 410 and the client has to start over without a token.
+const (
+	tombstonesCollectionName = "tombstones"
+	syncStateID              = "sync"
+	syncRetention            = 30 * 24 * time.Hour
+	// syncOverlap is subtracted from the next token to cover writes that
+	// were in flight during the sync and small clock differences between
+	// instances. Clients may see a change twice, which is harmless.
+	syncOverlap = 5 * time.Second
+)
+
+var tombstonesCollection *mongo.Collection
+
+var tombstoneIndexes = []mongo.IndexModel{{
+	Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+	Options: options.Index().SetExpireAfterSeconds(int32(syncRetention.Seconds())),
+}}
+
+type tombstone struct {
+	ID        todoID    `bson:"_id"`
+	DeletedAt time.Time `bson:"deleted_at"`
+}
+
+var errSyncTokenExpired = errors.New("the sync token has expired, sync again without since")
+
+// syncState is stored in the settings collection.
+type syncState struct {
+	ResetAt        time.Time `bson:"reset_at,omitempty" json:"-"`
+	ConflictPolicy string    `bson:"conflict_policy,omitempty" json:"conflict_policy"`
+}
+
+func loadSyncState(ctx context.Context) (syncState, error) {
+	s := syncState{ConflictPolicy: policyFieldMerge}
+	err := settingsCollection.FindOne(ctx, bson.M{"_id": syncStateID}).Decode(&s)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return s, err
+	}
+	return s, nil
+}
+
+// deleteTodoData removes the time entries and pomodoros of a deleted todo
+// and records its deletion for sync.
+func deleteTodoData(ctx context.Context, id todoID) error {
+	for _, c := range []*mongo.Collection{timeEntriesCollection, pomodorosCollection} {
+		if _, err := c.DeleteMany(ctx, bson.M{"todo_id": id}); err != nil {
+			return err
+		}
+	}
+	return recordDeletion(ctx, id)
+}
+
+// recordDeletion leaves a tombstone for a deleted todo.
+func recordDeletion(ctx context.Context, id todoID) error {
+	_, err := tombstonesCollection.ReplaceOne(ctx, bson.M{"_id": id},
+		tombstone{ID: id, DeletedAt: time.Now()}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// resetSync expires every sync token, for when the data was replaced
+// wholesale and the tombstones no longer tell what is gone.
+func resetSync(ctx context.Context) error {
+	_, err := settingsCollection.UpdateOne(ctx, bson.M{"_id": syncStateID},
+		bson.M{"$set": bson.M{"reset_at": time.Now()}}, options.Update().SetUpsert(true))
+	return err
+}
+
+func formatSyncToken(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 36)
+}
+
+// parseSyncToken returns the time a token was issued for.
+func parseSyncToken(ctx context.Context, token string, now time.Time) (time.Time, error) {
+	ms, err := strconv.ParseInt(token, 36, 64)
+	if err != nil {
+		return time.Time{}, errors.New("invalid sync token")
+	}
+	since := time.UnixMilli(ms)
+	if since.Before(now.Add(-syncRetention)) {
+		return since, errSyncTokenExpired
+	}
+	state, err := loadSyncState(ctx)
+	if err != nil {
+		return since, err
+	}
+	if since.Before(state.ResetAt) {
+		return since, errSyncTokenExpired
+	}
+	return since, nil
+}
+
+func syncTodos(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	now := time.Now()
+	filter := bson.M{}
+	var since time.Time
+	if token := r.URL.Query().Get("since"); token != "" {
+		var err error
+		since, err = parseSyncToken(ctx, token, now)
+		switch {
+		case errors.Is(err, errSyncTokenExpired):
+			rnd.JSON(w, http.StatusGone, renderer.M{
+				"message": "Sync token expired",
+				"error":   err.Error(),
+			})
+			return
+		case err != nil:
+			rnd.JSON(w, http.StatusBadRequest, renderer.M{
+				"message": "Invalid sync token",
+				"error":   err.Error(),
+			})
+			return
+		}
+		filter["updatedat"] = bson.M{"$gte": since}
+	}
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"updatedat": 1}))
+	todos := []todoModel{}
+	if err == nil {
+		err = cur.All(ctx, &todos)
+	}
+	deleted := []string{}
+	if err == nil && !since.IsZero() {
+		deleted, err = deletedSince(ctx, since, todos)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Sync failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	changed := make([]todo, len(todos))
+	for i, t := range todos {
+		changed[i] = newTodo(t)
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":       changed,
+		"deleted":    deleted,
+		"sync_token": formatSyncToken(now.Add(-syncOverlap)),
+	})
+}
+
+// deletedSince returns the IDs of todos deleted since the given time, leaving
+// out any that exist again.
+func deletedSince(ctx context.Context, since time.Time, changed []todoModel) ([]string, error) {
+	cur, err := tombstonesCollection.Find(ctx, bson.M{"deleted_at": bson.M{"$gte": since}})
+	tombstones := []tombstone{}
+	if err == nil {
+		err = cur.All(ctx, &tombstones)
+	}
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, t := range changed {
+		present[t.ID.Hex()] = true
+	}
+	deleted := []string{}
+	for _, t := range tombstones {
+		if !present[t.ID.Hex()] {
+			deleted = append(deleted, t.ID.Hex())
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Offline clients send the edits they made while offline with POST /sync.
+// Each mutation names the updated_at the client last saw as base_version.
+// When the todo has not changed since, the mutation is applied. Otherwise
+// the conflict policy decides:
+//
+//   - field_merge applies the changed fields the server left alone, judged
+//     by the values in base, and returns the others as conflicts for the
+//     client to resolve.
+//   - last_writer_wins applies the whole mutation when its modified_at is
+//     later than the server's change and drops it otherwise.
+//
+// Todos are created with POST /todo/ as usual, with a UUID the client chose
+// as _id.
+const (
+	policyFieldMerge     = "field_merge"
+	policyLastWriterWins = "last_writer_wins"
+
+	maxSyncMutations = 500
+	// syncAttempts bounds how often a mutation is retried when the todo
+	// changes between reading and writing it.
+	syncAttempts = 3
+)
+
+type syncMutation struct {
+	Op          string                     `json:"op"`
+	ID          string                     `json:"id"`
+	BaseVersion *time.Time                 `json:"base_version"`
+	ModifiedAt  *time.Time                 `json:"modified_at"`
+	Changes     map[string]json.RawMessage `json:"changes"`
+	Base        map[string]json.RawMessage `json:"base"`
+}
+
+type syncConflict struct {
+	Field  string          `json:"field"`
+	Base   json.RawMessage `json:"base,omitempty"`
+	Server json.RawMessage `json:"server"`
+	Client json.RawMessage `json:"client"`
+}
+
+// syncResult reports a mutation. Status is applied, merged (some fields
+// conflicted), conflict, superseded (last_writer_wins kept the server's
+// change), not_found or invalid.
+type syncResult struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status"`
+	Conflicts []syncConflict `json:"conflicts,omitempty"`
+	Todo      *todo          `json:"todo,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// syncFields are the fields mutations can change.
+var syncFields = map[string]bool{
+	"title": true, "status": true, "list": true, "tags": true, "checklist": true,
+	"due_at": true, "reminder_at": true, "color": true, "starred": true, "pinned": true,
+}
+
+func (s syncState) check() error {
+	if s.ConflictPolicy != policyFieldMerge && s.ConflictPolicy != policyLastWriterWins {
+		return fmt.Errorf("conflict_policy must be %s or %s", policyFieldMerge, policyLastWriterWins)
+	}
+	return nil
+}
+
+func applySyncMutations(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var body struct {
+		Mutations []syncMutation `json:"mutations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if len(body.Mutations) > maxSyncMutations {
+		rnd.JSON(w, http.StatusRequestEntityTooLarge, renderer.M{
+			"message": fmt.Sprintf("At most %d mutations can be sent at once", maxSyncMutations),
+		})
+		return
+	}
+	state, err := loadSyncState(ctx)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Sync failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	results := make([]syncResult, len(body.Mutations))
+	for i, m := range body.Mutations {
+		results[i], err = applySyncMutation(ctx, m, state.ConflictPolicy, cascadeRequested(r))
+		if err != nil {
+			rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+				"message": "Sync failed",
+				"error":   err.Error(),
+				"data":    results[:i],
+			})
+			return
+		}
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": results,
+	})
+}
+
+// applySyncMutation applies m, retrying when the todo changes in between.
+// Invalid mutations are reported in the result; err is for failed writes.
+// cascade completes the checklist of todos moved to a done status.
+func applySyncMutation(ctx context.Context, m syncMutation, policy string, cascade bool) (syncResult, error) {
+	res := syncResult{ID: m.ID}
+	id, err := parseTodoID(m.ID)
+	if err == nil && m.Op != "update" && m.Op != "delete" {
+		err = fmt.Errorf("unknown op %q", m.Op)
+	}
+	for field := range m.Changes {
+		if err == nil && !syncFields[field] {
+			err = fmt.Errorf("%s cannot be synced", field)
+		}
+	}
+	if err != nil {
+		res.Status, res.Error = "invalid", err.Error()
+		return res, nil
+	}
+	for attempt := 0; attempt < syncAttempts; attempt++ {
+		var current todoModel
+		err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			res.Status = "not_found"
+			if m.Op == "delete" {
+				res.Status = "applied"
+			}
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		var done bool
+		if m.Op == "delete" {
+			done, err = syncDelete(ctx, m, current, policy, &res)
+		} else {
+			done, err = syncUpdate(ctx, m, current, policy, cascade, &res)
+		}
+		if err != nil || done {
+			return res, err
+		}
+	}
+	res.Status, res.Error = "conflict", "the todo kept changing, sync and try again"
+	return res, nil
+}
+
+// serverChanged reports whether the todo changed after the client's base
+// version. Mutations without one are treated as stale.
+func serverChanged(m syncMutation, current todoModel) bool {
+	return m.BaseVersion == nil || current.UpdatedAt.After(*m.BaseVersion)
+}
+
+// clientIsLater reports whether the client's edit came after the server's
+// last change, which decides under last_writer_wins.
+func clientIsLater(m syncMutation, current todoModel) bool {
+	return m.ModifiedAt != nil && m.ModifiedAt.After(current.UpdatedAt)
+}
+
+func syncDelete(ctx context.Context, m syncMutation, current todoModel, policy string, res *syncResult) (bool, error) {
+	if serverChanged(m, current) && !(policy == policyLastWriterWins && clientIsLater(m, current)) {
+		t := newTodo(current)
+		res.Status, res.Todo = "superseded", &t
+		if policy == policyFieldMerge {
+			res.Status, res.Error = "conflict", "the todo changed after the client's version"
+		}
+		return true, nil
+	}
+	deleted, err := collection.DeleteOne(ctx, bson.M{"_id": current.ID, "updatedat": current.UpdatedAt})
+	if err != nil || deleted.DeletedCount == 0 {
+		return false, err
+	}
+	if err := deleteTodoData(ctx, current.ID); err != nil {
+		return false, err
+	}
+	go blockerChanged(current.ID, true)
+	res.Status = "applied"
+	return true, nil
+}
+
+func syncUpdate(ctx context.Context, m syncMutation, current todoModel, policy string, cascade bool, res *syncResult) (bool, error) {
+	changes := m.Changes
+	res.Conflicts = nil
+	if serverChanged(m, current) {
+		switch {
+		case policy == policyLastWriterWins && !clientIsLater(m, current):
+			t := newTodo(current)
+			res.Status, res.Todo = "superseded", &t
+			return true, nil
+		case policy == policyFieldMerge:
+			changes, res.Conflicts = mergeChanges(current, m)
+		}
+	}
+	if len(changes) > 0 {
+		set, unset, err := syncUpdateFields(current, changes, cascade)
+		if err != nil {
+			res.Status, res.Error = "invalid", err.Error()
+			return true, nil
+		}
+		set["updatedat"] = time.Now()
+		update := bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		updated, err := collection.UpdateOne(ctx, bson.M{"_id": current.ID, "updatedat": current.UpdatedAt}, update)
+		if err != nil || updated.MatchedCount == 0 {
+			return false, err
+		}
+		if _, ok := changes["status"]; ok {
+			go blockerChanged(current.ID, false)
+		}
+		go todoUpdated(current)
+	}
+	var after todoModel
+	if err := collection.FindOne(ctx, bson.M{"_id": current.ID}).Decode(&after); err != nil {
+		return true, err
+	}
+	t := newTodo(after)
+	res.Todo = &t
+	switch {
+	case len(res.Conflicts) == 0:
+		res.Status = "applied"
+	case len(changes) > 0:
+		res.Status = "merged"
+	default:
+		res.Status = "conflict"
+	}
+	return true, nil
+}
+
+// mergeChanges keeps the changes to fields the server still has at their
+// base value. Fields the server changed to something else are conflicts.
+func mergeChanges(current todoModel, m syncMutation) (map[string]json.RawMessage, []syncConflict) {
+	server := map[string]json.RawMessage{}
+	raw, _ := json.Marshal(newTodo(current))
+	json.Unmarshal(raw, &server)
+	changes := map[string]json.RawMessage{}
+	conflicts := []syncConflict{}
+	for field, value := range m.Changes {
+		base, hasBase := m.Base[field]
+		switch {
+		case sameSyncValue(server[field], value):
+			// Both sides made the same change.
+		case hasBase && sameSyncValue(server[field], base):
+			changes[field] = value
+		default:
+			conflicts = append(conflicts, syncConflict{Field: field, Base: base, Server: orNull(server[field]), Client: value})
+		}
+	}
+	return changes, conflicts
+}
+
+func orNull(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	return v
+}
+
+// sameSyncValue compares two JSON values, treating missing, null and empty
+// values alike and comparing times as instants.
+func sameSyncValue(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	json.Unmarshal(a, &va)
+	json.Unmarshal(b, &vb)
+	if sa, ok := va.(string); ok {
+		if sb, ok := vb.(string); ok {
+			ta, errA := time.Parse(time.RFC3339Nano, sa)
+			tb, errB := time.Parse(time.RFC3339Nano, sb)
+			if errA == nil && errB == nil {
+				return ta.Equal(tb)
+			}
+		}
+	}
+	return reflect.DeepEqual(emptyToNil(va), emptyToNil(vb))
+}
+
+func emptyToNil(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return v
+}
+
+// syncUpdateFields validates changes and turns them into the $set and $unset
+// of the update.
+func syncUpdateFields(current todoModel, changes map[string]json.RawMessage, cascade bool) (bson.M, bson.M, error) {
+	set, unset := bson.M{}, bson.M{}
+	var status string
+	var tags []string
+	var checklist []checklistItem
+	for field, raw := range changes {
+		var err error
+		switch field {
+		case "title":
+			var title string
+			if err = json.Unmarshal(raw, &title); err == nil && strings.TrimSpace(title) == "" {
+				err = errors.New("title is required")
+			}
+			if err == nil {
+				err = checkTodoContent(title, nil, nil)
+			}
+			if err == nil {
+				set["title"], err = sealTitle(current.ID, title)
+				set["title_key"], set["title_grams"] = storedTitleKey(title), titleGrams(title)
+			}
+		case "status":
+			if err = json.Unmarshal(raw, &status); err != nil {
+				break
+			}
+			if workflow.status(status) == nil {
+				err = fmt.Errorf("unknown status %q", status)
+			} else if !workflow.canMove(statusOf(current), status) {
+				err = fmt.Errorf("cannot move from %s to %s", statusOf(current), status)
+			}
+		case "list", "color":
+			var value string
+			if err = json.Unmarshal(raw, &value); err != nil {
+				break
+			}
+			value = strings.TrimSpace(value)
+			if field == "color" {
+				color, ok := normalizeColor(value)
+				if !ok {
+					err = fmt.Errorf("invalid color %q", value)
+					break
+				}
+				value = color
+			}
+			if value == "" {
+				unset[field] = ""
+			} else {
+				set[field] = value
+			}
+		case "tags":
+			if err = json.Unmarshal(raw, &tags); err == nil {
+				err = checkTodoContent("", tags, nil)
+				set[field] = tags
+			}
+		case "checklist":
+			if err = json.Unmarshal(raw, &checklist); err == nil {
+				err = checkTodoContent("", nil, checklist)
+				set[field] = checklist
+			}
+		case "due_at", "reminder_at":
+			var at *time.Time
+			if err = json.Unmarshal(raw, &at); err == nil {
+				if at == nil {
+					unset[field] = ""
+				} else {
+					set[field] = *at
+				}
+			}
+		case "starred", "pinned":
+			var flag bool
+			if err = json.Unmarshal(raw, &flag); err == nil {
+				set[field] = flag
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", field, err)
+		}
+	}
+	if status != "" {
+		// A checklist sent along is completed in place, before it is set.
+		fields, _ := completionUpdate(status, time.Now(), cascade, current.Checklist, checklist)
+		for _, e := range fields {
+			set[e.Key] = e.Value
+		}
+	}
+	return set, unset, nil
+}
+
+func getSyncSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := loadSyncState(ctx)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch sync settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": s,
+	})
+}
+
+func updateSyncSettings(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var s syncState
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := s.check(); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Invalid sync settings",
+			"error":   err.Error(),
+		})
+		return
+	}
+	_, err := settingsCollection.UpdateOne(ctx, bson.M{"_id": syncStateID},
+		bson.M{"$set": bson.M{"conflict_policy": s.ConflictPolicy}}, options.Update().SetUpsert(true))
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Settings update failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Settings update successful",
+		"data":    s,
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Telemetry is off unless TODO_TELEMETRY_URL is set. The leader then posts a
+// report to it every TODO_TELEMETRY_INTERVAL: a random ID for the
+// deployment, the build, which integrations are configured and how many
+// todos, lists and rules there are. No titles, names or other content leave
+// the server, and every report is logged in full before it is sent.
+const (
+	telemetryStateID      = "telemetry"
+	telemetryPollInterval = time.Hour
+)
+
+var telemetrySettings = struct {
+	url      string
+	interval string
+}{
+	url:      env("TODO_TELEMETRY_URL", ""),
+	interval: env("TODO_TELEMETRY_INTERVAL", "24h"),
+}
+
+// telemetryState is stored in the settings collection.
+type telemetryState struct {
+	InstanceID string     `bson:"instance_id"`
+	SentAt     *time.Time `bson:"sent_at,omitempty"`
+}
+
+type telemetryReport struct {
+	InstanceID string          `json:"instance_id"`
+	Version    string          `json:"version"`
+	GoVersion  string          `json:"go_version"`
+	Platform   string          `json:"platform"`
+	Features   map[string]bool `json:"features"`
+	Counts     map[string]int  `json:"counts"`
+	SentAt     time.Time       `json:"sent_at"`
+}
+
+// telemetryScheduler sends a report whenever the last one is older than
+// the interval, until ctx is done.
+func telemetryScheduler(ctx context.Context) {
+	if telemetrySettings.url == "" {
+		return
+	}
+	interval, err := time.ParseDuration(telemetrySettings.interval)
+	if err != nil || interval <= 0 {
+		log.Printf("telemetry: invalid TODO_TELEMETRY_INTERVAL %q\n", telemetrySettings.interval)
+		return
+	}
+	log.Printf("telemetry: on, reports go to %s every %s\n", telemetrySettings.url, interval)
+	ticker := time.NewTicker(telemetryPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := sendDueTelemetry(ctx, interval, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("telemetry: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendDueTelemetry(ctx context.Context, interval time.Duration, now time.Time) error {
+	state, err := loadTelemetryState(ctx)
+	if err != nil {
+		return err
+	}
+	if state.SentAt != nil && now.Before(state.SentAt.Add(interval)) {
+		return nil
+	}
+	report, err := buildTelemetryReport(ctx, state.InstanceID, now)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	log.Printf("telemetry: sending %s\n", body)
+	if err := postTelemetry(ctx, body); err != nil {
+		return err
+	}
+	_, err = settingsCollection.UpdateOne(ctx, bson.M{"_id": telemetryStateID},
+		bson.M{"$set": bson.M{"sent_at": now}})
+	return err
+}
+
+// loadTelemetryState returns the stored state, creating the instance ID on
+// first use. The ID is random and only tells reports of one deployment
+// apart.
+func loadTelemetryState(ctx context.Context) (telemetryState, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return telemetryState{}, err
+	}
+	var state telemetryState
+	err := settingsCollection.FindOneAndUpdate(ctx, bson.M{"_id": telemetryStateID},
+		bson.M{"$setOnInsert": bson.M{"instance_id": hex.EncodeToString(id)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&state)
+	return state, err
+}
+
+func buildTelemetryReport(ctx context.Context, instanceID string, now time.Time) (telemetryReport, error) {
+	report := telemetryReport{
+		InstanceID: instanceID,
+		Version:    buildVersion(),
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Features: map[string]bool{
+			"email":   emailEnabled(),
+			"fcm":     fcm.enabled(),
+			"apns":    apns.enabled(),
+			"llm":     llm.URL != "",
+			"backups": backupSettings.interval != "",
+			"admin":   adminToken != "",
+		},
+		Counts: map[string]int{},
+		SentAt: now,
+	}
+	counts := []struct {
+		name       string
+		collection *mongo.Collection
+		filter     bson.M
+	}{
+		{"todos", collection, bson.M{}},
+		{"completed", collection, bson.M{"iscompleted": true}},
+		{"archived", collection, bson.M{"archived": true}},
+		{"lists", listsCollection, bson.M{}},
+		{"filters", filtersCollection, bson.M{}},
+		{"automations", automationsCollection, bson.M{}},
+		{"rules", rulesCollection, bson.M{}},
+		{"devices", devicesCollection, bson.M{}},
+	}
+	for _, c := range counts {
+		n, err := c.collection.CountDocuments(ctx, c.filter)
+		if err != nil {
+			return report, fmt.Errorf("counting %s: %w", c.name, err)
+		}
+		report.Counts[c.name] = int(n)
+	}
+	return report, nil
+}
+
+// buildVersion returns the module version, or the VCS revision for builds
+// from a checkout.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && (version == "" || version == "(devel)") {
+			version = s.Value
+		}
+	}
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+func postTelemetry(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telemetrySettings.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return errors.New("report refused with " + res.Status)
+	}
+	return nil
+}
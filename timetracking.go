@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	timeEntriesCollectionName = "time_entries"
+	maxTimeReportDays         = 366
+)
+
+var timeEntriesCollection *mongo.Collection
+
+// timeEntry is a span of work on a todo. A running timer is an entry without
+// an end. Seconds is stored when the entry ends so reports can sum it, and
+// the todo's tracked_seconds is kept as the total of its finished entries.
+// Running is set until then, for the index allowing one running timer per
+// todo.
+type timeEntry struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	TodoID    todoID             `bson:"todo_id" json:"todo_id"`
+	Begin     time.Time          `bson:"begin" json:"begin"`
+	End       *time.Time         `bson:"end,omitempty" json:"end,omitempty"`
+	Seconds   int64              `bson:"seconds" json:"seconds"`
+	Note      string             `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	Running   bool               `bson:"running,omitempty" json:"-"`
+}
+
+func timeEntryHandlers() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", listTimeEntries)
+	r.Post("/", createTimeEntry)
+	r.Delete("/{entryID}", deleteTimeEntry)
+	return r
+}
+
+// todoIDParam reads the {id} URL parameter and checks the todo exists,
+// writing the error response itself when it does not.
+func todoIDParam(ctx context.Context, w http.ResponseWriter, r *http.Request) (todoID, bool) {
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err.Error(),
+		}))
+		return id, false
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err(); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			status = http.StatusNotFound
+		}
+		rnd.JSON(w, status, withMessage(r, "todo_not_found", nil))
+		return id, false
+	}
+	return id, true
+}
+
+func addTrackedTime(ctx context.Context, id todoID, seconds int64) error {
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$inc": bson.M{"tracked_seconds": seconds},
+		"$set": bson.M{"updatedat": time.Now()},
+	})
+	return err
+}
+
+func startTimer(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	// The note is optional, so an empty or missing body is fine.
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	now := time.Now()
+	e := timeEntry{
+		ID:        primitive.NewObjectID(),
+		TodoID:    id,
+		Begin:     now,
+		Note:      body.Note,
+		CreatedAt: now,
+		Running:   true,
+	}
+	// The upsert only inserts when no timer is running for this todo. Two
+	// racing upserts may both insert; the index refuses the second.
+	res, err := timeEntriesCollection.UpdateOne(ctx,
+		bson.M{"todo_id": id, "end": bson.M{"$exists": false}},
+		bson.M{"$setOnInsert": e}, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to start the timer",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err != nil || res.UpsertedCount == 0 {
+		rnd.JSON(w, http.StatusConflict, renderer.M{
+			"message": "A timer is already running for this todo",
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Timer started",
+		"data":    e,
+	})
+}
+
+func stopTimer(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	var running timeEntry
+	err := timeEntriesCollection.FindOne(ctx, bson.M{"todo_id": id, "end": bson.M{"$exists": false}}).Decode(&running)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusConflict, renderer.M{
+			"message": "No timer is running for this todo",
+		})
+		return
+	}
+	if err == nil {
+		now := time.Now()
+		running.End = &now
+		running.Seconds = int64(now.Sub(running.Begin).Seconds())
+		var res *mongo.UpdateResult
+		res, err = timeEntriesCollection.UpdateOne(ctx,
+			bson.M{"_id": running.ID, "end": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"end": now, "seconds": running.Seconds}, "$unset": bson.M{"running": ""}})
+		// Only the request that actually stopped the timer adds its time.
+		if err == nil && res.ModifiedCount == 1 {
+			err = addTrackedTime(ctx, id, running.Seconds)
+		}
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to stop the timer",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Timer stopped",
+		"data":    running,
+	})
+}
+
+func listTimeEntries(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	cur, err := timeEntriesCollection.Find(ctx, bson.M{"todo_id": id}, options.Find().SetSort(bson.M{"begin": 1}))
+	entries := []timeEntry{}
+	if err == nil {
+		err = cur.All(ctx, &entries)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to fetch time entries",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": entries,
+	})
+}
+
+// createTimeEntry records time worked without a timer, e.g. afterwards.
+func createTimeEntry(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	var e timeEntry
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if e.Begin.IsZero() || e.End == nil || !e.End.After(e.Begin) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "begin and end are required and end must be after begin",
+		})
+		return
+	}
+	e.ID = primitive.NewObjectID()
+	e.TodoID = id
+	e.Seconds = int64(e.End.Sub(e.Begin).Seconds())
+	e.CreatedAt = time.Now()
+	_, err := timeEntriesCollection.InsertOne(ctx, e)
+	if err == nil {
+		err = addTrackedTime(ctx, id, e.Seconds)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Time entry creation failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Time entry creation successful",
+		"data":    e,
+	})
+}
+
+func deleteTimeEntry(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, ok := todoIDParam(ctx, w, r)
+	if !ok {
+		return
+	}
+	entryID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "entryID"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error Parsing your request",
+			"error":   err.Error(),
+		})
+		return
+	}
+	var e timeEntry
+	err = timeEntriesCollection.FindOneAndDelete(ctx, bson.M{"_id": entryID, "todo_id": id}).Decode(&e)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusNotFound, renderer.M{
+			"message": "Time entry not found",
+		})
+		return
+	}
+	if err == nil && e.End != nil {
+		err = addTrackedTime(ctx, id, -e.Seconds)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Error deleting the time entry",
+			"error":   err.Error(),
+		})
+		return
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":  "Time entry deletion successful",
+		"entry_id": entryID.Hex(),
+	})
+}
+
+// timeReport sums finished entries per day between ?from= and ?to=
+// (inclusive YYYY-MM-DD dates in the ?tz= time zone). Entries count towards
+// the day they began.
+func timeReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	loc, err := requestLocation(r)
+	if err != nil {
+		locationError(w, err)
+		return
+	}
+	tz := loc.String()
+	from, fromErr := time.ParseInLocation(analyticsDayFormat, q.Get("from"), loc)
+	to, toErr := time.ParseInLocation(analyticsDayFormat, q.Get("to"), loc)
+	if fromErr != nil || toErr != nil || to.Before(from) {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "from and to must be YYYY-MM-DD dates with from <= to",
+		})
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+	if to.Sub(from) > maxTimeReportDays*24*time.Hour {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "The report range is limited to one year",
+		})
+		return
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cur, err := timeEntriesCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"begin": bson.M{"$gte": from, "$lt": to}, "end": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$begin", "timezone": tz}},
+			"seconds": bson.M{"$sum": "$seconds"},
+			"entries": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	days := []struct {
+		Date    string `bson:"_id" json:"date"`
+		Seconds int64  `bson:"seconds" json:"seconds"`
+		Entries int    `bson:"entries" json:"entries"`
+	}{}
+	if err == nil {
+		err = cur.All(ctx, &days)
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Failed to build the time report",
+			"error":   err.Error(),
+		})
+		return
+	}
+	var total int64
+	for _, d := range days {
+		total += d.Seconds
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":          days,
+		"total_seconds": total,
+		"tz":            tz,
+	})
+}
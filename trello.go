@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+type (
+	trelloBoard struct {
+		Name       string            `json:"name"`
+		Lists      []trelloList      `json:"lists"`
+		Cards      []trelloCard      `json:"cards"`
+		Checklists []trelloChecklist `json:"checklists"`
+	}
+	trelloList struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Closed bool   `json:"closed"`
+	}
+	trelloCard struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Closed      bool   `json:"closed"`
+		DueComplete bool   `json:"dueComplete"`
+		IDList      string `json:"idList"`
+		Labels      []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"labels"`
+		Attachments []json.RawMessage `json:"attachments"`
+	}
+	trelloChecklist struct {
+		IDCard     string `json:"idCard"`
+		CheckItems []struct {
+			Name  string `json:"name"`
+			State string `json:"state"`
+		} `json:"checkItems"`
+	}
+)
+
+// importTrello creates todos from a Trello board JSON export. Every open card
+// becomes a todo in a list named after its Trello list, labels become tags and
+// checklists are flattened into the todo's checklist. Archived cards and cards
+// in archived lists are ignored. Attachments are skipped and counted:
+// ?attachments=download answers 501, since the server has nowhere to keep
+// files.
+func importTrello(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var board trelloBoard
+	if err := json.NewDecoder(r.Body).Decode(&board); err != nil {
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "Error parsing Trello export",
+			"error":   err.Error(),
+		})
+		return
+	}
+	switch r.URL.Query().Get("attachments") {
+	case "", "skip":
+	case "download":
+		rnd.JSON(w, http.StatusNotImplemented, renderer.M{
+			"message": "Downloading attachments is not supported, there is no attachment storage; use attachments=skip",
+		})
+		return
+	default:
+		rnd.JSON(w, http.StatusBadRequest, renderer.M{
+			"message": "attachments must be skip or download",
+		})
+		return
+	}
+
+	lists := map[string]trelloList{}
+	for _, l := range board.Lists {
+		lists[l.ID] = l
+	}
+	checklists := map[string][]checklistItem{}
+	for _, c := range board.Checklists {
+		for _, item := range c.CheckItems {
+			checklists[c.IDCard] = append(checklists[c.IDCard], checklistItem{
+				Title:       item.Name,
+				IsCompleted: item.State == "complete",
+			})
+		}
+	}
+
+	docs := []interface{}{}
+	created := []todoModel{}
+	ids := []string{}
+	skippedAttachments := 0
+	for _, card := range board.Cards {
+		list := lists[card.IDList]
+		title := strings.TrimSpace(card.Name)
+		if card.Closed || list.Closed || title == "" {
+			continue
+		}
+		tags := []string{}
+		for _, label := range card.Labels {
+			name := label.Name
+			if name == "" {
+				name = label.Color
+			}
+			if name != "" {
+				tags = append(tags, name)
+			}
+		}
+		skippedAttachments += len(card.Attachments)
+		now := time.Now()
+		t := todoModel{
+			ID:          newTodoID(),
+			Title:       title,
+			IsCompleted: card.DueComplete,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			List:        list.Name,
+			Tags:        tags,
+			Checklist:   checklists[card.ID],
+		}
+		if t.IsCompleted {
+			t.CompletedAt = &now
+		}
+		docs = append(docs, t)
+		created = append(created, t)
+		ids = append(ids, t.ID.Hex())
+	}
+	if len(docs) == 0 {
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"message":  "Nothing to import",
+			"imported": 0,
+		})
+		return
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, renderer.M{
+			"message": "Trello import failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	go todosCreated(created...)
+	go backfillTodos()
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message":             "Trello import successful",
+		"board":               board.Name,
+		"imported":            len(ids),
+		"attachments_skipped": skippedAttachments,
+		"todo_ids":            ids,
+	})
+}
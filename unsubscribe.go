@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notification emails carry a link that stops one type of notification
+// being emailed to the recipient, without logging in. The link is
+// TODO_PUBLIC_URL/unsubscribe/{token}, where the token is the address and
+// the type signed with TODO_UNSUBSCRIBE_KEY, and it is also sent in a
+// List-Unsubscribe header for one-click unsubscribing (RFC 8058). Without
+// both settings no link is sent.
+var unsubscribeSettings = struct {
+	publicURL string
+	key       string
+}{
+	publicURL: strings.TrimSuffix(env("TODO_PUBLIC_URL", ""), "/"),
+	key:       mustSecret("TODO_UNSUBSCRIBE_KEY", ""),
+}
+
+// emailOptOutsID is the settings document listing who unsubscribed from
+// what. It is kept apart from the notification settings, which are
+// replaced whole when they are updated.
+const emailOptOutsID = "email_opt_outs"
+
+type emailOptOut struct {
+	Address string `bson:"address" json:"address"`
+	Type    string `bson:"type" json:"type"`
+}
+
+func unsubscribeEnabled() bool {
+	return unsubscribeSettings.publicURL != "" && unsubscribeSettings.key != ""
+}
+
+func unsubscribeMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(unsubscribeSettings.key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// unsubscribeURL returns the link that unsubscribes address from emails
+// about kind, or "" when links are not enabled.
+func unsubscribeURL(address, kind string) string {
+	if !unsubscribeEnabled() {
+		return ""
+	}
+	payload := strings.ToLower(address) + "\n" + kind
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(unsubscribeMAC(payload))
+	return unsubscribeSettings.publicURL + "/unsubscribe/" + token
+}
+
+// openUnsubscribeToken returns the address and type a token was made for.
+func openUnsubscribeToken(token string) (address, kind string, ok bool) {
+	if unsubscribeSettings.key == "" {
+		return "", "", false
+	}
+	encoded, sig, found := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	mac, macErr := base64.RawURLEncoding.DecodeString(sig)
+	if !found || err != nil || macErr != nil || !hmac.Equal(mac, unsubscribeMAC(string(payload))) {
+		return "", "", false
+	}
+	address, kind, found = strings.Cut(string(payload), "\n")
+	if !found || !containsString(notificationTypes, kind) {
+		return "", "", false
+	}
+	return address, kind, true
+}
+
+func loadEmailOptOuts(ctx context.Context) ([]emailOptOut, error) {
+	var doc struct {
+		Entries []emailOptOut `bson:"entries"`
+	}
+	err := settingsCollection.FindOne(ctx, bson.M{"_id": emailOptOutsID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = nil
+	}
+	if doc.Entries == nil {
+		doc.Entries = []emailOptOut{}
+	}
+	return doc.Entries, err
+}
+
+// emailRecipientsFor returns the configured recipients that have not
+// unsubscribed from emails about kind.
+func emailRecipientsFor(ctx context.Context, kind string) ([]string, error) {
+	optOuts, err := loadEmailOptOuts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := map[emailOptOut]bool{}
+	for _, o := range optOuts {
+		out[o] = true
+	}
+	to := []string{}
+	for _, addr := range emailRecipients() {
+		if !out[emailOptOut{strings.ToLower(addr), kind}] {
+			to = append(to, addr)
+		}
+	}
+	return to, nil
+}
+
+// unsubscribe stops emails about the type its token names to the address it
+// names. GET serves the link in the email and POST the one-click request
+// of List-Unsubscribe; both answer with a line of text.
+func unsubscribe(w http.ResponseWriter, r *http.Request) {
+	address, kind, ok := openUnsubscribeToken(chi.URLParam(r, "token"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := settingsCollection.UpdateOne(ctx, bson.M{"_id": emailOptOutsID},
+		bson.M{"$addToSet": bson.M{"entries": emailOptOut{address, kind}}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s will no longer get %s notifications by email.\n", address, kind)
+}
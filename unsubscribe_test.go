@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	saved := unsubscribeSettings
+	t.Cleanup(func() { unsubscribeSettings = saved })
+	unsubscribeSettings.publicURL = "https://todo.example"
+	unsubscribeSettings.key = "secret"
+
+	link := unsubscribeURL("Ann@Example.com", "digest")
+	token, ok := strings.CutPrefix(link, "https://todo.example/unsubscribe/")
+	if !ok {
+		t.Fatalf("unexpected link %q", link)
+	}
+	address, kind, ok := openUnsubscribeToken(token)
+	if !ok || address != "ann@example.com" || kind != "digest" {
+		t.Fatalf("token opened as %q, %q, %v", address, kind, ok)
+	}
+
+	forged := strings.TrimPrefix(unsubscribeURL("bob@example.com", "digest"), "https://todo.example/unsubscribe/")
+	_, sig, _ := strings.Cut(forged, ".")
+	payload, _, _ := strings.Cut(token, ".")
+	if _, _, ok := openUnsubscribeToken(payload + "." + sig); ok {
+		t.Error("a token with another token's signature was accepted")
+	}
+	unsubscribeSettings.key = "other"
+	if _, _, ok := openUnsubscribeToken(token); ok {
+		t.Error("a token signed with another key was accepted")
+	}
+}
+
+func TestRenderEmailUnsubscribeLink(t *testing.T) {
+	link := "https://todo.example/unsubscribe/abc.def"
+	for name, data := range map[string]interface{}{
+		"notification": notification{Message: "Water the plants is overdue", CreatedAt: time.Now()},
+		"digest":       digest{Period: digestDaily, Location: time.UTC},
+	} {
+		m, err := renderEmail(name, []string{"ann@example.com"}, emailData{Data: data, Unsubscribe: link})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !strings.Contains(m.Text, link) || !strings.Contains(m.HTML, link) || m.Unsubscribe != link {
+			t.Errorf("%s: the unsubscribe link is missing", name)
+		}
+		if m.Subject == "" {
+			t.Errorf("%s: the subject is empty", name)
+		}
+		msg, err := buildEmail(m, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(msg), "List-Unsubscribe: <"+link+">\r\n") {
+			t.Errorf("%s: the List-Unsubscribe header is missing", name)
+		}
+	}
+}
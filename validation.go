@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on what a todo holds, checked by checkTodoContent on every path
+// that writes a title, tags or a checklist.
+const (
+	maxTitleLength     = 500
+	maxTags            = 20
+	maxTagLength       = 50
+	maxChecklistItems  = 100
+	maxChecklistLength = 500
+)
+
+// checkTodoContent checks the title, tags and checklist of a todo. An
+// empty title or nil tags and checklist are not checked, so partial
+// updates can pass only what they change; creation checks the title is
+// there itself.
+func checkTodoContent(title string, tags []string, checklist []checklistItem) error {
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("title is longer than %d characters", maxTitleLength)
+	}
+	if len(tags) > maxTags {
+		return fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("tags cannot be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+	}
+	if len(checklist) > maxChecklistItems {
+		return fmt.Errorf("at most %d checklist items are allowed", maxChecklistItems)
+	}
+	for _, item := range checklist {
+		if strings.TrimSpace(item.Title) == "" {
+			return errors.New("checklist items need a title")
+		}
+		if utf8.RuneCountInString(item.Title) > maxChecklistLength {
+			return fmt.Errorf("checklist item %q is longer than %d characters", item.Title, maxChecklistLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+)
+
+// The API is versioned by path: /api/v1/todo, /api/v2/todo and so on. A
+// version never changes incompatibly once released; breaking changes such as
+// a new envelope ship as the next version alongside the old one. Every
+// response names the version that served it in the API-Version header.
+//
+// The unversioned paths the API started with are deprecated aliases frozen at
+// v1. They point clients at their versioned URL with a successor-version link.
+const legacyAPIVersion = "v1"
+
+var apiVersions = map[string]func(chi.Router){
+	"v1": apiRoutes,
+}
+
+func apiPrefix(version string) string {
+	return "/api/" + version
+}
+
+// apiRoutes registers the v1 API.
+func apiRoutes(r chi.Router) {
+	r.Mount("/admin", adminHandlers())
+	r.Group(func(r chi.Router) {
+		r.Use(readOnlyDuringMaintenance)
+		r.Mount("/todo", todoHandlers())
+		r.Mount("/lists", listHandlers())
+		r.Get("/analytics/completions", completionAnalytics)
+		r.Get("/reports/time", timeReport)
+		r.Mount("/pomodoros", pomodoroHandlers())
+		r.Mount("/filters", filterHandlers())
+		r.Mount("/notifications", notificationHandlers())
+		r.Mount("/fields", customFieldHandlers())
+		r.Mount("/jobs", jobHandlers())
+		r.Mount("/operations", operationHandlers())
+		r.Mount("/automations", automationHandlers())
+		r.Mount("/rules", ruleHandlers())
+		r.Mount("/devices", deviceHandlers())
+		r.Get("/sync", syncTodos)
+		r.Post("/sync", applySyncMutations)
+		r.Get("/workflow", getWorkflow)
+		r.Get("/settings", getSettings)
+		r.Put("/settings", updateSettings)
+		r.Get("/settings/notifications", getNotificationPrefs)
+		r.Put("/settings/notifications", updateNotificationPrefs)
+		r.Get("/settings/digest", getDigestSettings)
+		r.Put("/settings/digest", updateDigestSettings)
+		r.Get("/settings/sync", getSyncSettings)
+		r.Put("/settings/sync", updateSyncSettings)
+	})
+}
+
+// mountAPI serves every version under its prefix and the legacy aliases at
+// the root.
+func mountAPI(r chi.Router) {
+	for version, routes := range apiVersions {
+		r.Route(apiPrefix(version), func(r chi.Router) {
+			r.Use(versionHeader(version))
+			routes(r)
+		})
+	}
+	r.NotFound(unsupportedVersion)
+	r.Group(func(r chi.Router) {
+		r.Use(legacyAlias, deprecated(legacyPaths))
+		apiVersions[legacyAPIVersion](r)
+	})
+}
+
+func versionHeader(version string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("API-Version", version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func legacyAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", legacyAPIVersion)
+		w.Header().Add("Link", `<`+apiPrefix(legacyAPIVersion)+r.URL.Path+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unsupportedVersion answers unknown paths, listing the supported versions
+// when the path asks for one that does not exist. Paths outside /api/ go to
+// the single page app.
+func unsupportedVersion(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/api/")
+	version, _, _ := strings.Cut(rest, "/")
+	if !ok {
+		spaFallback(w, r)
+		return
+	}
+	if _, known := apiVersions[version]; known {
+		routeNotFound(w, r)
+		return
+	}
+	supported := []string{}
+	for version := range apiVersions {
+		supported = append(supported, version)
+	}
+	sort.Strings(supported)
+	writeProblem(w, r, http.StatusNotFound, "Unsupported API version", renderer.M{
+		"version":   version,
+		"supported": supported,
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// workflowStatus is one column of the board. Todos in a Done status are
+// reported as completed to clients that only know about is_completed.
+type workflowStatus struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Done bool   `json:"done"`
+}
+
+// workflowConfig describes the statuses a todo moves through and which moves
+// are allowed. It can be replaced with a JSON document in TODO_WORKFLOW.
+type workflowConfig struct {
+	Statuses    []workflowStatus    `json:"statuses"`
+	Initial     string              `json:"initial"`
+	Transitions map[string][]string `json:"transitions"`
+}
+
+var defaultWorkflow = workflowConfig{
+	Statuses: []workflowStatus{
+		{Key: "backlog", Name: "Backlog"},
+		{Key: "doing", Name: "Doing"},
+		{Key: "done", Name: "Done", Done: true},
+	},
+	Initial: "backlog",
+	Transitions: map[string][]string{
+		"backlog": {"doing", "done"},
+		"doing":   {"backlog", "done"},
+		"done":    {"doing", "backlog"},
+	},
+}
+
+var workflow = loadWorkflow()
+
+func loadWorkflow() workflowConfig {
+	raw := env("TODO_WORKFLOW", "")
+	if raw == "" {
+		return defaultWorkflow
+	}
+	var wf workflowConfig
+	if err := json.Unmarshal([]byte(raw), &wf); err != nil {
+		log.Fatalf("TODO_WORKFLOW: %s", err)
+	}
+	if err := wf.check(); err != nil {
+		log.Fatalf("TODO_WORKFLOW: %s", err)
+	}
+	return wf
+}
+
+func (wf workflowConfig) check() error {
+	if wf.status(wf.Initial) == nil {
+		return fmt.Errorf("initial status %q is not defined", wf.Initial)
+	}
+	if wf.completeStatus() == "" {
+		return errors.New("at least one status must be done")
+	}
+	for from, targets := range wf.Transitions {
+		for _, to := range append(targets, from) {
+			if wf.status(to) == nil {
+				return fmt.Errorf("transition uses undefined status %q", to)
+			}
+		}
+	}
+	return nil
+}
+
+func (wf workflowConfig) status(key string) *workflowStatus {
+	for i := range wf.Statuses {
+		if wf.Statuses[i].Key == key {
+			return &wf.Statuses[i]
+		}
+	}
+	return nil
+}
+
+// completeStatus is where legacy clients land when they set is_completed.
+func (wf workflowConfig) completeStatus() string {
+	for _, s := range wf.Statuses {
+		if s.Done {
+			return s.Key
+		}
+	}
+	return ""
+}
+
+func (wf workflowConfig) canMove(from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, allowed := range wf.Transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// statusOf returns the todo's status, deriving it from is_completed for
+// documents written before statuses existed.
+func statusOf(t todoModel) string {
+	if t.Status != "" {
+		return t.Status
+	}
+	if t.IsCompleted {
+		return workflow.completeStatus()
+	}
+	return workflow.Initial
+}
+
+// completionTarget returns the status a legacy client moves a todo in
+// status from to by asking for it to be done or not, and whether that
+// changes anything. A todo already in the state asked for keeps its
+// status, so "doing" is not reset by a client resending is_completed.
+func completionTarget(from string, done bool) (string, bool) {
+	if s := workflow.status(from); s != nil && s.Done == done {
+		return from, false
+	}
+	if done {
+		return workflow.completeStatus(), true
+	}
+	return workflow.Initial, true
+}
+
+// statusUpdate returns the fields to $set when a todo moves to status.
+// is_completed and completed_at are kept in step so existing queries and
+// clients see the same state.
+func statusUpdate(status string, now time.Time) bson.D {
+	done := workflow.status(status).Done
+	var completedAt *time.Time
+	if done {
+		completedAt = &now
+	}
+	return bson.D{
+		{Key: "status", Value: status},
+		{Key: "iscompleted", Value: done},
+		{Key: "completed_at", Value: completedAt},
+	}
+}
+
+// toggleCompleted flips a todo between the initial and done statuses, the
+// same move legacy clients make by setting is_completed. Like any other
+// move, it has to be allowed by the workflow.
+func toggleCompleted(w http.ResponseWriter, r *http.Request) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id, err := parseTodoID(chi.URLParam(r, "id"))
+	if err != nil {
+		rnd.JSON(w, http.StatusBadRequest, withMessage(r, "bad_request", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	var current todoModel
+	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rnd.JSON(w, http.StatusNotFound, withMessage(r, "todo_not_found", nil))
+		return
+	}
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "update_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	from := statusOf(current)
+	s := workflow.status(from)
+	status, _ := completionTarget(from, s == nil || !s.Done)
+	if !workflow.canMove(from, status) {
+		rnd.JSON(w, http.StatusConflict, withMessage(r, "transition_not_allowed", renderer.M{
+			"from": from,
+			"to":   status,
+		}))
+		return
+	}
+	now := time.Now()
+	update, cascaded := completionUpdate(status, now, cascadeRequested(r), current.Checklist, nil)
+	update = append(update, bson.E{Key: "updatedat", Value: now})
+	var t todoModel
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&t)
+	if err != nil {
+		rnd.JSON(w, http.StatusInternalServerError, withMessage(r, "update_failed", renderer.M{
+			"error": err.Error(),
+		}))
+		return
+	}
+	go blockerChanged(id, false)
+	go todoUpdated(current)
+	rnd.JSON(w, http.StatusOK, withMessage(r, "updated", renderer.M{
+		"data":     newTodo(t),
+		"cascaded": cascaded,
+	}))
+}
+
+func getWorkflow(w http.ResponseWriter, r *http.Request) {
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": workflow,
+	})
+}
+
+// migrateStatusesCommand backfills status on todos created before the
+// workflow existed. It is safe to run repeatedly.
+func migrateStatusesCommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	missing := bson.M{"status": bson.M{"$exists": false}}
+	done, err := collection.UpdateMany(ctx, bson.M{"$and": bson.A{missing, bson.M{"iscompleted": true}}},
+		bson.M{"$set": bson.M{"status": workflow.completeStatus()}})
+	if err != nil {
+		return err
+	}
+	open, err := collection.UpdateMany(ctx, missing, bson.M{"$set": bson.M{"status": workflow.Initial}})
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %d completed and %d open todos\n", done.ModifiedCount, open.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestCompletionTarget(t *testing.T) {
+	for _, c := range []struct {
+		from    string
+		done    bool
+		want    string
+		changed bool
+	}{
+		{"doing", false, "doing", false},
+		{"backlog", false, "backlog", false},
+		{"done", true, "done", false},
+		{"doing", true, "done", true},
+		{"done", false, "backlog", true},
+	} {
+		got, changed := completionTarget(c.from, c.done)
+		if got != c.want || changed != c.changed {
+			t.Errorf("completionTarget(%q, %v) = %q, %v, want %q, %v", c.from, c.done, got, changed, c.want, c.changed)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Todos" sheetId="1" r:id="rId1"/><sheet name="Summary" sheetId="2" r:id="rId2"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/></Relationships>`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+// exportXLSX writes a workbook with a "Todos" sheet, streamed row by row from
+// the cursor, followed by a "Summary" sheet of the counts gathered on the way.
+func exportXLSX(ctx context.Context, w http.ResponseWriter, cur *mongo.Cursor) error {
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename("xlsx")))
+	zw := zip.NewWriter(w)
+	for _, part := range []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(sheet, xlsxSheetHeader); err != nil {
+		return err
+	}
+	if err := writeXLSXRow(sheet, 1, "Title", "Status", "Due date", "Tags", "List"); err != nil {
+		return err
+	}
+	var total, completed, overdue int
+	perList := map[string]int{}
+	now := time.Now()
+	row := 2
+	for cur.Next(ctx) {
+		var t todoModel
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		status := "Open"
+		if t.IsCompleted {
+			status = "Completed"
+			completed++
+		}
+		due := ""
+		if t.DueAt != nil {
+			due = t.DueAt.Format("2006-01-02 15:04")
+			if !t.IsCompleted && t.DueAt.Before(now) {
+				overdue++
+			}
+		}
+		total++
+		perList[t.List]++
+		if err := writeXLSXRow(sheet, row, t.Title, status, due, strings.Join(t.Tags, ", "), t.List); err != nil {
+			return err
+		}
+		row++
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(sheet, xlsxSheetFooter); err != nil {
+		return err
+	}
+
+	summary, err := zw.Create("xl/worksheets/sheet2.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(summary, xlsxSheetHeader); err != nil {
+		return err
+	}
+	rows := [][]interface{}{
+		{"Metric", "Count"},
+		{"Total", total},
+		{"Open", total - completed},
+		{"Completed", completed},
+		{"Overdue", overdue},
+	}
+	lists := make([]string, 0, len(perList))
+	for list := range perList {
+		lists = append(lists, list)
+	}
+	sort.Strings(lists)
+	for _, list := range lists {
+		name := list
+		if name == "" {
+			name = "(no list)"
+		}
+		rows = append(rows, []interface{}{"List: " + name, perList[list]})
+	}
+	for i, cells := range rows {
+		if err := writeXLSXRow(summary, i+1, cells...); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(summary, xlsxSheetFooter); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeXLSXRow writes a row of at most 26 cells. Strings are stored inline and
+// ints as numbers.
+func writeXLSXRow(w io.Writer, row int, cells ...interface{}) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<row r="%d">`, row)
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case int:
+			fmt.Fprintf(&b, `<c r="%c%d"><v>%d</v></c>`, 'A'+i, row, v)
+		case string:
+			if v == "" {
+				continue
+			}
+			fmt.Fprintf(&b, `<c r="%c%d" t="inlineStr"><is><t xml:space="preserve">`, 'A'+i, row)
+			if err := xml.EscapeText(&b, []byte(v)); err != nil {
+				return err
+			}
+			b.WriteString(`</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := w.Write(b.Bytes())
+	return err
+}